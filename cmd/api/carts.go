@@ -0,0 +1,296 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"misarfeh.com/internal/data"
+	"misarfeh.com/internal/validator"
+)
+
+// The getCart() helper returns the cart of the user with the discount of its coupon
+// applied.
+func (app *application) getCart(userID int64) (*data.Cart, error) {
+	cart, err := app.models.Carts.Get(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if cart.CouponID == nil {
+		return cart, nil
+	}
+
+	coupon, err := app.models.Coupons.Get(*cart.CouponID)
+	if err != nil {
+		return nil, err
+	}
+
+	cart.ApplyCoupon(coupon, time.Now())
+
+	return cart, nil
+}
+
+func (app *application) showCartHandler(w http.ResponseWriter, r *http.Request) {
+	cart, err := app.getCart(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"cart": cart}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) addCartItemHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ProductID int64 `json:"product_id"`
+		VariantID int64 `json:"variant_id"`
+		Quantity  int32 `json:"quantity"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.ProductID > 0, "product_id", "must be provided")
+
+	if data.ValidateCartItemQuantity(v, input.Quantity); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Products with variants can only be bought as one of their variants.
+	variants, err := app.models.Variants.GetAllForProduct(input.ProductID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if len(variants) > 0 {
+		v.Check(input.VariantID != 0, "variant_id", "must be provided for a product with variants")
+	} else {
+		v.Check(input.VariantID == 0, "variant_id", "the product has no variants")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.Carts.AddItem(user.ID, input.ProductID, input.VariantID, input.Quantity)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("product_id", "no matching product or variant found")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrInvalidQuantity):
+			v.AddError("quantity", "must not be more than 100 in total")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	cart, err := app.getCart(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"cart": cart}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The updateCartItemHandler() changes the quantity of a cart item. Sending
+// accept_price=true replaces the price snapshot of the item with the current price of
+// the product, which clears its price_changed flag.
+func (app *application) updateCartItemHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	item, err := app.models.Carts.GetItem(user.ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Quantity    *int32 `json:"quantity"`
+		AcceptPrice bool   `json:"accept_price"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Quantity != nil {
+		item.Quantity = *input.Quantity
+	}
+
+	v := validator.New()
+
+	if data.ValidateCartItemQuantity(v, item.Quantity); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Carts.UpdateItem(user.ID, item.ID, item.Quantity, input.AcceptPrice)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	cart, err := app.getCart(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"cart": cart}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteCartItemHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Carts.DeleteItem(app.contextGetUser(r).ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "cart item succesfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The applyCartCouponHandler() applies a coupon to the cart of the user by its code.
+// The coupon has to belong to a shop with items in the cart, its other conditions are
+// shown on the cart and checked again when the order is placed.
+func (app *application) applyCartCouponHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.Code != "", "code", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	coupon, err := app.models.Coupons.GetByCode(input.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("code", "no matching coupon found")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	cart, err := app.models.Carts.Get(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	inCart := false
+	for _, shop := range cart.Shops {
+		inCart = inCart || shop.ShopID == coupon.ShopID
+	}
+
+	if v.Check(inCart, "code", "the coupon is for a shop which has no items in the cart"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Carts.SetCoupon(user.ID, &coupon.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	cart, err = app.getCart(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"cart": cart}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) removeCartCouponHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	err := app.models.Carts.SetCoupon(user.ID, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	cart, err := app.getCart(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"cart": cart}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
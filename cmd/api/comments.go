@@ -1,9 +1,9 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
-	"time"
 
 	"misarfeh.com/internal/data"
 	"misarfeh.com/internal/validator"
@@ -11,10 +11,9 @@ import (
 
 func (app *application) createCommentHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Text     string `json:"text"`
-		Phone    string `json:"phone"`
-		Username string `json:"username"`
-		Rate     int8   `json:"rate"`
+		ProductID int64  `json:"product_id"`
+		Text      string `json:"text"`
+		Rate      int8   `json:"rate"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -23,11 +22,14 @@ func (app *application) createCommentHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	user := app.contextGetUser(r)
+
 	comment := &data.Comment{
-		Text:     input.Text,
-		Phone:    input.Phone,
-		Username: input.Username,
-		Rate:     input.Rate,
+		ProductID: input.ProductID,
+		UserID:    user.ID,
+		Username:  user.FirstName + " " + user.LastName,
+		Text:      input.Text,
+		Rate:      input.Rate,
 	}
 
 	v := validator.New()
@@ -37,7 +39,40 @@ func (app *application) createCommentHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	fmt.Fprintf(w, "%+v\n", input)
+	_, err = app.models.Products.Get(comment.ProductID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("product_id", "no matching product found")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Comments.Insert(comment)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("product_id", "no matching product found")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateComment):
+			v.AddError("product_id", "you have already commented on this product")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/product/comments/%d", comment.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"comment": comment}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
 
 func (app *application) showCommentHandler(w http.ResponseWriter, r *http.Request) {
@@ -47,13 +82,15 @@ func (app *application) showCommentHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	comment := data.Comment{
-		ID:        id,
-		CreatedAt: time.Now(),
-		Text:      "Good stuf",
-		Phone:     "09379137805",
-		Username:  "matin_nhd",
-		Rate:      4,
+	comment, err := app.models.Comments.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
 	}
 
 	err = app.writeJSON(w, http.StatusOK, envelope{"comment": comment}, nil)
@@ -63,25 +100,156 @@ func (app *application) showCommentHandler(w http.ResponseWriter, r *http.Reques
 }
 
 func (app *application) listCommentHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprintln(w, "list all comments")
+	var input struct {
+		ProductID int
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.ProductID = app.readInt(qs, "product_id", 0, v)
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.Filters.Sort = app.readString(qs, "sort", "-id")
+	input.Filters.SortSafelist = []string{"id", "rate", "-id", "-rate"}
+
+	v.Check(input.ProductID >= 0, "product_id", "must not be negative")
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	comments, metadata, err := app.models.Comments.GetAll(int64(input.ProductID), input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"comments": comments, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
 
-func (app *application) EditeCommentHandler(w http.ResponseWriter, r *http.Request) {
+func (app *application) updateCommentHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
 	if err != nil {
-		http.NotFound(w, r)
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	comment, err := app.models.Comments.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if comment.UserID != app.contextGetUser(r).ID {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Text *string `json:"text"`
+		Rate *int8   `json:"rate"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Text != nil {
+		comment.Text = *input.Text
+	}
+
+	if input.Rate != nil {
+		comment.Rate = *input.Rate
+	}
+
+	v := validator.New()
+
+	if data.ValidateComment(v, comment); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Comments.Update(comment)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
-	fmt.Fprintf(w, "Edite comment %d\n", id)
+	err = app.writeJSON(w, http.StatusOK, envelope{"comment": comment}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
 
+// The deleteCommentHandler() lets users delete their own comments, and moderators
+// delete anyone's.
 func (app *application) deleteCommentHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
 	if err != nil {
-		http.NotFound(w, r)
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	comment, err := app.models.Comments.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
-	fmt.Fprintf(w, "delete comment %d\n", id)
+	user := app.contextGetUser(r)
+
+	if comment.UserID != user.ID {
+		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !permissions.Include(data.PermissionCommentsModerate) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+	}
+
+	err = app.models.Comments.Delete(comment.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "comment succesfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
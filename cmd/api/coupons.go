@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"misarfeh.com/internal/data"
+	"misarfeh.com/internal/validator"
+)
+
+func (app *application) listCouponsHandler(w http.ResponseWriter, r *http.Request) {
+	shopID, ok := app.requireShopOwner(w, r)
+	if !ok {
+		return
+	}
+
+	coupons, err := app.models.Coupons.GetAllForShop(shopID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"coupons": coupons}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createCouponHandler(w http.ResponseWriter, r *http.Request) {
+	shopID, ok := app.requireShopOwner(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Code           string    `json:"code"`
+		Kind           string    `json:"kind"`
+		Value          int64     `json:"value"`
+		MinOrderTotal  int64     `json:"min_order_total"`
+		MaxUses        *int32    `json:"max_uses"`
+		MaxUsesPerUser *int32    `json:"max_uses_per_user"`
+		StartsAt       time.Time `json:"starts_at"`
+		EndsAt         time.Time `json:"ends_at"`
+		Scope          string    `json:"scope"`
+		CategoryID     *int64    `json:"category_id"`
+		ProductID      *int64    `json:"product_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	coupon := &data.Coupon{
+		ShopID:         shopID,
+		Code:           strings.ToUpper(input.Code),
+		Kind:           input.Kind,
+		Value:          input.Value,
+		MinOrderTotal:  input.MinOrderTotal,
+		MaxUses:        input.MaxUses,
+		MaxUsesPerUser: input.MaxUsesPerUser,
+		StartsAt:       input.StartsAt,
+		EndsAt:         input.EndsAt,
+		Scope:          input.Scope,
+		CategoryID:     input.CategoryID,
+		ProductID:      input.ProductID,
+	}
+
+	if coupon.Scope == "" {
+		coupon.Scope = data.ScopeShop
+	}
+
+	v := validator.New()
+
+	if data.ValidateCoupon(v, coupon); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if !app.validScopeTarget(w, r, v, shopID, coupon.CategoryID, coupon.ProductID) {
+		return
+	}
+
+	err = app.models.Coupons.Insert(coupon)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateCouponCode):
+			v.AddError("code", "a coupon with this code already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/shops/%d/coupons/%d", shopID, coupon.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"coupon": coupon}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The validScopeTarget() helper checks that the category of a coupon or promotion
+// exists and that its product is sold by the shop. It sends the validation error and
+// returns false otherwise.
+func (app *application) validScopeTarget(w http.ResponseWriter, r *http.Request, v *validator.Validator, shopID int64, categoryID, productID *int64) bool {
+	if categoryID != nil {
+		_, err := app.models.Categories.Get(*categoryID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError("category_id", "no matching category found")
+			default:
+				app.serverErrorResponse(w, r, err)
+				return false
+			}
+		}
+	}
+
+	if productID != nil {
+		product, err := app.models.Products.Get(*productID)
+		if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+			app.serverErrorResponse(w, r, err)
+			return false
+		}
+
+		v.Check(err == nil && product.ShopID == shopID, "product_id", "no matching product found in the shop")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+
+	return true
+}
+
+func (app *application) deleteCouponHandler(w http.ResponseWriter, r *http.Request) {
+	shopID, ok := app.requireShopOwner(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := app.readInt64Param(r, "coupon_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Coupons.Delete(shopID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "coupon successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -50,3 +50,70 @@ func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http
 	message := "rate limit exceeded"
 	app.errorResponse(w, r, http.StatusTooManyRequests, message)
 }
+
+func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid authentication credentials"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+
+	message := "invalid or missing authentication token"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "you must be authenticated to access this resource"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) sellerRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account must be a seller account to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account doesn't have the necessary permissions to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
+	message := "unable to update the record due to an edit conflict, please try again"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) tooManyAttemptsResponse(w http.ResponseWriter, r *http.Request) {
+	message := "too many attempts, please try again later"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
+func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account must be activated to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) categoryInUseResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the category still has products, coupons or promotions, move them to the parent category with move_to_parent=true or reassign them first"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) shopHasOrdersResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the shop has orders and can't be deleted"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the record has been modified since you last fetched it, please fetch it again"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, message)
+}
+
+func (app *application) paymentFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the payment was not completed, please try again"
+	app.errorResponse(w, r, http.StatusPaymentRequired, message)
+}
+
+func (app *application) paymentRefundDueResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the order can no longer be paid, the payment will be refunded"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
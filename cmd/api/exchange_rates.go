@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"misarfeh.com/internal/data"
+	"misarfeh.com/internal/validator"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func (app *application) listExchangeRatesHandler(w http.ResponseWriter, r *http.Request) {
+	rates, err := app.models.ExchangeRates.GetAllForSeller(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"exchange_rates": rates}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The updateExchangeRateHandler() sets the rate of the seller for a currency, and
+// reprices all products of the seller with an original price in that currency.
+func (app *application) updateExchangeRateHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Rate int64 `json:"rate"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	rate := &data.ExchangeRate{
+		SellerID: app.contextGetUser(r).ID,
+		Currency: strings.ToUpper(httprouter.ParamsFromContext(r.Context()).ByName("currency")),
+		Rate:     input.Rate,
+	}
+
+	v := validator.New()
+
+	if data.ValidateExchangeRate(v, rate); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var repriced int64
+
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		err := tx.ExchangeRates.Upsert(rate)
+		if err != nil {
+			return err
+		}
+
+		repriced, err = tx.Products.RepriceForSeller(rate.SellerID, rate.Currency, rate.Rate)
+		return err
+	})
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"exchange_rate": rate, "repriced_products": repriced}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteExchangeRateHandler(w http.ResponseWriter, r *http.Request) {
+	currency := strings.ToUpper(httprouter.ParamsFromContext(r.Context()).ByName("currency"))
+
+	err := app.models.ExchangeRates.Delete(app.contextGetUser(r).ID, currency)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "exchange rate succesfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
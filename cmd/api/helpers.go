@@ -9,6 +9,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"misarfeh.com/internal/validator"
 
@@ -18,11 +19,17 @@ import (
 type envelope map[string]interface{}
 
 func (app *application) readIDParam(r *http.Request) (int64, error) {
+	return app.readInt64Param(r, "id")
+}
+
+// The readInt64Param() helper reads a positive integer URL parameter by name, for
+// routes which have more than one id in their path.
+func (app *application) readInt64Param(r *http.Request, name string) (int64, error) {
 	params := httprouter.ParamsFromContext(r.Context())
 
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	id, err := strconv.ParseInt(params.ByName(name), 10, 64)
 	if err != nil || id < 1 {
-		return 0, errors.New("invalid id parameter")
+		return 0, fmt.Errorf("invalid %s parameter", name)
 	}
 
 	return id, nil
@@ -157,3 +164,83 @@ func (app *application) readBool(qs url.Values, key string, defaultValue bool) b
 
 	return true
 }
+
+// The readBearerToken() helper extracts the plaintext token from an
+// "Authorization: Bearer <token>" header. It returns an empty string if the header is
+// missing, and an error if the header is present but malformed.
+func (app *application) readBearerToken(r *http.Request) (string, error) {
+	authorizationHeader := r.Header.Get("Authorization")
+
+	if authorizationHeader == "" {
+		return "", nil
+	}
+
+	headerParts := strings.Split(authorizationHeader, " ")
+	if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+		return "", errors.New("invalid authorization header")
+	}
+
+	return headerParts[1], nil
+}
+
+// The background() helper runs fn in a goroutine which is tracked by the application
+// wait group, so the server waits for it on shutdown. Panics are recovered and logged.
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.PrintError(fmt.Errorf("%s", err), nil)
+			}
+		}()
+
+		fn()
+	}()
+}
+
+// The every() helper runs fn in the background once every interval until the server
+// shuts down. Errors returned by fn are logged and don't stop the job.
+func (app *application) every(interval time.Duration, fn func() error) {
+	app.background(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				err := fn()
+				if err != nil {
+					app.logger.PrintError(err, nil)
+				}
+			case <-app.stop:
+				return
+			}
+		}
+	})
+}
+
+// The etag() helper formats the version of a record as a strong ETag value.
+func etag(version int) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// The ifMatch() helper reports whether the If-Match header of the request, if there is
+// one, matches the version of the record. A missing header or "*" always matches.
+func (app *application) ifMatch(r *http.Request, version int) bool {
+	header := r.Header.Get("If-Match")
+
+	if header == "" || strings.TrimSpace(header) == "*" {
+		return true
+	}
+
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimSpace(tag) == etag(version) {
+			return true
+		}
+	}
+
+	return false
+}
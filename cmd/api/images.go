@@ -1,13 +1,21 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
-	"path/filepath"
+	"strconv"
 	"strings"
-	"time"
+
+	"misarfeh.com/internal/data"
+	"misarfeh.com/internal/imaging"
+	"misarfeh.com/internal/storage"
+	"misarfeh.com/internal/validator"
+
+	"github.com/julienschmidt/httprouter"
 )
 
 const BULK_FILE_SIZE = 32 << 20
@@ -26,7 +34,10 @@ func (app *application) uploadImagesHandler(w http.ResponseWriter, r *http.Reque
 
 	var errNew string
 
-	diskFiles := make([]*os.File, 0)
+	hashes := make([]string, 0)
+	processed := make([][]*imaging.Variant, 0)
+
+	user := app.contextGetUser(r)
 
 	for _, fileHeader := range files {
 		// Open the file
@@ -38,65 +49,444 @@ func (app *application) uploadImagesHandler(w http.ResponseWriter, r *http.Reque
 
 		defer file.Close()
 
-		buff := make([]byte, 512)
-		_, err = file.Read(buff)
+		buf, err := io.ReadAll(file)
 		if err != nil {
 			errNew = err.Error()
 			continue
 		}
 
-		// checking the content type
-		// so we don't allow files other than images
-		filetype := http.DetectContentType(buff)
-		if filetype != "image/jpeg" && filetype != "image/png" && filetype != "image/jpg" {
-			errNew = fmt.Sprintf("The %s file format is not allowed. Please upload a JPEG,JPG or PNG image", fileHeader.Filename)
+		variants, err := processUpload(fileHeader.Filename, buf)
+		if err != nil {
+			errNew = err.Error()
 			continue
 		}
 
-		_, err = file.Seek(0, io.SeekStart)
+		hashes = append(hashes, storage.Hash(buf))
+		processed = append(processed, variants)
+	}
+
+	if errNew != "" {
+		app.badRequestResponse(w, r, fmt.Errorf(errNew))
+		return
+	}
+
+	// The uploads are pending until a shop or product is saved with their URLs, pending
+	// images which are never used are deleted by the deletePendingImages() job.
+	uploaded := make([]*data.Image, 0, len(hashes))
+	created := make([]*data.Image, 0, len(hashes))
+
+	for i, hash := range hashes {
+		image, isNew, err := app.storeUpload(r.Context(), user.ID, hash, processed[i])
 		if err != nil {
-			errNew = err.Error()
-			continue
+			// Undo the uploads of the request, so a failed request leaves nothing
+			// behind.
+			for _, image := range created {
+				keys, err := app.models.Images.Delete(image.ID)
+				if err != nil {
+					app.logger.PrintError(err, nil)
+					continue
+				}
+
+				app.releaseStoredFiles(keys)
+			}
+
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		uploaded = append(uploaded, image)
+
+		if isNew {
+			created = append(created, image)
+		}
+	}
+
+	resp := map[string]string{}
+	images := map[string]*data.Image{}
+
+	for i, image := range uploaded {
+		resp[fmt.Sprint(i+1)] = image.Url
+		images[fmt.Sprint(i+1)] = image
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"img_urls": resp, "images": images}, nil)
+}
+
+// The serveImageHandler() serves an image from the storage. Range and conditional
+// requests are supported when the storage returns a seekable body.
+func (app *application) serveImageHandler(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	key := strings.TrimPrefix(params.ByName("filepath"), "/")
+	if !storage.ValidKey(key) {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	object, err := app.storage.Get(r.Context(), key)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	defer object.Body.Close()
+
+	w.Header().Set("Content-Type", object.ContentType)
+
+	if body, ok := object.Body.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, key, object.ModTime, body)
+		return
+	}
+
+	if object.Size >= 0 {
+		w.Header().Set("Content-Length", fmt.Sprint(object.Size))
+	}
+
+	io.Copy(w, object.Body)
+}
+
+// The processUpload() helper checks that the uploaded file is a JPEG or PNG image,
+// strips its metadata and scales it to every size. The returned error is meant for
+// the uploader.
+func processUpload(filename string, buf []byte) ([]*imaging.Variant, error) {
+	// checking the content type
+	// so we don't allow files other than images
+	filetype := http.DetectContentType(buf)
+	if filetype != "image/jpeg" && filetype != "image/png" && filetype != "image/jpg" {
+		return nil, fmt.Errorf("The %s file format is not allowed. Please upload a JPEG,JPG or PNG image", filename)
+	}
+
+	variants, err := imaging.Process(buf)
+	if err != nil {
+		switch {
+		case errors.Is(err, imaging.ErrTooLarge):
+			return nil, fmt.Errorf("The %s image is too large. Please upload an image of less than %d pixels", filename, imaging.MaxPixels)
+		default:
+			return nil, fmt.Errorf("The %s image could not be read. Please upload a valid JPEG,JPG or PNG image", filename)
 		}
+	}
+
+	return variants, nil
+}
+
+// The storeUpload() helper saves the processed upload with the hash as a pending image
+// of the user. Its files are stored under the hash of their content, so uploading the
+// same file again stores nothing: the pending image of the user is returned if there
+// is one, otherwise a new image sharing the files is inserted. It reports whether a
+// new image was inserted.
+func (app *application) storeUpload(ctx context.Context, userID int64, hash string, variants []*imaging.Variant) (*data.Image, bool, error) {
+	var image *data.Image
+	var isNew bool
+	var stored []string
 
-		err = os.MkdirAll("./uploads", os.ModePerm)
+	err := app.models.WithTx(ctx, func(tx data.Models) error {
+		// Identical uploads are saved one at a time.
+		err := tx.Images.Lock(hash)
 		if err != nil {
-			errNew = err.Error()
-			continue
+			return err
+		}
+
+		existing, err := tx.Images.GetByHash(hash, userID)
+		if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+			return err
+		}
+
+		if existing != nil {
+			pending := existing.ProductID == nil && existing.ShopID == nil
+			if pending && existing.UserID != nil && *existing.UserID == userID {
+				image = existing
+				return nil
+			}
+
+			err = tx.Images.Lock(existing.StorageKeys...)
+			if err != nil {
+				return err
+			}
+
+			referenced, err := tx.Images.Referenced(existing.StorageKeys...)
+			if err != nil {
+				return err
+			}
+
+			if referenced {
+				image = &data.Image{
+					UserID:      &userID,
+					Url:         existing.Url,
+					Hash:        hash,
+					Variants:    existing.Variants,
+					StorageKeys: existing.StorageKeys,
+				}
+
+				isNew = true
+
+				return tx.Images.Insert(image)
+			}
+		}
+
+		image = &data.Image{
+			UserID:   &userID,
+			Hash:     hash,
+			Variants: data.ImageVariants{},
 		}
 
-		f, err := os.Create(fmt.Sprintf("./uploads/%d%s", time.Now().UnixNano(), filepath.Ext(fileHeader.Filename)))
+		keys := make([]string, len(variants))
+
+		for i, variant := range variants {
+			keys[i] = storage.ContentKey(variant.Data, variant.Extension())
+		}
+
+		err = tx.Images.Lock(keys...)
 		if err != nil {
-			errNew = err.Error()
-			continue
+			return err
 		}
 
-		defer f.Close()
+		for i, variant := range variants {
+			err = app.storage.Put(ctx, keys[i], bytes.NewReader(variant.Data), int64(len(variant.Data)), variant.ContentType())
+			if err != nil {
+				return err
+			}
 
-		diskFiles = append(diskFiles, f)
+			stored = append(stored, keys[i])
+
+			url := app.storage.URL(keys[i])
+
+			if image.Variants[variant.Size] == nil {
+				image.Variants[variant.Size] = map[string]string{}
+			}
+
+			image.Variants[variant.Size][variant.Format] = url
+
+			if variant.Size == "full" && variant.Format == imaging.FormatJPEG {
+				image.Url = url
+			}
+		}
+
+		image.StorageKeys = keys
+		isNew = true
+
+		return tx.Images.Insert(image)
+	})
+	if err != nil {
+		app.releaseStoredFiles(stored)
+		return nil, false, err
+	}
+
+	return image, isNew, nil
+}
 
-		_, err = io.Copy(f, file)
+// The releaseStoredFiles() helper deletes the files from the storage unless an image
+// still references them. Failures are only logged, a file which couldn't be deleted
+// is reported by the verify-images command.
+func (app *application) releaseStoredFiles(keys []string) {
+	for _, key := range keys {
+		err := app.models.WithTx(context.Background(), func(tx data.Models) error {
+			err := tx.Images.Lock(key)
+			if err != nil {
+				return err
+			}
+
+			referenced, err := tx.Images.Referenced(key)
+			if err != nil || referenced {
+				return err
+			}
+
+			return app.storage.Delete(context.Background(), key)
+		})
 		if err != nil {
-			errNew = err.Error()
-			continue
+			app.logger.PrintError(err, map[string]string{"key": key})
 		}
 	}
+}
+
+// The deleteImageHandler() deletes an image and its files. Uploaders can delete their
+// pending images, and sellers the images of their shops and products. The last image
+// of a product can't be deleted, since products must have at least one.
+func (app *application) deleteImageHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
 
-	if errNew != "" {
-		for _, file := range diskFiles {
-			os.Remove(file.Name())
+	image, err := app.models.Images.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
 		}
-		app.badRequestResponse(w, r, fmt.Errorf(errNew))
 		return
 	}
 
-	serverUrl := fmt.Sprintf("localhost:%d/v1/images/", app.config.port)
+	allowed, err := app.canManageImage(r, image)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 
-	resp := map[string]string{}
+	if !allowed {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	if image.ProductID != nil {
+		images, err := app.models.Images.GetAll(0, *image.ProductID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if len(images) <= 1 {
+			v := validator.New()
+			v.AddError("image", "the last image of a product can't be deleted")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+	}
+
+	keys, err := app.models.Images.Delete(image.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.releaseStoredFiles(keys)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "image successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The canManageImage() helper reports whether the authenticated user can delete the
+// image: attached images belong to the owner of their shop, pending ones to their
+// uploader.
+func (app *application) canManageImage(r *http.Request, image *data.Image) (bool, error) {
+	switch {
+	case image.ShopID != nil:
+		return app.ownsShop(r, *image.ShopID)
+	case image.ProductID != nil:
+		product, err := app.models.Products.Get(*image.ProductID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				return false, nil
+			default:
+				return false, err
+			}
+		}
+
+		return app.ownsShop(r, product.ShopID)
+	default:
+		return image.UserID != nil && *image.UserID == app.contextGetUser(r).ID, nil
+	}
+}
 
-	for i, file := range diskFiles {
-		resp[fmt.Sprint(i+1)] = serverUrl + strings.Split(file.Name(), "/")[2]
+// The reorderProductImagesHandler() sets the order of the images of a product. The
+// first image is the cover of the product.
+func (app *application) reorderProductImagesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
 	}
 
-	app.writeJSON(w, http.StatusOK, envelope{"img_urls": resp}, nil)
+	product, err := app.models.Products.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	owner, err := app.ownsShop(r, product.ShopID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !owner {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		ImageIDs []int64 `json:"image_ids"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(len(input.ImageIDs) >= 1, "image_ids", "must contain at least 1 image")
+	v.Check(validator.Unique(input.ImageIDs), "image_ids", "must not contain duplicate values")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Images.Reorder(product.ID, input.ImageIDs)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("image_ids", "must contain every image of the product exactly once")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	images, err := app.models.Images.GetAll(0, product.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"images": images}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deletePendingImages() job deletes the images which were uploaded, or detached
+// from their product, and haven't been used within the pending TTL.
+func (app *application) deletePendingImages() error {
+	for {
+		keys, count, err := app.models.Images.DeletePending(app.config.images.pendingTTL, 500)
+		if err != nil {
+			return err
+		}
+
+		app.releaseStoredFiles(keys)
+
+		if count > 0 {
+			app.logger.PrintInfo("deleted pending images", map[string]string{
+				"count": strconv.FormatInt(count, 10),
+			})
+		}
+
+		if count < 500 {
+			return nil
+		}
+	}
 }
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"misarfeh.com/internal/data"
+	"misarfeh.com/internal/validator"
+)
+
+func (app *application) showSellerBalanceHandler(w http.ResponseWriter, r *http.Request) {
+	balance, err := app.models.Ledger.GetBalance(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"balance": balance}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listSellerLedgerHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.Filters.Sort = app.readString(qs, "sort", "-id")
+	input.Filters.SortSafelist = []string{"id", "amount", "-id", "-amount"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	entries, metadata, err := app.models.Ledger.GetAllForSeller(app.contextGetUser(r).ID, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"entries": entries, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The createPayoutBatchHandler() creates payouts for the available balance of every
+// seller and exports all pending payouts as CSV, ready to be transferred to the bank
+// cards of the sellers.
+func (app *application) createPayoutBatchHandler(w http.ResponseWriter, r *http.Request) {
+	_, err := app.models.Payouts.CreateBatch()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	payouts, err := app.models.Payouts.GetAllPending()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// The CSV is written to a buffer first, so a failure can still be reported with an
+	// error response.
+	var buf bytes.Buffer
+
+	cw := csv.NewWriter(&buf)
+
+	err = cw.Write([]string{"payout_id", "seller_id", "meli_code", "meli_cart_url", "amount", "created_at"})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	for _, payout := range payouts {
+		err = cw.Write([]string{
+			strconv.FormatInt(payout.ID, 10),
+			strconv.FormatInt(payout.SellerID, 10),
+			csvText(payout.MeliCode),
+			csvText(payout.MeliCartUrl),
+			strconv.FormatInt(payout.Amount, 10),
+			payout.CreatedAt.Format(time.RFC3339),
+		})
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	cw.Flush()
+
+	if err := cw.Error(); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	filename := fmt.Sprintf("payouts-%s.csv", time.Now().Format("20060102-150405"))
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.WriteHeader(http.StatusCreated)
+
+	_, err = w.Write(buf.Bytes())
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// The csvText() helper makes a value entered by a user safe to open in a spreadsheet.
+// Spreadsheets run cells starting with =, +, - or @ as formulas, so such values are
+// prefixed with a quote to be shown as text.
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+
+	return s
+}
+
+func (app *application) updatePayoutPaidHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Payouts.MarkPaid(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "payout succesfully marked as paid"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
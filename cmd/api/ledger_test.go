@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestCSVText(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"", ""},
+		{"Ali Rezaei", "Ali Rezaei"},
+		{"6037991234567890", "6037991234567890"},
+		{"=HYPERLINK(\"http://evil\")", "'=HYPERLINK(\"http://evil\")"},
+		{"+98912", "'+98912"},
+		{"-1+1", "'-1+1"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\t=1", "'\t=1"},
+		{"\r=1", "'\r=1"},
+		{"a=1", "a=1"},
+	}
+
+	for _, tt := range tests {
+		if got := csvText(tt.s); got != tt.want {
+			t.Errorf("csvText(%q) = %q; want %q", tt.s, got, tt.want)
+		}
+	}
+}
@@ -3,22 +3,31 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"flag"
+	"fmt"
 	"os"
+	"path/filepath"
+	"sync"
 	"time"
 
 	_ "github.com/lib/pq"
 
 	"misarfeh.com/internal/data"
 	"misarfeh.com/internal/jsonlog"
+	"misarfeh.com/internal/notify"
+	"misarfeh.com/internal/payment"
+	"misarfeh.com/internal/sms"
+	"misarfeh.com/internal/storage"
 )
 
 const version = "1.0.0"
 
 type config struct {
-	port int
-	env  string
-	db   struct {
+	port       int
+	env        string
+	commission int
+	db         struct {
 		dsn          string
 		maxOpenConns int
 		maxIdleConns int
@@ -29,12 +38,56 @@ type config struct {
 		burst   int
 		enabled bool
 	}
+	sms struct {
+		sender  string
+		logFile string
+		apiURL  string
+		apiKey  string
+		from    string
+	}
+	notifier struct {
+		sink    string
+		logFile string
+	}
+	payment struct {
+		gateway     string
+		merchantID  string
+		sandbox     bool
+		callbackURL string
+		timeout     time.Duration
+	}
+	images struct {
+		pendingTTL time.Duration
+	}
+	uploads struct {
+		dir string
+		ttl time.Duration
+	}
+	storage struct {
+		backend string
+		root    string
+		baseURL string
+		s3      struct {
+			endpoint  string
+			bucket    string
+			region    string
+			accessKey string
+			secretKey string
+			publicURL string
+		}
+	}
 }
 
 type application struct {
-	config config
-	logger *jsonlog.Logger
-	models data.Models
+	config   config
+	logger   *jsonlog.Logger
+	models   data.Models
+	sms      sms.Sender
+	notifier notify.Notifier
+	payment  payment.Gateway
+	storage  storage.Store
+	stop     chan struct{}
+	wg       sync.WaitGroup
 }
 
 func main() {
@@ -53,10 +106,45 @@ func main() {
 	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
 	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
 
+	flag.StringVar(&cfg.sms.sender, "sms-sender", "log", "SMS sender (log|http)")
+	flag.StringVar(&cfg.sms.logFile, "sms-log-file", "", "File the log SMS sender appends to (default stdout)")
+	flag.StringVar(&cfg.sms.apiURL, "sms-api-url", "", "SMS provider API URL")
+	flag.StringVar(&cfg.sms.apiKey, "sms-api-key", os.Getenv("ONLINESHOP_SMS_API_KEY"), "SMS provider API key")
+	flag.StringVar(&cfg.sms.from, "sms-from", "", "SMS sender line number")
+
+	flag.StringVar(&cfg.notifier.sink, "notifier", "log", "Notification sink (log|sms)")
+	flag.StringVar(&cfg.notifier.logFile, "notifier-log-file", "", "File the log notifier appends to (default stdout)")
+
+	flag.IntVar(&cfg.commission, "commission", 10, "Platform commission on delivered orders in percent")
+
+	flag.StringVar(&cfg.payment.gateway, "payment-gateway", "fake", "Payment gateway (fake|zarinpal)")
+	flag.StringVar(&cfg.payment.merchantID, "payment-merchant-id", os.Getenv("ONLINESHOP_PAYMENT_MERCHANT_ID"), "Payment gateway merchant id")
+	flag.BoolVar(&cfg.payment.sandbox, "payment-sandbox", false, "Use the sandbox of the payment gateway")
+	flag.StringVar(&cfg.payment.callbackURL, "payment-callback-url", "http://localhost:4000/v1/payments/callback", "URL the payment gateway redirects buyers back to")
+	flag.DurationVar(&cfg.payment.timeout, "payment-timeout", 30*time.Minute, "Time after which unpaid orders are cancelled")
+
+	flag.DurationVar(&cfg.images.pendingTTL, "images-pending-ttl", 24*time.Hour, "Time after which uploaded images which aren't used are deleted")
+	flag.StringVar(&cfg.uploads.dir, "uploads-dir", filepath.Join(os.TempDir(), "onlineshop-uploads"), "Directory the chunks of resumable uploads are kept in")
+	flag.DurationVar(&cfg.uploads.ttl, "uploads-ttl", 24*time.Hour, "Time after which resumable uploads are deleted, finished or not")
+
+	flag.StringVar(&cfg.storage.backend, "storage", "local", "Image storage (local|s3)")
+	flag.StringVar(&cfg.storage.root, "storage-root", "./uploads", "Directory the local storage keeps images in")
+	flag.StringVar(&cfg.storage.baseURL, "storage-base-url", "", "Public URL of the local storage (default http://localhost:<port>/v1/images)")
+	flag.StringVar(&cfg.storage.s3.endpoint, "s3-endpoint", "", "S3-compatible storage endpoint")
+	flag.StringVar(&cfg.storage.s3.bucket, "s3-bucket", "", "S3 bucket images are kept in")
+	flag.StringVar(&cfg.storage.s3.region, "s3-region", "us-east-1", "S3 region")
+	flag.StringVar(&cfg.storage.s3.accessKey, "s3-access-key", os.Getenv("ONLINESHOP_S3_ACCESS_KEY"), "S3 access key")
+	flag.StringVar(&cfg.storage.s3.secretKey, "s3-secret-key", os.Getenv("ONLINESHOP_S3_SECRET_KEY"), "S3 secret key")
+	flag.StringVar(&cfg.storage.s3.publicURL, "s3-public-url", "", "Public URL of the S3 bucket (default <s3-endpoint>/<s3-bucket>)")
+
 	flag.Parse()
 
 	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
 
+	if cfg.commission < 0 || cfg.commission > 100 {
+		logger.PrintFatal(errors.New("commission must be between 0 and 100"), nil)
+	}
+
 	db, err := openDB(cfg)
 	if err != nil {
 		logger.PrintFatal(err, nil)
@@ -66,12 +154,60 @@ func main() {
 
 	logger.PrintInfo("database connection pool established", nil)
 
+	smsSender, err := openSMSSender(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	notifier, err := openNotifier(cfg, smsSender)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	paymentGateway, err := openPaymentGateway(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	store, err := openStore(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
+		config:   cfg,
+		logger:   logger,
+		models:   data.NewModels(db),
+		sms:      smsSender,
+		notifier: notifier,
+		payment:  paymentGateway,
+		storage:  store,
+		stop:     make(chan struct{}),
 	}
 
+	// Running the binary with the verify-images argument checks the stored images
+	// instead of serving the API.
+	if flag.Arg(0) == "verify-images" {
+		problems, err := app.verifyImages()
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+
+		if problems > 0 {
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	app.every(time.Minute, app.cancelUnpaidOrders)
+	app.every(time.Minute, app.settleVerifiedPayments)
+	app.every(time.Minute, app.releaseExpiredReservations)
+	app.every(time.Minute, app.applyPromotions)
+	app.every(time.Minute, app.notifyWishlists)
+	app.every(10*time.Minute, app.deletePendingImages)
+	app.every(10*time.Minute, app.deleteExpiredUploads)
+
 	err = app.serve()
 	if err != nil {
 		logger.PrintFatal(err, nil)
@@ -103,3 +239,84 @@ func openDB(cfg config) (*sql.DB, error) {
 
 	return db, nil
 }
+
+func openSMSSender(cfg config) (sms.Sender, error) {
+	switch cfg.sms.sender {
+	case "log":
+		if cfg.sms.logFile == "" {
+			return sms.NewLogSender(os.Stdout), nil
+		}
+
+		f, err := os.OpenFile(cfg.sms.logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+
+		return sms.NewLogSender(f), nil
+	case "http":
+		if cfg.sms.apiURL == "" {
+			return nil, errors.New("sms-api-url must be provided for the http sms sender")
+		}
+
+		return sms.NewHTTPSender(cfg.sms.apiURL, cfg.sms.apiKey, cfg.sms.from), nil
+	default:
+		return nil, fmt.Errorf("unknown sms sender %q", cfg.sms.sender)
+	}
+}
+
+func openNotifier(cfg config, smsSender sms.Sender) (notify.Notifier, error) {
+	switch cfg.notifier.sink {
+	case "log":
+		if cfg.notifier.logFile == "" {
+			return notify.NewLogNotifier(os.Stdout), nil
+		}
+
+		f, err := os.OpenFile(cfg.notifier.logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+
+		return notify.NewLogNotifier(f), nil
+	case "sms":
+		return notify.NewSMSNotifier(smsSender), nil
+	default:
+		return nil, fmt.Errorf("unknown notifier %q", cfg.notifier.sink)
+	}
+}
+
+func openPaymentGateway(cfg config) (payment.Gateway, error) {
+	switch cfg.payment.gateway {
+	case "fake":
+		return payment.NewFakeGateway(fmt.Sprintf("http://localhost:%d", cfg.port)), nil
+	case "zarinpal":
+		if cfg.payment.merchantID == "" {
+			return nil, errors.New("payment-merchant-id must be provided for the zarinpal payment gateway")
+		}
+
+		return payment.NewZarinpalGateway(cfg.payment.merchantID, cfg.payment.sandbox), nil
+	default:
+		return nil, fmt.Errorf("unknown payment gateway %q", cfg.payment.gateway)
+	}
+}
+
+func openStore(cfg config) (storage.Store, error) {
+	switch cfg.storage.backend {
+	case "local":
+		baseURL := cfg.storage.baseURL
+		if baseURL == "" {
+			baseURL = fmt.Sprintf("http://localhost:%d/v1/images", cfg.port)
+		}
+
+		return storage.NewLocalStore(cfg.storage.root, baseURL), nil
+	case "s3":
+		if cfg.storage.s3.endpoint == "" || cfg.storage.s3.bucket == "" {
+			return nil, errors.New("s3-endpoint and s3-bucket must be provided for the s3 storage")
+		}
+
+		s3 := cfg.storage.s3
+
+		return storage.NewS3Store(s3.endpoint, s3.bucket, s3.region, s3.accessKey, s3.secretKey, s3.publicURL), nil
+	default:
+		return nil, fmt.Errorf("unknown storage %q", cfg.storage.backend)
+	}
+}
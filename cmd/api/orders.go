@@ -0,0 +1,352 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"misarfeh.com/internal/data"
+	"misarfeh.com/internal/validator"
+)
+
+// The createOrderHandler() turns the cart of the user into orders, one for every shop
+// in the cart since each shop ships separately and charges its own shipping cost to
+// the destination. Items whose price changed since they were added have to be accepted
+// in the cart first. The coupon of the cart is redeemed on the order of its shop.
+func (app *application) createOrderHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Destination data.Destination `json:"destination"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateDestination(v, input.Destination); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	cart, err := app.models.Carts.Get(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v.Check(len(cart.Shops) > 0, "cart", "must not be empty")
+
+	var itemIDs []int64
+
+	for _, shop := range cart.Shops {
+		for _, item := range shop.Items {
+			v.Check(!item.PriceChanged, "cart", fmt.Sprintf("the price of %s has changed, please accept the new price first", item.ProductName))
+			itemIDs = append(itemIDs, item.ID)
+		}
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// The coupon of the cart discounts the order of its shop.
+	var coupon *data.Coupon
+	var discount int64
+
+	if cart.CouponID != nil {
+		coupon, err = app.models.Coupons.Get(*cart.CouponID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = data.ErrCouponNotApplicable
+		for _, shop := range cart.Shops {
+			if shop.ShopID == coupon.ShopID {
+				discount, err = coupon.Discount(shop, time.Now())
+			}
+		}
+
+		if err != nil {
+			v.AddError("coupon", err.Error())
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+	}
+
+	orders := []*data.Order{}
+
+	// The name of the first item which is out of stock, if any.
+	var outOfStock string
+
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		for _, shop := range cart.Shops {
+			order := &data.Order{
+				UserID:           user.ID,
+				ShopID:           shop.ShopID,
+				Status:           data.OrderPendingPayment,
+				Destination:      input.Destination,
+				EstimatedArrival: shop.EstimatedDelivery,
+			}
+
+			lines := make([]*data.ShippingLine, 0, len(shop.Items))
+
+			for _, item := range shop.Items {
+				productID := item.ProductID
+
+				lines = append(lines, &data.ShippingLine{
+					ProductID: item.ProductID,
+					Quantity:  item.Quantity,
+					Subtotal:  item.Subtotal,
+					Weight:    int64(item.Weight) * int64(item.Quantity),
+				})
+
+				order.Items = append(order.Items, &data.OrderItem{
+					ProductID:   &productID,
+					VariantID:   item.VariantID,
+					ProductName: item.ProductName,
+					SKU:         item.SKU,
+					Quantity:    item.Quantity,
+					SalePrice:   item.SalePrice,
+					Off:         item.Off,
+				})
+			}
+
+			quote, err := app.quoteShipping(shop.ShopID, lines, input.Destination)
+			if err != nil {
+				return err
+			}
+
+			order.ShippingCost = quote.ShippingCost
+
+			if coupon != nil && coupon.ShopID == shop.ShopID {
+				order.Discount = discount
+				order.CouponCode = coupon.Code
+			}
+
+			err = tx.Orders.Insert(order)
+			if err != nil {
+				return err
+			}
+
+			// The stock of the variants is held until the payment timeout, the order
+			// is cancelled if it isn't paid by then.
+			for _, item := range order.Items {
+				if item.VariantID == nil {
+					continue
+				}
+
+				err := tx.Reservations.Hold(order.ID, *item.VariantID, item.Quantity, app.config.payment.timeout)
+				if err != nil {
+					if errors.Is(err, data.ErrOutOfStock) {
+						outOfStock = item.ProductName
+					}
+					return err
+				}
+			}
+
+			if order.CouponCode != "" {
+				err = tx.Coupons.Redeem(coupon, user.ID, order.ID, order.Discount)
+				if err != nil {
+					return err
+				}
+			}
+
+			orders = append(orders, order)
+		}
+
+		err := tx.Carts.DeleteItems(user.ID, itemIDs)
+		if err != nil {
+			return err
+		}
+
+		return tx.Carts.SetCoupon(user.ID, nil)
+	})
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrOutOfStock):
+			v.AddError("cart", fmt.Sprintf("there is not enough of %s in stock", outOfStock))
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrCouponUsedUp):
+			v.AddError("coupon", err.Error())
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"orders": orders}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The showOrderHandler() shows an order to the buyer who placed it and to the seller of
+// the shop it was placed in.
+func (app *application) showOrderHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	order, err := app.models.Orders.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if order.UserID != app.contextGetUser(r).ID {
+		owns, err := app.ownsShop(r, order.ShopID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !owns {
+			app.notFoundResponse(w, r)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"order": order}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The updateOrderStatusHandler() lets sellers move the orders of their shops through
+// the order lifecycle. Orders are marked as paid by the payment flow, never by sellers.
+func (app *application) updateOrderStatusHandler(w http.ResponseWriter, r *http.Request) {
+	shopID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	orderID, err := app.readInt64Param(r, "order_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	owns, err := app.ownsShop(r, shopID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !owns {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	order, err := app.models.Orders.Get(orderID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if order.ShopID != shopID {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Status string `json:"status"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateOrderStatus(v, input.Status)
+	v.Check(input.Status != data.OrderPaid, "status", "is set when the order is paid")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Delivering an order releases its money to the seller, in the same transaction as
+	// the change of status.
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		err := tx.Orders.UpdateStatus(order, input.Status)
+		if err != nil {
+			return err
+		}
+
+		if order.Status != data.OrderDelivered {
+			return nil
+		}
+
+		return tx.Ledger.CreditDeliveredOrder(order, app.contextGetUser(r).ID, app.config.commission)
+	})
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidTransition):
+			v.AddError("status", fmt.Sprintf("cannot change from %s to %s", order.Status, input.Status))
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	order, err = app.models.Orders.Get(order.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"order": order}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The cancelUnpaidOrders() job cancels the orders which weren't paid within the
+// payment timeout.
+func (app *application) cancelUnpaidOrders() error {
+	count, err := app.models.Orders.CancelExpired(app.config.payment.timeout)
+	if err != nil {
+		return err
+	}
+
+	if count > 0 {
+		app.logger.PrintInfo("cancelled unpaid orders", map[string]string{
+			"count": strconv.FormatInt(count, 10),
+		})
+	}
+
+	return nil
+}
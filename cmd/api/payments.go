@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"misarfeh.com/internal/data"
+	"misarfeh.com/internal/payment"
+	"misarfeh.com/internal/validator"
+)
+
+// The createPaymentHandler() starts the payment of an order. The buyer is expected to
+// be redirected to the returned redirect_url, and the gateway sends them back to the
+// payment callback afterwards.
+func (app *application) createPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	order, err := app.models.Orders.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if order.UserID != app.contextGetUser(r).ID {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(order.Status == data.OrderPendingPayment, "order", "is not waiting for payment"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	description := fmt.Sprintf("پرداخت سفارش %d", order.ID)
+
+	authority, redirectURL, err := app.payment.RequestPayment(r.Context(), order.Total, description, app.config.payment.callbackURL)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	p := &data.Payment{
+		OrderID:   order.ID,
+		Amount:    order.Total,
+		Authority: authority,
+		Status:    data.PaymentPending,
+	}
+
+	err = app.models.Payments.Insert(p)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"payment": p, "redirect_url": redirectURL}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The paymentCallbackHandler() is where the gateway sends the buyer back to. It
+// verifies the payment with the gateway and settles it, marking the order as paid. The
+// handler is idempotent, calling it again for a settled payment just returns the
+// payment.
+func (app *application) paymentCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	callback, err := app.payment.ParseCallback(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	p, err := app.models.Payments.GetByAuthority(callback.Authority)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if p.Status == data.PaymentPending {
+		if !callback.OK {
+			app.failPayment(w, r, p)
+			return
+		}
+
+		err = app.verifyPayment(p)
+		if err != nil {
+			switch {
+			case errors.Is(err, payment.ErrNotVerified), errors.Is(err, data.ErrInvalidTransition):
+				app.failPayment(w, r, p)
+			case errors.Is(err, data.ErrEditConflict):
+				// A concurrent callback verified the payment first.
+				app.reloadPaymentResponse(w, r, p)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	if p.Status == data.PaymentVerified {
+		err = app.settlePayment(p)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				app.reloadPaymentResponse(w, r, p)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	app.writePaymentStatusResponse(w, r, p)
+}
+
+// The verifyPayment() helper verifies a pending payment with the gateway and records
+// that it was verified. Once the gateway verified a payment the buyer has been
+// charged, so it runs on a context of its own rather than the one of the request,
+// which is cancelled if the buyer disconnects. A payment for an order which can no
+// longer be paid isn't verified, the gateway gives unverified payments back to the
+// buyer.
+func (app *application) verifyPayment(p *data.Payment) error {
+	order, err := app.models.Orders.Get(p.OrderID)
+	if err != nil {
+		return err
+	}
+
+	if !data.CanTransition(order.Status, data.OrderPaid) {
+		return data.ErrInvalidTransition
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	refID, err := app.payment.Verify(ctx, p.Authority, p.Amount)
+	if err != nil {
+		return err
+	}
+
+	err = app.models.Payments.MarkVerified(p, refID)
+	if err != nil {
+		// The buyer was charged but the payment isn't recorded as verified, the
+		// reference id is logged so the payment can be found.
+		app.logger.PrintError(err, map[string]string{
+			"authority": p.Authority,
+			"ref_id":    refID,
+		})
+		return err
+	}
+
+	return nil
+}
+
+// The settlePayment() helper settles a verified payment: it commits the stock held
+// for the order, moves the payment into escrow and marks the order as paid, all with
+// the order locked. If the order can no longer be paid, because it was cancelled or
+// its stock was released in the meantime, the payment is marked as refund_due
+// instead. It returns ErrEditConflict if the payment was settled concurrently.
+func (app *application) settlePayment(p *data.Payment) error {
+	err := app.models.WithTx(context.Background(), func(tx data.Models) error {
+		order, err := tx.Orders.GetForUpdate(p.OrderID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Payments.MarkPaid(p)
+		if err != nil {
+			return err
+		}
+
+		if !data.CanTransition(order.Status, data.OrderPaid) {
+			return data.ErrInvalidTransition
+		}
+
+		err = tx.Reservations.Commit(order.ID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Ledger.RecordPayment(p)
+		if err != nil {
+			return err
+		}
+
+		return tx.Orders.UpdateStatus(order, data.OrderPaid)
+	})
+
+	switch {
+	case errors.Is(err, data.ErrInvalidTransition), errors.Is(err, data.ErrReservationExpired):
+		// The payment was marked as paid in the rolled back transaction.
+		p.Status = data.PaymentVerified
+
+		err = app.models.Payments.MarkRefundDue(p)
+		if err != nil {
+			return err
+		}
+
+		app.logger.PrintError(errors.New("payment of an order which can't be paid is due for a refund"), map[string]string{
+			"authority": p.Authority,
+			"ref_id":    p.RefID,
+			"order":     strconv.FormatInt(p.OrderID, 10),
+		})
+
+		return nil
+	default:
+		return err
+	}
+}
+
+// The settleVerifiedPayments() job settles the payments which were verified but not
+// settled, because the callback failed after verifying them.
+func (app *application) settleVerifiedPayments() error {
+	payments, err := app.models.Payments.GetAllVerified()
+	if err != nil {
+		return err
+	}
+
+	for _, p := range payments {
+		err := app.settlePayment(p)
+		if err != nil && !errors.Is(err, data.ErrEditConflict) {
+			app.logger.PrintError(err, map[string]string{
+				"authority": p.Authority,
+				"ref_id":    p.RefID,
+			})
+		}
+	}
+
+	return nil
+}
+
+// The reloadPaymentResponse() helper answers a callback whose payment was verified or
+// settled by a concurrent callback, with the status that callback left it in.
+func (app *application) reloadPaymentResponse(w http.ResponseWriter, r *http.Request, p *data.Payment) {
+	p, err := app.models.Payments.GetByAuthority(p.Authority)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writePaymentStatusResponse(w, r, p)
+}
+
+func (app *application) writePaymentStatusResponse(w http.ResponseWriter, r *http.Request, p *data.Payment) {
+	switch p.Status {
+	case data.PaymentPaid, data.PaymentVerified:
+		app.writePaymentResponse(w, r, p)
+	case data.PaymentRefundDue:
+		app.paymentRefundDueResponse(w, r)
+	default:
+		app.paymentFailedResponse(w, r)
+	}
+}
+
+func (app *application) failPayment(w http.ResponseWriter, r *http.Request, p *data.Payment) {
+	err := app.models.Payments.MarkFailed(p)
+	if err != nil && !errors.Is(err, data.ErrEditConflict) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.paymentFailedResponse(w, r)
+}
+
+func (app *application) writePaymentResponse(w http.ResponseWriter, r *http.Request, p *data.Payment) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"payment": p}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
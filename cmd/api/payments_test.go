@@ -0,0 +1,462 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"misarfeh.com/internal/data"
+	"misarfeh.com/internal/jsonlog"
+	"misarfeh.com/internal/payment"
+)
+
+// paymentStore holds the orders, payments and ledger behind the models of the payment
+// tests. WithTx runs its function directly on models without a database, so the
+// models only have to keep the state the payment callback reads and writes.
+type paymentStore struct {
+	orders   map[int64]*data.Order
+	payments map[string]*data.Payment
+	balances map[string]int64
+
+	// expired makes committing the reservations of an order fail, as if its stock
+	// was released before it was paid, and ledgerErr makes recording payments fail.
+	expired   bool
+	ledgerErr error
+
+	// settling is the payment being marked as paid, it is stored as paid once the
+	// order is.
+	settling *data.Payment
+}
+
+type testOrderModel struct{ s *paymentStore }
+
+func (m testOrderModel) Insert(order *data.Order) error {
+	m.s.orders[order.ID] = order
+	return nil
+}
+
+func (m testOrderModel) Get(id int64) (*data.Order, error) {
+	order, ok := m.s.orders[id]
+	if !ok {
+		return nil, data.ErrRecordNotFound
+	}
+
+	copied := *order
+	return &copied, nil
+}
+
+func (m testOrderModel) GetForUpdate(id int64) (*data.Order, error) {
+	return m.Get(id)
+}
+
+func (m testOrderModel) UpdateStatus(order *data.Order, status string) error {
+	if !data.CanTransition(m.s.orders[order.ID].Status, status) {
+		return data.ErrInvalidTransition
+	}
+
+	m.s.orders[order.ID].Status = status
+	order.Status = status
+
+	if status == data.OrderPaid && m.s.settling != nil {
+		m.s.settling.Status = data.PaymentPaid
+		m.s.settling = nil
+	}
+
+	return nil
+}
+
+func (m testOrderModel) CancelExpired(timeout time.Duration) (int64, error) {
+	return 0, nil
+}
+
+type testPaymentModel struct{ s *paymentStore }
+
+func (m testPaymentModel) Insert(p *data.Payment) error {
+	m.s.payments[p.Authority] = p
+	return nil
+}
+
+func (m testPaymentModel) GetByAuthority(authority string) (*data.Payment, error) {
+	p, ok := m.s.payments[authority]
+	if !ok {
+		return nil, data.ErrRecordNotFound
+	}
+
+	copied := *p
+	return &copied, nil
+}
+
+func (m testPaymentModel) GetAllVerified() ([]*data.Payment, error) {
+	payments := []*data.Payment{}
+
+	for _, p := range m.s.payments {
+		if p.Status == data.PaymentVerified {
+			copied := *p
+			payments = append(payments, &copied)
+		}
+	}
+
+	return payments, nil
+}
+
+func (m testPaymentModel) MarkVerified(p *data.Payment, refID string) error {
+	return m.updateStatus(p, data.PaymentVerified, refID, data.PaymentPending, data.PaymentFailed)
+}
+
+func (m testPaymentModel) MarkPaid(p *data.Payment) error {
+	return m.updateStatus(p, data.PaymentPaid, "", data.PaymentVerified)
+}
+
+func (m testPaymentModel) MarkRefundDue(p *data.Payment) error {
+	return m.updateStatus(p, data.PaymentRefundDue, "", data.PaymentVerified)
+}
+
+func (m testPaymentModel) MarkFailed(p *data.Payment) error {
+	return m.updateStatus(p, data.PaymentFailed, "", data.PaymentPending)
+}
+
+// Like the database, a payment only moves to status from one of the from statuses.
+// Since WithTx doesn't roll anything back here, the status of a payment marked as paid
+// is only stored once the settlement got through.
+func (m testPaymentModel) updateStatus(p *data.Payment, status, refID string, from ...string) error {
+	stored := m.s.payments[p.Authority]
+
+	allowed := false
+	for _, f := range from {
+		allowed = allowed || stored.Status == f
+	}
+
+	if !allowed {
+		return data.ErrEditConflict
+	}
+
+	if status == data.PaymentPaid {
+		m.s.settling = stored
+	} else {
+		stored.Status = status
+	}
+
+	if refID != "" {
+		stored.RefID = refID
+		p.RefID = refID
+	}
+
+	p.Status = status
+	return nil
+}
+
+type testReservationModel struct{ s *paymentStore }
+
+func (m testReservationModel) Hold(orderID, variantID int64, quantity int32, ttl time.Duration) error {
+	return nil
+}
+
+func (m testReservationModel) Commit(orderID int64) error {
+	if m.s.expired {
+		return data.ErrReservationExpired
+	}
+	return nil
+}
+
+func (m testReservationModel) ReleaseExpired() (int64, error) {
+	return 0, nil
+}
+
+func (m testReservationModel) GetAllForProduct(productID int64) ([]*data.VariantReservations, error) {
+	return nil, nil
+}
+
+type testLedgerModel struct{ s *paymentStore }
+
+func (m testLedgerModel) RecordPayment(p *data.Payment) error {
+	if m.s.ledgerErr != nil {
+		return m.s.ledgerErr
+	}
+
+	m.s.balances[data.AccountGateway] -= p.Amount
+	m.s.balances[data.AccountEscrow] += p.Amount
+	return nil
+}
+
+func (m testLedgerModel) CreditDeliveredOrder(order *data.Order, sellerID int64, commissionPercent int) error {
+	return nil
+}
+
+func (m testLedgerModel) GetBalance(sellerID int64) (*data.Balance, error) {
+	return &data.Balance{}, nil
+}
+
+func (m testLedgerModel) GetAllForSeller(sellerID int64, filters data.Filters) ([]*data.LedgerEntry, data.Metadata, error) {
+	return nil, data.Metadata{}, nil
+}
+
+func TestPaymentCallback(t *testing.T) {
+	const amount = 1500000
+
+	tests := []struct {
+		name string
+
+		// setup changes the store after the buyer was sent to the gateway, and
+		// pay completes the payment on the gateway. It returns the callback URL.
+		setup func(s *paymentStore)
+		pay   func(g *payment.FakeGateway, authority string) string
+
+		wantCode          int
+		wantPaymentStatus string
+		wantOrderStatus   string
+		wantEscrow        int64
+	}{
+		{
+			name:              "paid",
+			wantCode:          http.StatusOK,
+			wantPaymentStatus: data.PaymentPaid,
+			wantOrderStatus:   data.OrderPaid,
+			wantEscrow:        amount,
+		},
+		{
+			name: "cancelled by the buyer",
+			pay: func(g *payment.FakeGateway, authority string) string {
+				callbackURL, _ := g.Pay(authority, false)
+				return callbackURL
+			},
+			wantCode:          http.StatusPaymentRequired,
+			wantPaymentStatus: data.PaymentFailed,
+			wantOrderStatus:   data.OrderPendingPayment,
+		},
+		{
+			name: "callback without paying",
+			pay: func(g *payment.FakeGateway, authority string) string {
+				return "/v1/payments/callback?Status=OK&Authority=" + authority
+			},
+			wantCode:          http.StatusPaymentRequired,
+			wantPaymentStatus: data.PaymentFailed,
+			wantOrderStatus:   data.OrderPendingPayment,
+		},
+		{
+			name:              "reservation expired",
+			setup:             func(s *paymentStore) { s.expired = true },
+			wantCode:          http.StatusConflict,
+			wantPaymentStatus: data.PaymentRefundDue,
+			wantOrderStatus:   data.OrderPendingPayment,
+		},
+		{
+			name:              "order cancelled before the callback",
+			setup:             func(s *paymentStore) { s.orders[1].Status = data.OrderCancelled },
+			wantCode:          http.StatusPaymentRequired,
+			wantPaymentStatus: data.PaymentFailed,
+			wantOrderStatus:   data.OrderCancelled,
+		},
+		{
+			name: "unknown authority",
+			pay: func(g *payment.FakeGateway, authority string) string {
+				return "/v1/payments/callback?Status=OK&Authority=FAKE0000000000000042"
+			},
+			wantCode:          http.StatusNotFound,
+			wantPaymentStatus: data.PaymentPending,
+			wantOrderStatus:   data.OrderPendingPayment,
+		},
+		{
+			name: "no authority",
+			pay: func(g *payment.FakeGateway, authority string) string {
+				return "/v1/payments/callback?Status=OK"
+			},
+			wantCode:          http.StatusBadRequest,
+			wantPaymentStatus: data.PaymentPending,
+			wantOrderStatus:   data.OrderPendingPayment,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, s, g := newPaymentTestApplication()
+
+			authority := startPayment(t, app, g, amount)
+
+			if tt.setup != nil {
+				tt.setup(s)
+			}
+
+			var callbackURL string
+			if tt.pay != nil {
+				callbackURL = tt.pay(g, authority)
+			} else {
+				callbackURL, _ = g.Pay(authority, true)
+			}
+
+			code, _ := callPaymentCallback(app, callbackURL)
+			if code != tt.wantCode {
+				t.Errorf("got status %d; want %d", code, tt.wantCode)
+			}
+
+			if got := s.payments[authority].Status; got != tt.wantPaymentStatus {
+				t.Errorf("got payment status %q; want %q", got, tt.wantPaymentStatus)
+			}
+
+			if got := s.orders[1].Status; got != tt.wantOrderStatus {
+				t.Errorf("got order status %q; want %q", got, tt.wantOrderStatus)
+			}
+
+			if s.balances[data.AccountEscrow] != tt.wantEscrow || s.balances[data.AccountGateway] != -tt.wantEscrow {
+				t.Errorf("got escrow %d and gateway %d; want %d and %d", s.balances[data.AccountEscrow],
+					s.balances[data.AccountGateway], tt.wantEscrow, -tt.wantEscrow)
+			}
+		})
+	}
+}
+
+// A payment whose settlement failed after the gateway verified it stays verified, and
+// is settled by the settleVerifiedPayments job.
+func TestPaymentCallbackSettlementFailed(t *testing.T) {
+	app, s, g := newPaymentTestApplication()
+
+	authority := startPayment(t, app, g, 400000)
+
+	callbackURL, err := g.Pay(authority, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.ledgerErr = errors.New("connection reset")
+
+	code, _ := callPaymentCallback(app, callbackURL)
+	if code != http.StatusInternalServerError {
+		t.Fatalf("got status %d; want %d", code, http.StatusInternalServerError)
+	}
+
+	if p := s.payments[authority]; p.Status != data.PaymentVerified || p.RefID == "" {
+		t.Fatalf("got payment status %q and reference id %q; want a verified payment", p.Status, p.RefID)
+	}
+
+	s.ledgerErr = nil
+
+	err = app.settleVerifiedPayments()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := s.payments[authority].Status; got != data.PaymentPaid {
+		t.Errorf("got payment status %q; want %q", got, data.PaymentPaid)
+	}
+
+	if got := s.orders[1].Status; got != data.OrderPaid {
+		t.Errorf("got order status %q; want %q", got, data.OrderPaid)
+	}
+
+	if s.balances[data.AccountEscrow] != 400000 {
+		t.Errorf("got escrow %d; want 400000", s.balances[data.AccountEscrow])
+	}
+
+	// The buyer coming back again gets the settled payment.
+	code, _ = callPaymentCallback(app, callbackURL)
+	if code != http.StatusOK {
+		t.Errorf("got status %d; want %d", code, http.StatusOK)
+	}
+}
+
+// The gateway may call back more than once for the same payment. Every callback after
+// the first returns the payment without verifying or recording it again.
+func TestPaymentCallbackTwice(t *testing.T) {
+	app, s, g := newPaymentTestApplication()
+
+	authority := startPayment(t, app, g, 250000)
+
+	callbackURL, err := g.Pay(authority, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var refIDs []string
+
+	for i := 0; i < 2; i++ {
+		code, body := callPaymentCallback(app, callbackURL)
+		if code != http.StatusOK {
+			t.Fatalf("callback %d: got status %d; want %d", i+1, code, http.StatusOK)
+		}
+
+		var response struct {
+			Payment data.Payment `json:"payment"`
+		}
+
+		err := json.Unmarshal(body, &response)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		refIDs = append(refIDs, response.Payment.RefID)
+	}
+
+	if refIDs[0] == "" || refIDs[0] != refIDs[1] {
+		t.Errorf("got reference ids %q; want the same one twice", refIDs)
+	}
+
+	if s.balances[data.AccountEscrow] != 250000 {
+		t.Errorf("got escrow %d; want the payment recorded once", s.balances[data.AccountEscrow])
+	}
+}
+
+func newPaymentTestApplication() (*application, *paymentStore, *payment.FakeGateway) {
+	s := &paymentStore{
+		orders:   make(map[int64]*data.Order),
+		payments: make(map[string]*data.Payment),
+		balances: make(map[string]int64),
+	}
+
+	models := data.NewMockModels()
+	models.Orders = testOrderModel{s}
+	models.Payments = testPaymentModel{s}
+	models.Reservations = testReservationModel{s}
+	models.Ledger = testLedgerModel{s}
+
+	g := payment.NewFakeGateway("")
+
+	app := &application{
+		logger:  jsonlog.New(io.Discard, jsonlog.LevelInfo),
+		models:  models,
+		payment: g,
+	}
+
+	return app, s, g
+}
+
+// The startPayment() helper creates order 1 with a pending payment of amount, as
+// createPaymentHandler does, and returns the authority of the payment.
+func startPayment(t *testing.T, app *application, g *payment.FakeGateway, amount int64) string {
+	t.Helper()
+
+	err := app.models.Orders.Insert(&data.Order{ID: 1, Status: data.OrderPendingPayment, Total: amount})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authority, _, err := g.RequestPayment(context.Background(), amount, "order 1", "http://localhost:4000/v1/payments/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.models.Payments.Insert(&data.Payment{
+		ID:        1,
+		OrderID:   1,
+		Amount:    amount,
+		Authority: authority,
+		Status:    data.PaymentPending,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return authority
+}
+
+func callPaymentCallback(app *application, callbackURL string) (int, []byte) {
+	rr := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, callbackURL, nil)
+
+	app.paymentCallbackHandler(rr, r)
+
+	return rr.Code, rr.Body.Bytes()
+}
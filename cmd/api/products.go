@@ -6,22 +6,125 @@ import (
 	"net/http"
 
 	"misarfeh.com/internal/data"
+	"misarfeh.com/internal/imaging"
 	"misarfeh.com/internal/validator"
 )
 
-// Todo : add real shop_id to created products by JWT, and img
+// The ownsShop() helper reports whether the shop with the given id belongs to the
+// authenticated seller. A shop which doesn't exist is treated as not owned.
+func (app *application) ownsShop(r *http.Request, shopID int64) (bool, error) {
+	shop, err := app.models.Shops.Get(shopID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return shop.SellerID == app.contextGetUser(r).ID, nil
+}
+
+// The requireShopOwner() helper reads the shop id from the URL and checks that the
+// shop belongs to the authenticated seller. It sends the error response and returns
+// false if it doesn't.
+func (app *application) requireShopOwner(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return 0, false
+	}
+
+	owner, err := app.ownsShop(r, id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return 0, false
+	}
+
+	if !owner {
+		app.notPermittedResponse(w, r)
+		return 0, false
+	}
+
+	return id, true
+}
+
+// The priceFromExchangeRate() helper sets the sale price of the product from its
+// original price, if the seller has set an exchange rate for its currency. The product
+// is left unchanged otherwise.
+func (app *application) priceFromExchangeRate(r *http.Request, product *data.Product) error {
+	if product.Price == nil || !data.ValidCurrency(product.Price.Currency) {
+		return nil
+	}
+
+	if product.Price.Currency == data.CurrencyToman {
+		product.SalePrice = product.Price.Amount
+		return nil
+	}
+
+	rate, err := app.models.ExchangeRates.Get(app.contextGetUser(r).ID, product.Price.Currency)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil
+		default:
+			return err
+		}
+	}
+
+	product.SalePrice = product.Price.Convert(rate.Rate).Amount
+
+	// Like RepriceForSeller(), a price worth less than a toman is sold for one toman,
+	// since sale prices must be positive.
+	if product.SalePrice < 1 {
+		product.SalePrice = 1
+	}
+
+	return nil
+}
+
+// The attachProductImages() helper attaches the images of every url of the product to
+// it, in order, so the first one is its cover. Other URLs are saved as new images,
+// sharing the files of the images already using them.
+func attachProductImages(models data.Models, product *data.Product, userID int64) error {
+	product.Images = make([]*data.Image, 0, len(product.ImgUrls))
+
+	for i, url := range product.ImgUrls {
+		image := &data.Image{
+			UserID:    &userID,
+			Url:       url,
+			Variants:  imaging.Srcset(url),
+			Position:  int32(i),
+			ProductID: &product.ID,
+		}
+
+		err := models.Images.Attach(image)
+		if err != nil {
+			return err
+		}
+
+		product.Images = append(product.Images, image)
+	}
+
+	return nil
+}
 
 func (app *application) createProductHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Category    string   `json:"category"`
-		Country     string   `json:"country"`
-		Name        string   `json:"name"`
-		Description string   `json:"description"`
-		Price       float32  `json:"price,omitempty"`
-		SalePrice   int64    `json:"sale_price"`
-		Off         int32    `json:"off,omitempty"`
-		Brand       string   `json:"brand"`
-		ImgUrls     []string `json:"img_urls"`
+		ShopID        int64                  `json:"shop_id"`
+		Category      string                 `json:"category"`
+		Country       string                 `json:"country"`
+		Name          string                 `json:"name"`
+		Description   string                 `json:"description"`
+		Price         *int64                 `json:"price,omitempty"`
+		PriceCurrency string                 `json:"price_currency,omitempty"`
+		SalePrice     int64                  `json:"sale_price"`
+		Off           int32                  `json:"off,omitempty"`
+		Brand         string                 `json:"brand"`
+		Weight        int32                  `json:"weight"`
+		ImgUrls       []string               `json:"img_urls"`
+		Variants      []*data.ProductVariant `json:"variants"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -31,15 +134,30 @@ func (app *application) createProductHandler(w http.ResponseWriter, r *http.Requ
 	}
 
 	product := &data.Product{
+		ShopID:      input.ShopID,
 		Name:        input.Name,
 		Country:     input.Country,
 		Category:    input.Category,
 		Description: input.Description,
-		Price:       input.Price,
 		SalePrice:   input.SalePrice,
 		Off:         input.Off,
 		Brand:       input.Brand,
+		Weight:      input.Weight,
 		ImgUrls:     input.ImgUrls,
+		Variants:    input.Variants,
+	}
+
+	if input.Price != nil {
+		product.Price = &data.Money{Amount: *input.Price, Currency: input.PriceCurrency}
+	}
+
+	// Without a sale price, the product is priced from the exchange rate of the seller.
+	if product.SalePrice == 0 {
+		err = app.priceFromExchangeRate(r, product)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
 	}
 
 	v := validator.New()
@@ -49,32 +167,63 @@ func (app *application) createProductHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	// Create or return Country
-	countries, err := app.models.Countries.GetOrInsert(input.Country)
+	owner, err := app.ownsShop(r, product.ShopID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	// Create or return Category
-	categories, err := app.models.Categories.GetOrInsert(input.Category)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
+	if !owner {
+		app.notPermittedResponse(w, r)
 		return
 	}
 
-	// Update product
-	product.CountryID = countries[0].ID
-	product.CategoryID = categories[0].ID
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		// Create or return Country
+		countries, err := tx.Countries.GetOrInsert(product.Country)
+		if err != nil {
+			return err
+		}
+
+		// Create or return Category
+		categories, err := tx.Categories.GetOrInsert(product.Category)
+		if err != nil {
+			return err
+		}
 
-	err = app.models.Products.Insert(product)
+		// Update product
+		product.CountryID = countries[0].ID
+		product.CategoryID = categories[0].ID
+
+		err = tx.Products.Insert(product)
+		if err != nil {
+			return err
+		}
+
+		if product.Variants != nil {
+			err = tx.Variants.ReplaceForProduct(product.ID, product.Variants)
+			if err != nil {
+				return err
+			}
+
+			product.Options = data.VariantOptions(product.Variants)
+		}
+
+		return attachProductImages(tx, product, app.contextGetUser(r).ID)
+	})
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/products/%d", product.ID))
+	headers.Set("ETag", etag(product.Version))
 
 	err = app.writeJSON(w, http.StatusCreated, envelope{"product": product}, headers)
 	if err != nil {
@@ -128,7 +277,34 @@ func (app *application) showProductHandler(w http.ResponseWriter, r *http.Reques
 		product.Category = category.Name
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"product": product}, nil)
+	// retrieve list of image_urls
+	images, err := app.models.Images.GetAll(0, product.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	for _, image := range images {
+		product.ImgUrls = append(product.ImgUrls, image.Url)
+		product.Images = append(product.Images, image)
+	}
+
+	product.Options, err = app.models.Variants.GetOptionsForProduct(product.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	product.Variants, err = app.models.Variants.GetAllForProduct(product.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag(product.Version))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"product": product}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -136,11 +312,14 @@ func (app *application) showProductHandler(w http.ResponseWriter, r *http.Reques
 
 func (app *application) listProductsHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Name       string
+		Search     string
 		Brand      string
-		categories []string
-		Countries  []string
-		Sale_Price []int64
+		ShopID     int
+		CategoryID int
+		CountryID  int
+		MinPrice   int
+		MaxPrice   int
+		MinOff     int
 		data.Filters
 	}
 
@@ -148,28 +327,44 @@ func (app *application) listProductsHandler(w http.ResponseWriter, r *http.Reque
 
 	qs := r.URL.Query()
 
-	input.Name = app.readString(qs, "title", "")
-	input.Brand = app.readString(qs, "instagram", "")
-	input.Countries = app.readCSV(qs, "countries", []string{})
+	input.Search = app.readString(qs, "search", "")
+	input.Brand = app.readString(qs, "brand", "")
+	input.ShopID = app.readInt(qs, "shop_id", 0, v)
+	input.CategoryID = app.readInt(qs, "category_id", 0, v)
+	input.CountryID = app.readInt(qs, "country_id", 0, v)
+	input.MinPrice = app.readInt(qs, "min_price", 0, v)
+	input.MaxPrice = app.readInt(qs, "max_price", 0, v)
+	input.MinOff = app.readInt(qs, "off", 0, v)
 
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 
-	input.Filters.Sort = app.readString(qs, "sort", "id")
-	input.Filters.SortSafelist = []string{"id", "title", "delivery_time", "-id", "-title", "-delivery_time"}
+	input.Filters.Sort = app.readString(qs, "sort", "-created_at")
+	input.Filters.SortSafelist = []string{"id", "sale_price", "created_at", "off", "-id", "-sale_price", "-created_at", "-off"}
+
+	v.Check(input.ShopID >= 0, "shop_id", "must not be negative")
+	v.Check(input.CategoryID >= 0, "category_id", "must not be negative")
+	v.Check(input.CountryID >= 0, "country_id", "must not be negative")
+	v.Check(input.MinPrice >= 0, "min_price", "must not be negative")
+	v.Check(input.MaxPrice >= 0, "max_price", "must not be negative")
+	v.Check(input.MaxPrice == 0 || input.MaxPrice >= input.MinPrice, "max_price", "must not be lesser than min_price")
+	v.Check(input.MinOff >= 0, "off", "must not be negetive number")
+	v.Check(input.MinOff <= 100, "off", "must not be greater than 100")
 
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	shops, metadata, err := app.models.Shops.GetAll(input.Name, false, input.Countries, input.Filters)
+	products, metadata, err := app.models.Products.GetAll(input.Search, input.Brand, int64(input.ShopID),
+		int64(input.CategoryID), int64(input.CountryID), int64(input.MinPrice), int64(input.MaxPrice),
+		int32(input.MinOff), input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"shops": shops, "metadata": metadata}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"products": products, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -193,16 +388,35 @@ func (app *application) updateProductHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	owner, err := app.ownsShop(r, product.ShopID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !owner {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	if !app.ifMatch(r, product.Version) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
 	var input struct {
-		Category    *string  `json:"category"`
-		Country     *string  `json:"country"`
-		Name        *string  `json:"name"`
-		Description *string  `json:"description"`
-		Price       *float32 `json:"price,omitempty"`
-		SalePrice   *int64   `json:"sale_price"`
-		Off         *int32   `json:"off,omitempty"`
-		Brand       *string  `json:"brand"`
-		ImgUrls     []string `json:"img_urls"`
+		Category      *string                `json:"category"`
+		Country       *string                `json:"country"`
+		Name          *string                `json:"name"`
+		Description   *string                `json:"description"`
+		Price         *int64                 `json:"price,omitempty"`
+		PriceCurrency *string                `json:"price_currency,omitempty"`
+		SalePrice     *int64                 `json:"sale_price"`
+		Off           *int32                 `json:"off,omitempty"`
+		Brand         *string                `json:"brand"`
+		Weight        *int32                 `json:"weight"`
+		ImgUrls       []string               `json:"img_urls"`
+		Variants      []*data.ProductVariant `json:"variants"`
 	}
 
 	err = app.readJSON(w, r, &input)
@@ -211,28 +425,41 @@ func (app *application) updateProductHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	// Create or return Country
-	countries, err := app.models.Countries.GetOrInsert(*input.Country)
-	if err != nil {
+	// fetch the current country, category and images so the product validates even
+	// when they aren't part of the update.
+	country, err := app.models.Countries.Get(product.CountryID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
 		app.serverErrorResponse(w, r, err)
 		return
+	} else if err == nil {
+		product.Country = country.Name
 	}
 
-	// Create or return Category
-	categories, err := app.models.Categories.GetOrInsert(*input.Category)
+	category, err := app.models.Categories.Get(product.CategoryID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	} else if err == nil {
+		product.Category = category.Name
+	}
+
+	images, err := app.models.Images.GetAll(0, product.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	for _, image := range images {
+		product.ImgUrls = append(product.ImgUrls, image.Url)
+		product.Images = append(product.Images, image)
+	}
+
 	if input.Category != nil {
-		product.CategoryID = categories[0].ID
-		product.Category = categories[0].Name
+		product.Category = *input.Category
 	}
 
 	if input.Country != nil {
-		product.CountryID = countries[0].ID
-		product.Country = countries[0].Name
+		product.Country = *input.Country
 	}
 
 	if input.Name != nil {
@@ -243,12 +470,31 @@ func (app *application) updateProductHandler(w http.ResponseWriter, r *http.Requ
 		product.Description = *input.Description
 	}
 
-	if input.Price != nil {
-		product.Price = *input.Price
+	if input.Price != nil || input.PriceCurrency != nil {
+		price := data.Money{}
+		if product.Price != nil {
+			price = *product.Price
+		}
+
+		if input.Price != nil {
+			price.Amount = *input.Price
+		}
+
+		if input.PriceCurrency != nil {
+			price.Currency = *input.PriceCurrency
+		}
+
+		product.Price = &price
 	}
 
 	if input.SalePrice != nil {
 		product.SalePrice = *input.SalePrice
+	} else if input.Price != nil || input.PriceCurrency != nil {
+		err = app.priceFromExchangeRate(r, product)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
 	}
 
 	if input.Off != nil {
@@ -259,10 +505,18 @@ func (app *application) updateProductHandler(w http.ResponseWriter, r *http.Requ
 		product.Brand = *input.Brand
 	}
 
+	if input.Weight != nil {
+		product.Weight = *input.Weight
+	}
+
 	if input.ImgUrls != nil {
 		product.ImgUrls = input.ImgUrls
 	}
 
+	if input.Variants != nil {
+		product.Variants = input.Variants
+	}
+
 	v := validator.New()
 
 	if data.ValidateProduct(v, product); !v.Valid() {
@@ -270,13 +524,64 @@ func (app *application) updateProductHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	err = app.models.Products.Update(product)
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		// Create or return Country
+		countries, err := tx.Countries.GetOrInsert(product.Country)
+		if err != nil {
+			return err
+		}
+
+		// Create or return Category
+		categories, err := tx.Categories.GetOrInsert(product.Category)
+		if err != nil {
+			return err
+		}
+
+		product.CountryID = countries[0].ID
+		product.CategoryID = categories[0].ID
+
+		err = tx.Products.Update(product)
+		if err != nil {
+			return err
+		}
+
+		if input.Variants != nil {
+			err = tx.Variants.ReplaceForProduct(product.ID, product.Variants)
+			if err != nil {
+				return err
+			}
+
+			product.Options = data.VariantOptions(product.Variants)
+		}
+
+		if input.ImgUrls == nil {
+			return nil
+		}
+
+		err = tx.Images.DetachAllForProduct(product.ID)
+		if err != nil {
+			return err
+		}
+
+		return attachProductImages(tx, product, app.contextGetUser(r).ID)
+	})
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrVariantReserved):
+			v.AddError("variants", "the stock of variants with unpaid orders can't be changed and they can't be removed, try again later")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"product": product}, nil)
+	headers := make(http.Header)
+	headers.Set("ETag", etag(product.Version))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"product": product}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -289,7 +594,29 @@ func (app *application) deleteProductHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	err = app.models.Products.Delete(id)
+	product, err := app.models.Products.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	owner, err := app.ownsShop(r, product.ShopID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !owner {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	err = app.models.Products.Delete(product.ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"misarfeh.com/internal/data"
+	"misarfeh.com/internal/validator"
+)
+
+func (app *application) listPromotionsHandler(w http.ResponseWriter, r *http.Request) {
+	shopID, ok := app.requireShopOwner(w, r)
+	if !ok {
+		return
+	}
+
+	promotions, err := app.models.Promotions.GetAllForShop(shopID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"promotions": promotions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The createPromotionHandler() schedules a sale. The off of the products in its scope
+// is switched on when the sale window opens and back off when it closes.
+func (app *application) createPromotionHandler(w http.ResponseWriter, r *http.Request) {
+	shopID, ok := app.requireShopOwner(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Off        int32     `json:"off"`
+		StartsAt   time.Time `json:"starts_at"`
+		EndsAt     time.Time `json:"ends_at"`
+		Scope      string    `json:"scope"`
+		CategoryID *int64    `json:"category_id"`
+		ProductID  *int64    `json:"product_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	promotion := &data.Promotion{
+		ShopID:     shopID,
+		Off:        input.Off,
+		StartsAt:   input.StartsAt,
+		EndsAt:     input.EndsAt,
+		Scope:      input.Scope,
+		CategoryID: input.CategoryID,
+		ProductID:  input.ProductID,
+	}
+
+	if promotion.Scope == "" {
+		promotion.Scope = data.ScopeShop
+	}
+
+	v := validator.New()
+
+	if data.ValidatePromotion(v, promotion); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if !app.validScopeTarget(w, r, v, shopID, promotion.CategoryID, promotion.ProductID) {
+		return
+	}
+
+	err = app.models.Promotions.Insert(promotion)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"promotion": promotion}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deletePromotionHandler(w http.ResponseWriter, r *http.Request) {
+	shopID, ok := app.requireShopOwner(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := app.readInt64Param(r, "promotion_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Promotions.Delete(shopID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "promotion successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The applyPromotions() job switches the off of the products on and off as the windows
+// of the promotions open and close.
+func (app *application) applyPromotions() error {
+	started, ended, err := app.models.Promotions.Apply()
+	if err != nil {
+		return err
+	}
+
+	if started > 0 || ended > 0 {
+		app.logger.PrintInfo("applied promotions", map[string]string{
+			"started": strconv.FormatInt(started, 10),
+			"ended":   strconv.FormatInt(ended, 10),
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"misarfeh.com/internal/data"
+)
+
+// The listProductReservationsHandler() shows the owner of the shop how much of every
+// variant of the product is left in stock, held for orders waiting for payment and
+// committed to paid orders.
+func (app *application) listProductReservationsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	product, err := app.models.Products.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	owner, err := app.ownsShop(r, product.ShopID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !owner {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	reservations, err := app.models.Reservations.GetAllForProduct(product.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"reservations": reservations}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The releaseExpiredReservations() job puts the stock held for orders which weren't
+// paid in time back on sale.
+func (app *application) releaseExpiredReservations() error {
+	count, err := app.models.Reservations.ReleaseExpired()
+	if err != nil {
+		return err
+	}
+
+	if count > 0 {
+		app.logger.PrintInfo("released expired stock reservations", map[string]string{
+			"count": strconv.FormatInt(count, 10),
+		})
+	}
+
+	return nil
+}
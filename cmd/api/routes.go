@@ -3,6 +3,9 @@ package main
 import (
 	"net/http"
 
+	"misarfeh.com/internal/data"
+	"misarfeh.com/internal/payment"
+
 	"github.com/julienschmidt/httprouter"
 )
 
@@ -14,36 +17,95 @@ func (app *application) routes() http.Handler {
 
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
 
-	router.HandlerFunc(http.MethodPost, "/v1/upload", app.uploadImagesHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/upload", app.requireActivatedUser(app.uploadImagesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/uploads", app.requireActivatedUser(app.createUploadHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/uploads/:id", app.requireActivatedUser(app.showUploadHandler))
+	router.HandlerFunc(http.MethodHead, "/v1/uploads/:id", app.requireActivatedUser(app.showUploadHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/uploads/:id", app.requireActivatedUser(app.appendUploadHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/uploads/:id", app.requireActivatedUser(app.deleteUploadHandler))
 
-	fileServer := http.FileServer(http.Dir("./uploads/"))
-	router.Handler(http.MethodGet, "/v1/images/*filepath", http.StripPrefix("/v1/images", fileServer))
+	router.HandlerFunc(http.MethodGet, "/v1/images/*filepath", app.serveImageHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/images/:id", app.requireActivatedUser(app.deleteImageHandler))
 
 	router.HandlerFunc(http.MethodGet, "/v1/shops", app.listShopsHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/shops", app.createShopHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/shops", app.requireSellerUser(app.createShopHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/shops/:id", app.showShopHandler)
-	router.HandlerFunc(http.MethodPatch, "/v1/shops/:id", app.updateShopHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/shops/:id", app.deleteShopHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/shops/:id", app.requireSellerUser(app.updateShopHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/shops/:id", app.requireSellerUser(app.deleteShopHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/shops/:id/verified", app.requirePermission(data.PermissionShopsVerify, app.updateShopVerifiedHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/shops/:id/shipping-rules", app.showShippingRulesHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/shops/:id/shipping-rules", app.requireSellerUser(app.updateShippingRulesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/shops/:id/shipping-quote", app.shippingQuoteHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/shops/:id/coupons", app.requireSellerUser(app.listCouponsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/shops/:id/coupons", app.requireSellerUser(app.createCouponHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/shops/:id/coupons/:coupon_id", app.requireSellerUser(app.deleteCouponHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/shops/:id/promotions", app.requireSellerUser(app.listPromotionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/shops/:id/promotions", app.requireSellerUser(app.createPromotionHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/shops/:id/promotions/:promotion_id", app.requireSellerUser(app.deletePromotionHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/shops/:id/orders/:order_id/status", app.requireSellerUser(app.updateOrderStatusHandler))
 
 	router.HandlerFunc(http.MethodGet, "/v1/product/comments", app.listCommentHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/product/comments", app.createCommentHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/product/comments", app.requireActivatedUser(app.createCommentHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/product/comments/:id", app.showCommentHandler)
-	router.HandlerFunc(http.MethodPut, "/v1/product/comments/:id", app.EditeCommentHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/product/comments/:id", app.deleteCommentHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/product/comments/:id", app.requireActivatedUser(app.updateCommentHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/product/comments/:id", app.requireActivatedUser(app.deleteCommentHandler))
 
 	router.HandlerFunc(http.MethodGet, "/v1/product/categories", app.listCategoryHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/product/categories", app.createCategoryHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/product/category-tree", app.showCategoryTreeHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/product/categories", app.requirePermission(data.PermissionCategoriesWrite, app.createCategoryHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/product/categories/:id", app.showCategoryHandler)
-	router.HandlerFunc(http.MethodPut, "/v1/product/categories/:id", app.updateCategoryHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/product/categories/:id", app.deleteCategoryHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/product/categories/:id", app.requirePermission(data.PermissionCategoriesWrite, app.updateCategoryHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/product/categories/:id", app.requirePermission(data.PermissionCategoriesWrite, app.deleteCategoryHandler))
 
 	router.HandlerFunc(http.MethodGet, "/v1/products", app.listProductsHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/products", app.createProductHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/products", app.requireSellerUser(app.createProductHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/products/:id", app.showProductHandler)
-	router.HandlerFunc(http.MethodPatch, "/v1/products/:id", app.updateProductHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/products/:id", app.deleteProductHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/products/:id", app.requireSellerUser(app.updateProductHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/products/:id", app.requireSellerUser(app.deleteProductHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/products/:id/reservations", app.requireSellerUser(app.listProductReservationsHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/products/:id/images", app.requireSellerUser(app.reorderProductImagesHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/cart/items", app.requireActivatedUser(app.showCartHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/cart/items", app.requireActivatedUser(app.addCartItemHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/cart/items/:id", app.requireActivatedUser(app.updateCartItemHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/cart/items/:id", app.requireActivatedUser(app.deleteCartItemHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/cart/coupon", app.requireActivatedUser(app.applyCartCouponHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/cart/coupon", app.requireActivatedUser(app.removeCartCouponHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/wishlist", app.requireActivatedUser(app.listWishlistHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/wishlist", app.requireActivatedUser(app.addWishlistItemHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/wishlist/:id", app.requireActivatedUser(app.deleteWishlistItemHandler))
+
+	router.HandlerFunc(http.MethodPost, "/v1/orders", app.requireActivatedUser(app.createOrderHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/orders/:id", app.requireActivatedUser(app.showOrderHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/orders/:id/payments", app.requireActivatedUser(app.createPaymentHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/payments/callback", app.paymentCallbackHandler)
+
+	// The fake gateway serves its own payment page, so payments can be completed
+	// without leaving the API.
+	if fake, ok := app.payment.(*payment.FakeGateway); ok {
+		router.Handler(http.MethodGet, "/v1/payments/fake", fake)
+	}
 
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerSellerHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/buyers", app.registerBuyerHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/sellers/me/shops", app.requireSellerUser(app.listSellerShopsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/sellers/me/balance", app.requireSellerUser(app.showSellerBalanceHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/sellers/me/ledger", app.requireSellerUser(app.listSellerLedgerHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/sellers/me/exchange-rates", app.requireSellerUser(app.listExchangeRatesHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/sellers/me/exchange-rates/:currency", app.requireSellerUser(app.updateExchangeRateHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/sellers/me/exchange-rates/:currency", app.requireSellerUser(app.deleteExchangeRateHandler))
+
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationCodeHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetCodeHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication", app.requireAuthenticatedUser(app.deleteAuthenticationTokenHandler))
+
+	router.HandlerFunc(http.MethodPut, "/v1/admin/roles", app.requirePermission(data.PermissionUsersManage, app.updateUserRoleHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/payouts", app.requirePermission(data.PermissionPayoutsManage, app.createPayoutBatchHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/payouts/:id/paid", app.requirePermission(data.PermissionPayoutsManage, app.updatePayoutPaidHandler))
 
-	return app.recoverPanic(app.rateLimit(router))
+	return app.recoverPanic(app.rateLimit(app.authenticate(router)))
 }
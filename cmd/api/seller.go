@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"net/http"
+	"time"
 
 	"misarfeh.com/internal/data"
 	"misarfeh.com/internal/validator"
@@ -75,6 +76,12 @@ func (app *application) registerSellerHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	err = app.sendOneTimeCode(user, 10*time.Minute, data.ScopeActivation, activationMessage)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	output := struct {
 		data.User
 		MeliCode    string `json:"meli_code,omitempty"`
@@ -85,7 +92,7 @@ func (app *application) registerSellerHandler(w http.ResponseWriter, r *http.Req
 		MeliCartUrl: seller.MeliCartUrl,
 	}
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"user": output}, nil)
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"user": output}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
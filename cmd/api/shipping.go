@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"misarfeh.com/internal/data"
+	"misarfeh.com/internal/validator"
+)
+
+// The quoteShipping() helper quotes shipping the priced lines from the shop to the
+// destination. Shops which haven't set shipping rules ship for free.
+func (app *application) quoteShipping(shopID int64, lines []*data.ShippingLine, destination data.Destination) (*data.ShippingQuote, error) {
+	rules, err := app.models.Shipping.GetRules(shopID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	return data.QuoteShipping(rules, lines, destination), nil
+}
+
+func (app *application) showShippingRulesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	rules, err := app.models.Shipping.GetRules(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"shipping_rules": rules}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The updateShippingRulesHandler() replaces the shipping rules of the shop with the
+// given ones.
+func (app *application) updateShippingRulesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	owner, err := app.ownsShop(r, id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !owner {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		FlatRate      int64                `json:"flat_rate"`
+		PerKgRate     int64                `json:"per_kg_rate"`
+		FreeThreshold *int64               `json:"free_threshold"`
+		Provinces     []*data.ProvinceRate `json:"provinces"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	rules := &data.ShippingRules{
+		ShopID:        id,
+		FlatRate:      input.FlatRate,
+		PerKgRate:     input.PerKgRate,
+		FreeThreshold: input.FreeThreshold,
+		Provinces:     input.Provinces,
+	}
+
+	if rules.Provinces == nil {
+		rules.Provinces = []*data.ProvinceRate{}
+	}
+
+	v := validator.New()
+
+	if data.ValidateShippingRules(v, rules); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Shipping.UpsertRules(rules)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"shipping_rules": rules}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The shippingQuoteHandler() quotes shipping the given lines from the shop to the
+// destination, at the current prices of the products.
+func (app *application) shippingQuoteHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Shops.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Items       []*data.ShippingLine `json:"items"`
+		Destination data.Destination     `json:"destination"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(len(input.Items) >= 1, "items", "must contain at least 1 item")
+	v.Check(len(input.Items) <= 100, "items", "must not contain more than 100 items")
+
+	for _, item := range input.Items {
+		v.Check(item.ProductID > 0, "items", "product_id must be provided")
+		v.Check(item.Quantity >= 1 && item.Quantity <= 100, "items", "quantity must be between 1 and 100")
+	}
+
+	if data.ValidateDestination(v, input.Destination); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Shipping.PriceLines(id, input.Items)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("items", "must only contain products and variants of the shop")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	quote, err := app.quoteShipping(id, input.Items, input.Destination)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"quote": quote}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
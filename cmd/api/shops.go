@@ -6,9 +6,52 @@ import (
 	"net/http"
 
 	"misarfeh.com/internal/data"
+	"misarfeh.com/internal/imaging"
 	"misarfeh.com/internal/validator"
 )
 
+// The insertShopRelations() helper creates the countries and categories of the shop if
+// they don't exist yet, and links them to the shop.
+func insertShopRelations(models data.Models, shop *data.Shop) error {
+	// Create or return countries
+	countries, err := models.Countries.GetOrInsert(shop.Countries...)
+	if err != nil {
+		return err
+	}
+
+	// Create or return categories
+	categories, err := models.Categories.GetOrInsert(shop.Categories...)
+	if err != nil {
+		return err
+	}
+
+	// Insert ShopCountry
+	for _, country := range countries {
+		shopCountry := &data.ShopCountry{
+			Shop_id:    shop.ID,
+			Country_id: country.ID,
+		}
+		err = models.ShopCountry.Insert(shopCountry)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Insert ShopCategory
+	for _, category := range categories {
+		shopCategory := &data.ShopCategory{
+			Shop_id:     shop.ID,
+			Category_id: category.ID,
+		}
+		err = models.ShopCategory.Insert(shopCategory)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (app *application) createShopHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		Title        string   `json:"title"`
@@ -30,7 +73,10 @@ func (app *application) createShopHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	user := app.contextGetUser(r)
+
 	shop := &data.Shop{
+		SellerID:     user.ID,
 		Title:        input.Title,
 		Description:  input.Description,
 		Year:         input.Year,
@@ -51,68 +97,47 @@ func (app *application) createShopHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// Create or return countries
-	countries, err := app.models.Countries.GetOrInsert(shop.Countries...)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
-	// Create or return countries
-	categories, err := app.models.Categories.GetOrInsert(shop.Categories...)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
-	err = app.models.Shops.Insert(shop)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
-	// Insert ShopCountry
-	for _, country := range countries {
-		shopCountry := &data.ShopCountry{
-			Shop_id:    shop.ID,
-			Country_id: country.ID,
-		}
-		err = app.models.ShopCountry.Insert(shopCountry)
+	// The shop, its join rows and its images are saved in a single transaction, so a
+	// failure half way through doesn't leave a half-built shop behind.
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		err := tx.Shops.Insert(shop)
 		if err != nil {
-			app.serverErrorResponse(w, r, err)
-			return
+			return err
 		}
-	}
 
-	// Insert ShopCategory
-	for _, category := range categories {
-		shopCategory := &data.ShopCategory{
-			Shop_id:     shop.ID,
-			Category_id: category.ID,
-		}
-		err = app.models.ShopCategory.Insert(shopCategory)
+		err = insertShopRelations(tx, shop)
 		if err != nil {
-			app.serverErrorResponse(w, r, err)
-			return
+			return err
 		}
-	}
 
-	// Insert images
-	for _, url := range shop.ImgUrls {
-		image := &data.Image{
-			Url:    url,
-			ShopID: &shop.ID,
-		}
+		// Attach the uploaded images
+		for i, url := range shop.ImgUrls {
+			image := &data.Image{
+				UserID:   &user.ID,
+				Url:      url,
+				Variants: imaging.Srcset(url),
+				Position: int32(i),
+				ShopID:   &shop.ID,
+			}
 
-		err = app.models.Images.Insert(image)
-		if err != nil {
-			app.serverErrorResponse(w, r, err)
-			return
+			err = tx.Images.Attach(image)
+			if err != nil {
+				return err
+			}
+
+			shop.Images = append(shop.Images, image)
 		}
+
+		return nil
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
 	}
 
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/shops/%d", shop.ID))
+	headers.Set("ETag", etag(shop.Version))
 
 	err = app.writeJSON(w, http.StatusCreated, envelope{"shop": shop}, headers)
 	if err != nil {
@@ -187,9 +212,13 @@ func (app *application) showShopHandler(w http.ResponseWriter, r *http.Request)
 
 	for _, image := range images {
 		shop.ImgUrls = append(shop.ImgUrls, image.Url)
+		shop.Images = append(shop.Images, image)
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"shop": shop}, nil)
+	headers := make(http.Header)
+	headers.Set("ETag", etag(shop.Version))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"shop": shop}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -256,6 +285,16 @@ func (app *application) updateShopHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	if shop.SellerID != app.contextGetUser(r).ID {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	if !app.ifMatch(r, shop.Version) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
 	// fetch countries for shop
 	countries, err := app.models.Countries.GetAllByShopID(shop.ID)
 	if err != nil {
@@ -304,6 +343,7 @@ func (app *application) updateShopHandler(w http.ResponseWriter, r *http.Request
 	err = app.readJSON(w, r, &input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
+		return
 	}
 
 	if input.Title != nil {
@@ -338,6 +378,14 @@ func (app *application) updateShopHandler(w http.ResponseWriter, r *http.Request
 		shop.Categories = input.Categories
 	}
 
+	if input.DeliveryTime != nil {
+		shop.DeliveryTime = *input.DeliveryTime
+	}
+
+	if input.LogoUrl != nil {
+		shop.LogoUrl = *input.LogoUrl
+	}
+
 	v := validator.New()
 
 	if data.ValidateShop(v, shop); !v.Valid() {
@@ -345,98 +393,127 @@ func (app *application) updateShopHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = app.models.Shops.Update(shop)
+	// Update the shop and replace its shops_countries and shops_categories rows in a
+	// single transaction.
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		err := tx.Shops.Update(shop)
+		if err != nil {
+			return err
+		}
+
+		err = tx.ShopCountry.DeleteByShopID(shop.ID)
+		if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+			return err
+		}
+
+		err = tx.ShopCategory.DeleteByShopID(shop.ID)
+		if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+			return err
+		}
+
+		return insertShopRelations(tx, shop)
+	})
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
-	// Delete all shops_countries row for this shop
-	if len(shop.Countries) != 0 {
-		err = app.models.ShopCountry.DeleteByShopID(shop.ID)
-		if err != nil {
-			switch {
-			case errors.Is(err, data.ErrRecordNotFound):
-				app.notFoundResponse(w, r)
-				return
-			default:
-				app.serverErrorResponse(w, r, err)
-				return
-			}
-		}
-	}
+	headers := make(http.Header)
+	headers.Set("ETag", etag(shop.Version))
 
-	// Create or return country_id
-	countries, err = app.models.Countries.GetOrInsert(input.Countries...)
+	err = app.writeJSON(w, http.StatusOK, envelope{"shop": shop}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
-		return
 	}
+}
 
-	// Insert ShopCountry
-	for _, country := range countries {
-		shopCountry := &data.ShopCountry{
-			Shop_id:    shop.ID,
-			Country_id: country.ID,
-		}
-		err = app.models.ShopCountry.Insert(shopCountry)
-		if err != nil {
+func (app *application) deleteShopHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
 
+	shop, err := app.models.Shops.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
 			app.serverErrorResponse(w, r, err)
-			return
 		}
+		return
 	}
 
-	// Delete all shops_categories row for this shop
-	if len(shop.Categories) != 0 {
-		err = app.models.ShopCategory.DeleteByShopID(shop.ID)
-		if err != nil {
-			switch {
-			case errors.Is(err, data.ErrRecordNotFound):
-				app.notFoundResponse(w, r)
-				return
-			default:
-				app.serverErrorResponse(w, r, err)
-				return
-			}
-		}
+	user := app.contextGetUser(r)
+
+	if shop.SellerID != user.ID {
+		app.notPermittedResponse(w, r)
+		return
 	}
 
-	// Create or return category_id
-	categories, err = app.models.Categories.GetOrInsert(input.Categories...)
+	err = app.models.Shops.Delete(shop.ID, user.ID)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrShopHasOrders):
+			app.shopHasOrdersResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
-	// Insert ShopCategory
-	for _, category := range categories {
-		shopCategory := &data.ShopCategory{
-			Shop_id:     shop.ID,
-			Category_id: category.ID,
-		}
-		err = app.models.ShopCategory.Insert(shopCategory)
-		if err != nil {
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "shop succesfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
 
-			app.serverErrorResponse(w, r, err)
-			return
-		}
+func (app *application) listSellerShopsHandler(w http.ResponseWriter, r *http.Request) {
+	shops, err := app.models.Shops.GetAllForSeller(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"shop": shop}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"shops": shops}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
-func (app *application) deleteShopHandler(w http.ResponseWriter, r *http.Request) {
+func (app *application) updateShopVerifiedHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
 	if err != nil {
 		app.notFoundResponse(w, r)
 		return
 	}
 
-	err = app.models.Shops.Delete(id)
+	var input struct {
+		Verified *bool `json:"verified"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.Verified != nil, "verified", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Shops.UpdateVerified(id, *input.Verified)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -447,7 +524,7 @@ func (app *application) deleteShopHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "shop succesfully deleted"}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"shop": envelope{"id": id, "verified": *input.Verified}}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
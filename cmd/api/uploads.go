@@ -0,0 +1,331 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"misarfeh.com/internal/data"
+	"misarfeh.com/internal/storage"
+	"misarfeh.com/internal/validator"
+)
+
+// Resumable uploads send an image in chunks of at most UPLOAD_CHUNK_SIZE bytes, so a
+// dropped connection only loses the chunk in flight. A client creates the upload with
+// POST /v1/uploads, appends chunks with PATCH at the Upload-Offset the server reported
+// and, after an interruption, asks for the offset to resume from with HEAD.
+const UPLOAD_CHUNK_SIZE = 8 << 20
+
+const uploadChunkContentType = "application/offset+octet-stream"
+
+func (app *application) createUploadHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Filename string `json:"filename"`
+		Size     int64  `json:"size"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	upload := &data.Upload{
+		UserID:   user.ID,
+		Filename: input.Filename,
+		Size:     input.Size,
+	}
+
+	v := validator.New()
+
+	if data.ValidateUpload(v, upload, BULK_FILE_SIZE); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		err := tx.Uploads.Insert(upload)
+		if err != nil {
+			return err
+		}
+
+		err = os.MkdirAll(app.config.uploads.dir, 0o700)
+		if err != nil {
+			return err
+		}
+
+		file, err := os.OpenFile(app.uploadPath(upload.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err != nil {
+			return err
+		}
+
+		return file.Close()
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := app.uploadHeaders(upload)
+	headers.Set("Location", fmt.Sprintf("/v1/uploads/%d", upload.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"upload": upload}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The showUploadHandler() serves both GET and HEAD requests. The Upload-Offset header
+// tells a client which byte to resume the upload from.
+func (app *application) showUploadHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	upload, err := app.models.Uploads.Get(id, user.ID, false)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if upload.ImageID != nil {
+		upload.Image, err = app.models.Images.Get(*upload.ImageID)
+		if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"upload": upload}, app.uploadHeaders(upload))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The appendUploadHandler() writes a chunk to the upload at the offset given in the
+// Upload-Offset header, which must be the current offset of the upload. Once the last
+// chunk arrived the file is validated and processed like the files of
+// uploadImagesHandler(), and the image is returned with the upload. An empty chunk at
+// the end of a finished upload retries processing it.
+func (app *application) appendUploadHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if r.Header.Get("Content-Type") != uploadChunkContentType {
+		message := fmt.Sprintf("chunks must be sent with the %s content type", uploadChunkContentType)
+		app.errorResponse(w, r, http.StatusUnsupportedMediaType, message)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		app.badRequestResponse(w, r, errors.New("missing or invalid Upload-Offset header"))
+		return
+	}
+
+	if r.ContentLength > UPLOAD_CHUNK_SIZE {
+		message := fmt.Sprintf("chunks must not be larger than %d bytes", UPLOAD_CHUNK_SIZE)
+		app.errorResponse(w, r, http.StatusRequestEntityTooLarge, message)
+		return
+	}
+
+	// The chunk is read completely before the upload is locked, so a slow connection
+	// doesn't hold the lock.
+	r.Body = http.MaxBytesReader(w, r.Body, UPLOAD_CHUNK_SIZE)
+
+	chunk, err := io.ReadAll(r.Body)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	var upload *data.Upload
+
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		var err error
+
+		upload, err = tx.Uploads.Get(id, user.ID, true)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Uploads.Advance(upload, offset, int64(len(chunk)))
+		if err != nil {
+			return err
+		}
+
+		// The offset is only committed once the chunk is written, a chunk which
+		// failed to be written is sent again.
+		file, err := os.OpenFile(app.uploadPath(upload.ID), os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+
+		_, err = file.WriteAt(chunk, offset)
+		if err != nil {
+			file.Close()
+			return err
+		}
+
+		return file.Close()
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrUploadOffsetMismatch):
+			message := "the upload offset doesn't match, request the current offset and resume from it"
+			app.errorResponse(w, r, http.StatusConflict, message)
+		case errors.Is(err, data.ErrUploadTooLong):
+			v := validator.New()
+			v.AddError("chunk", "must not go past the size of the upload")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if upload.Complete() && upload.ImageID == nil {
+		buf, err := os.ReadFile(app.uploadPath(upload.ID))
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		variants, err := processUpload(upload.Filename, buf)
+		if err != nil {
+			// The file will never be valid, so the upload is dropped.
+			app.deleteUpload(upload.ID, user.ID)
+
+			v := validator.New()
+			v.AddError("file", err.Error())
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		image, _, err := app.storeUpload(r.Context(), user.ID, storage.Hash(buf), variants)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.models.Uploads.SetImage(upload, image.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.removeUploadFile(upload.ID)
+
+		upload.Image = image
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"upload": upload}, app.uploadHeaders(upload))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deleteUploadHandler() cancels an upload. An image it already produced stays
+// pending until it is used or swept.
+func (app *application) deleteUploadHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.deleteUpload(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "upload successfully cancelled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteUpload(id, userID int64) error {
+	err := app.models.Uploads.Delete(id, userID)
+	if err != nil {
+		return err
+	}
+
+	app.removeUploadFile(id)
+
+	return nil
+}
+
+// The deleteExpiredUploads() job deletes the uploads older than the uploads TTL and
+// their temporary files.
+func (app *application) deleteExpiredUploads() error {
+	ids, err := app.models.Uploads.DeleteExpired(app.config.uploads.ttl)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		app.removeUploadFile(id)
+	}
+
+	if len(ids) > 0 {
+		app.logger.PrintInfo("deleted expired uploads", map[string]string{
+			"count": strconv.Itoa(len(ids)),
+		})
+	}
+
+	return nil
+}
+
+func (app *application) uploadPath(id int64) string {
+	return filepath.Join(app.config.uploads.dir, strconv.FormatInt(id, 10)+".part")
+}
+
+// The removeUploadFile() helper removes the temporary file of an upload. Finished
+// uploads have none, and failures are only logged.
+func (app *application) removeUploadFile(id int64) {
+	err := os.Remove(app.uploadPath(id))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		app.logger.PrintError(err, map[string]string{"upload": strconv.FormatInt(id, 10)})
+	}
+}
+
+func (app *application) uploadHeaders(upload *data.Upload) http.Header {
+	headers := make(http.Header)
+	headers.Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	headers.Set("Upload-Length", strconv.FormatInt(upload.Size, 10))
+	headers.Set("Cache-Control", "no-store")
+
+	return headers
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"misarfeh.com/internal/data"
+	"misarfeh.com/internal/validator"
+)
+
+const (
+	activationMessage    = "کد فعال‌سازی شما در میصرفه: %s"
+	passwordResetMessage = "کد بازیابی رمز عبور شما در میصرفه: %s"
+)
+
+// The sendOneTimeCode() helper creates a new code for the user and sends it to the
+// user's phone in a background goroutine. The message must contain a single %s verb
+// which is replaced by the code.
+func (app *application) sendOneTimeCode(user *data.User, ttl time.Duration, scope, message string) error {
+	code, err := app.models.OneTimeCodes.New(user.ID, ttl, scope)
+	if err != nil {
+		return err
+	}
+
+	phone := user.Phone
+
+	app.background(func() {
+		err := app.sms.Send(phone, fmt.Sprintf(message, code.Plaintext))
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{
+				"scope": scope,
+			})
+		}
+	})
+
+	return nil
+}
+
+func (app *application) registerBuyerHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		FistName string `json:"first_name"`
+		LastName string `json:"last_name"`
+		Phone    string `json:"phone"`
+		Email    string `json:"email,omitempty"`
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := &data.User{
+		FirstName: input.FistName,
+		LastName:  input.LastName,
+		Phone:     input.Phone,
+		Email:     input.Email,
+	}
+
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Users.Insert(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicatePhone):
+			v.AddError("phone", "a user with this phone number already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.sendOneTimeCode(user, 10*time.Minute, data.ScopeActivation, activationMessage)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createActivationCodeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Phone string `json:"phone"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidatePhone(v, input.Phone); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetByEmailPhone(input.Phone)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("phone", "no matching account found")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.Activated {
+		v.AddError("phone", "user has already been activated")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.sendOneTimeCode(user, 10*time.Minute, data.ScopeActivation, activationMessage)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrCodeRecentlySent), errors.Is(err, data.ErrTooManyAttempts):
+			app.tooManyAttemptsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{"message": "an sms will be sent to you containing activation code"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Phone string `json:"phone"`
+		Code  string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidatePhone(v, input.Phone)
+	data.ValidateCodePlaintext(v, input.Code)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetByEmailPhone(input.Phone)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("code", "invalid or expired activation code")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.OneTimeCodes.Check(user.ID, data.ScopeActivation, input.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidCode):
+			v.AddError("code", "invalid or expired activation code")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrTooManyAttempts):
+			app.tooManyAttemptsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user.Activated = true
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The updateUserRoleHandler() replaces the staff permissions of a user with the
+// permission set of the given role. Granting the buyer role removes all staff
+// permissions. The seller role can't be granted here, since a seller also needs the
+// national code and bank card which seller registration asks for.
+func (app *application) updateUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		UserID int64  `json:"user_id"`
+		Role   string `json:"role"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.UserID > 0, "user_id", "must be provided")
+	v.Check(validator.In(input.Role, data.RoleBuyer, data.RoleSeller, data.RoleModerator, data.RoleAdmin), "role", "invalid role")
+	v.Check(input.Role != data.RoleSeller, "role", "can't be granted, sellers must sign up through seller registration")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.Get(input.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("user_id", "no matching user found")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	permissions := data.RolePermissions[input.Role]
+
+	// The permissions are replaced in a transaction, so a failure can't leave the user
+	// without any.
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		err := tx.Permissions.DeleteAllForUser(user.ID)
+		if err != nil {
+			return err
+		}
+
+		if len(permissions) == 0 {
+			return nil
+		}
+
+		return tx.Permissions.AddForUser(user.ID, permissions...)
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user, "permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The createPasswordResetCodeHandler() sends a password reset code to the phone number,
+// if it belongs to a user. The response is the same whether or not the phone number is
+// registered so it can't be used to discover accounts.
+func (app *application) createPasswordResetCodeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Phone string `json:"phone"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidatePhone(v, input.Phone); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetByEmailPhone(input.Phone)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if user != nil {
+		err = app.sendOneTimeCode(user, 15*time.Minute, data.ScopePasswordReset, passwordResetMessage)
+		if err != nil && !errors.Is(err, data.ErrCodeRecentlySent) && !errors.Is(err, data.ErrTooManyAttempts) {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	env := envelope{"message": "if the phone number is registered, an sms will be sent to you containing password reset code"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Phone    string `json:"phone"`
+		Code     string `json:"code"`
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidatePhone(v, input.Phone)
+	data.ValidateCodePlaintext(v, input.Code)
+	data.ValidatePasswordPlaintext(v, input.Password)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetByEmailPhone(input.Phone)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("code", "invalid or expired password reset code")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Running out of attempts gets the same response as an unknown phone number, a
+	// different one would tell which phone numbers are registered.
+	err = app.models.OneTimeCodes.Check(user.ID, data.ScopePasswordReset, input.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidCode), errors.Is(err, data.ErrTooManyAttempts):
+			v.AddError("code", "invalid or expired password reset code")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Log the user out everywhere, anyone holding an old session loses it together
+	// with the old password.
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeAuthentication, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "your password was successfully reset"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"misarfeh.com/internal/storage"
+)
+
+// The verifyImages() command re-hashes every file referenced by an image and logs the
+// ones which are missing from the storage or whose content no longer matches their
+// hash. It returns the number of files with problems.
+func (app *application) verifyImages() (int, error) {
+	files, err := app.models.Images.GetAllStoredFiles()
+	if err != nil {
+		return 0, err
+	}
+
+	var missing, corrupted int
+
+	for _, file := range files {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err := storage.Verify(ctx, app.storage, file.Key)
+		cancel()
+
+		if err == nil {
+			continue
+		}
+
+		ids := make([]string, len(file.ImageIDs))
+		for i, id := range file.ImageIDs {
+			ids[i] = strconv.FormatInt(id, 10)
+		}
+
+		properties := map[string]string{
+			"key":    file.Key,
+			"images": strings.Join(ids, ","),
+		}
+
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			missing++
+			app.logger.PrintInfo("image file missing", properties)
+		case errors.Is(err, storage.ErrCorrupted):
+			corrupted++
+			app.logger.PrintInfo("image file corrupted", properties)
+		default:
+			return 0, fmt.Errorf("verifying %s: %w", file.Key, err)
+		}
+	}
+
+	app.logger.PrintInfo("verified image files", map[string]string{
+		"checked":   strconv.Itoa(len(files)),
+		"missing":   strconv.Itoa(missing),
+		"corrupted": strconv.Itoa(corrupted),
+	})
+
+	return missing + corrupted, nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"misarfeh.com/internal/data"
+	"misarfeh.com/internal/notify"
+	"misarfeh.com/internal/validator"
+)
+
+func (app *application) listWishlistHandler(w http.ResponseWriter, r *http.Request) {
+	items, err := app.models.Wishlist.GetAllForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"wishlist": items}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) addWishlistItemHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ProductID int64 `json:"product_id"`
+		VariantID int64 `json:"variant_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.ProductID > 0, "product_id", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	item, err := app.models.Wishlist.Insert(app.contextGetUser(r).ID, input.ProductID, input.VariantID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("product_id", "no matching product or variant found")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateWishlistItem):
+			v.AddError("product_id", "is already in the wishlist")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"wishlist_item": item}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteWishlistItemHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Wishlist.Delete(app.contextGetUser(r).ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "wishlist item successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The notifyWishlists() job queues notifications for the price drops and restocks of
+// wishlisted products, then delivers the queued notifications through the notifier.
+// Notifications which fail are retried on the next run.
+func (app *application) notifyWishlists() error {
+	count, err := app.models.Wishlist.DetectChanges()
+	if err != nil {
+		return err
+	}
+
+	if count > 0 {
+		app.logger.PrintInfo("queued wishlist notifications", map[string]string{
+			"count": strconv.FormatInt(count, 10),
+		})
+	}
+
+	notifications, err := app.models.Notifications.GetUnsent(100)
+	if err != nil {
+		return err
+	}
+
+	for _, n := range notifications {
+		var productID int64
+		if n.ProductID != nil {
+			productID = *n.ProductID
+		}
+
+		err := app.notifier.Notify(&notify.Notification{
+			ID:        n.ID,
+			UserID:    n.UserID,
+			Phone:     n.Phone,
+			Kind:      n.Kind,
+			ProductID: productID,
+			Message:   n.Message,
+		})
+
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{
+				"notification_id": strconv.FormatInt(n.ID, 10),
+			})
+
+			err = app.models.Notifications.MarkFailed(n.ID)
+		} else {
+			err = app.models.Notifications.MarkSent(n.ID)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -3,8 +3,9 @@ module misarfeh.com
 go 1.19
 
 require (
-	github.com/julienschmidt/httprouter v1.3.0 // indirect
-	github.com/lib/pq v1.10.0 // indirect
-	golang.org/x/crypto v0.12.0 // indirect
-	golang.org/x/time v0.3.0 // indirect
+	github.com/chai2010/webp v1.4.0
+	github.com/julienschmidt/httprouter v1.3.0
+	github.com/lib/pq v1.10.0
+	golang.org/x/crypto v0.12.0
+	golang.org/x/time v0.3.0
 )
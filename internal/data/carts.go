@@ -0,0 +1,387 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"misarfeh.com/internal/validator"
+)
+
+var (
+	ErrInvalidQuantity = errors.New("invalid quantity")
+)
+
+// CartItem is a line in a buyer's cart. SalePrice and Off are a snapshot of the product
+// taken when it was added, so a later change of the product price is reported through
+// PriceChanged instead of being applied silently.
+type CartItem struct {
+	ID               int64  `json:"id"`
+	ProductID        int64  `json:"product_id"`
+	VariantID        *int64 `json:"variant_id,omitempty"`
+	ProductName      string `json:"product_name"`
+	SKU              string `json:"sku,omitempty"`
+	ShopID           int64  `json:"-"`
+	CategoryID       int64  `json:"-"`
+	Weight           int32  `json:"-"`
+	Quantity         int32  `json:"quantity"`
+	SalePrice        int64  `json:"sale_price"`
+	Off              int32  `json:"off"`
+	CurrentSalePrice int64  `json:"current_sale_price"`
+	CurrentOff       int32  `json:"current_off"`
+	PriceChanged     bool   `json:"price_changed"`
+	Subtotal         int64  `json:"subtotal"`
+}
+
+// CartShop groups the items of a cart which are shipped together by one shop.
+type CartShop struct {
+	ShopID            int64       `json:"shop_id"`
+	ShopTitle         string      `json:"shop_title"`
+	DeliveryTime      int8        `json:"delivery_time"`
+	EstimatedDelivery time.Time   `json:"estimated_delivery"`
+	Items             []*CartItem `json:"items"`
+	Subtotal          int64       `json:"subtotal"`
+}
+
+// CartCoupon is the coupon applied to a cart, with the discount it gives on the current
+// items or the reason it gives none.
+type CartCoupon struct {
+	Code     string `json:"code"`
+	ShopID   int64  `json:"shop_id"`
+	Discount int64  `json:"discount"`
+	Error    string `json:"error,omitempty"`
+}
+
+type Cart struct {
+	Shops    []*CartShop `json:"shops"`
+	CouponID *int64      `json:"-"`
+	Coupon   *CartCoupon `json:"coupon,omitempty"`
+	Discount int64       `json:"discount"`
+	Total    int64       `json:"total"`
+}
+
+// The ApplyCoupon() method shows the discount of the coupon on the cart and takes it off
+// the total.
+func (c *Cart) ApplyCoupon(coupon *Coupon, now time.Time) {
+	c.Coupon = &CartCoupon{Code: coupon.Code, ShopID: coupon.ShopID}
+
+	err := ErrCouponNotApplicable
+
+	for _, shop := range c.Shops {
+		if shop.ShopID == coupon.ShopID {
+			c.Coupon.Discount, err = coupon.Discount(shop, now)
+		}
+	}
+
+	if err != nil {
+		c.Coupon.Error = err.Error()
+	}
+
+	c.Discount = c.Coupon.Discount
+	c.Total -= c.Discount
+}
+
+// EstimatedDelivery returns the date an order placed now is expected to arrive, given
+// the delivery time of the shop in weeks.
+func EstimatedDelivery(from time.Time, deliveryTime int8) time.Time {
+	return from.AddDate(0, 0, 7*int(deliveryTime))
+}
+
+func ValidateCartItemQuantity(v *validator.Validator, quantity int32) {
+	v.Check(quantity != 0, "quantity", "must be provided")
+	v.Check(quantity >= 1, "quantity", "must be greater than zero")
+	v.Check(quantity <= 100, "quantity", "must not be more than 100")
+}
+
+type CartModel struct {
+	DB DBTX
+}
+
+// The getCartID() helper returns the id of the cart of the user, creating the cart
+// when the user doesn't have one yet.
+func (m CartModel) getCartID(ctx context.Context, userID int64) (int64, error) {
+	query := `
+		INSERT INTO carts (user_id)
+		VALUES ($1)
+		ON CONFLICT (user_id) DO UPDATE SET user_id = EXCLUDED.user_id
+		RETURNING id`
+
+	var cartID int64
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&cartID)
+	return cartID, err
+}
+
+// The Get() method returns the cart of the user with its items grouped per shop, and
+// the id of the coupon applied to it.
+func (m CartModel) Get(userID int64) (*Cart, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	cart := &Cart{Shops: []*CartShop{}}
+
+	err := m.DB.QueryRowContext(ctx, `SELECT coupon_id FROM carts WHERE user_id = $1`, userID).Scan(&cart.CouponID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	query := `
+		SELECT cart_items.id, cart_items.product_id, cart_items.variant_id, products.name,
+			COALESCE(product_variants.sku, ''), products.shop_id, COALESCE(products.category_id, 0),
+			shops.title, shops.delivery_time,
+			cart_items.quantity, cart_items.sale_price, cart_items.off,
+			COALESCE(product_variants.sale_price, products.sale_price), products.off, products.weight
+		FROM cart_items
+		JOIN carts ON cart_items.cart_id = carts.id
+		JOIN products ON cart_items.product_id = products.id
+		JOIN shops ON products.shop_id = shops.id
+		LEFT JOIN product_variants ON cart_items.variant_id = product_variants.id
+		WHERE carts.user_id = $1
+		ORDER BY shops.id, cart_items.id`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	now := time.Now()
+
+	var shop *CartShop
+
+	for rows.Next() {
+		var item CartItem
+		var shopTitle string
+		var deliveryTime int8
+
+		err := rows.Scan(
+			&item.ID,
+			&item.ProductID,
+			&item.VariantID,
+			&item.ProductName,
+			&item.SKU,
+			&item.ShopID,
+			&item.CategoryID,
+			&shopTitle,
+			&deliveryTime,
+			&item.Quantity,
+			&item.SalePrice,
+			&item.Off,
+			&item.CurrentSalePrice,
+			&item.CurrentOff,
+			&item.Weight,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		item.PriceChanged = item.SalePrice != item.CurrentSalePrice || item.Off != item.CurrentOff
+		item.Subtotal = FinalPrice(item.SalePrice, item.Off) * int64(item.Quantity)
+
+		// The rows are ordered by shop, so a new shop id starts a new group.
+		if shop == nil || shop.ShopID != item.ShopID {
+			shop = &CartShop{
+				ShopID:            item.ShopID,
+				ShopTitle:         shopTitle,
+				DeliveryTime:      deliveryTime,
+				EstimatedDelivery: EstimatedDelivery(now, deliveryTime),
+				Items:             []*CartItem{},
+			}
+			cart.Shops = append(cart.Shops, shop)
+		}
+
+		shop.Items = append(shop.Items, &item)
+		shop.Subtotal += item.Subtotal
+		cart.Total += item.Subtotal
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return cart, nil
+}
+
+// The SetCoupon() method applies the coupon to the cart of the user, replacing the
+// coupon applied before. A nil couponID removes the coupon.
+func (m CartModel) SetCoupon(userID int64, couponID *int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	cartID, err := m.getCartID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.DB.ExecContext(ctx, `UPDATE carts SET coupon_id = $2 WHERE id = $1`, cartID, couponID)
+	return err
+}
+
+// The AddItem() method adds a product to the cart of the user and snapshots its price.
+// A variantID of 0 adds a product without variants. Adding a product which is already
+// in the cart increases its quantity, and keeps the original snapshot.
+func (m CartModel) AddItem(userID, productID, variantID int64, quantity int32) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	cartID, err := m.getCartID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO cart_items (cart_id, product_id, variant_id, quantity, sale_price, off)
+		SELECT $1, products.id, product_variants.id, $4,
+			COALESCE(product_variants.sale_price, products.sale_price), products.off
+		FROM products
+		LEFT JOIN product_variants ON product_variants.id = $3 AND product_variants.product_id = products.id
+		WHERE products.id = $2 AND ($3 = 0 OR product_variants.id IS NOT NULL)
+		ON CONFLICT (cart_id, product_id, (COALESCE(variant_id, 0))) DO UPDATE
+		SET quantity = cart_items.quantity + EXCLUDED.quantity
+		RETURNING id`
+
+	var itemID int64
+
+	err = m.DB.QueryRowContext(ctx, query, cartID, productID, variantID, quantity).Scan(&itemID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		case err.Error() == `pq: new row for relation "cart_items" violates check constraint "cart_items_quantity_check"`:
+			return ErrInvalidQuantity
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The UpdateItem() method changes the quantity of a cart item. When acceptPrice is set
+// the price snapshot is replaced with the current price of the product.
+func (m CartModel) UpdateItem(userID, itemID int64, quantity int32, acceptPrice bool) error {
+	query := `
+		UPDATE cart_items
+		SET quantity = $3,
+			sale_price = CASE WHEN $4 THEN COALESCE((SELECT product_variants.sale_price
+				FROM product_variants WHERE product_variants.id = cart_items.variant_id),
+				products.sale_price) ELSE cart_items.sale_price END,
+			off = CASE WHEN $4 THEN products.off ELSE cart_items.off END
+		FROM carts, products
+		WHERE cart_items.id = $2
+		AND cart_items.cart_id = carts.id AND carts.user_id = $1
+		AND cart_items.product_id = products.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, itemID, quantity, acceptPrice)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m CartModel) GetItem(userID, itemID int64) (*CartItem, error) {
+	query := `
+		SELECT cart_items.id, cart_items.product_id, cart_items.variant_id, cart_items.quantity,
+			cart_items.sale_price, cart_items.off
+		FROM cart_items
+		JOIN carts ON cart_items.cart_id = carts.id
+		WHERE cart_items.id = $2 AND carts.user_id = $1`
+
+	var item CartItem
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID, itemID).Scan(
+		&item.ID,
+		&item.ProductID,
+		&item.VariantID,
+		&item.Quantity,
+		&item.SalePrice,
+		&item.Off,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &item, nil
+}
+
+func (m CartModel) DeleteItem(userID, itemID int64) error {
+	query := `
+		DELETE FROM cart_items
+		USING carts
+		WHERE cart_items.id = $2
+		AND cart_items.cart_id = carts.id AND carts.user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, itemID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// The DeleteItems() method removes the given items from the cart of the user. It
+// returns ErrEditConflict unless every item was deleted, which happens when the cart
+// was changed or checked out by a concurrent request.
+func (m CartModel) DeleteItems(userID int64, itemIDs []int64) error {
+	query := `
+		DELETE FROM cart_items
+		USING carts
+		WHERE cart_items.id = ANY($2)
+		AND cart_items.cart_id = carts.id AND carts.user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, pq.Array(itemIDs))
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected != int64(len(itemIDs)) {
+		return ErrEditConflict
+	}
+
+	return nil
+}
@@ -9,10 +9,26 @@ import (
 	"misarfeh.com/internal/validator"
 )
 
+var (
+	ErrDuplicateCategory = errors.New("duplicate category")
+	ErrCategoryInUse     = errors.New("category in use")
+	ErrCategoryCycle     = errors.New("category cycle")
+)
+
 type Category struct {
-	ID     int64  `josn:"id"`
-	Name   string `josn:"name"`
-	ImgUrl string `json:"img_url"`
+	ID       int64  `json:"id"`
+	ParentID *int64 `json:"parent_id,omitempty"`
+	Name     string `json:"name"`
+	ImgUrl   string `json:"img_url"`
+}
+
+// CategoryNode is a category in the category tree. The counts include the products and
+// shops of all the descendants of the category, a shop is only counted once.
+type CategoryNode struct {
+	Category
+	ProductCount int64           `json:"product_count"`
+	ShopCount    int64           `json:"shop_count"`
+	Children     []*CategoryNode `json:"children,omitempty"`
 }
 
 func ValidateCategory(v *validator.Validator, category *Category) {
@@ -20,18 +36,24 @@ func ValidateCategory(v *validator.Validator, category *Category) {
 	v.Check(len(category.Name) <= 100, "name", "must not be more than 100 bytes long")
 
 	v.Check(category.ImgUrl != "", "img_url", "must be provided")
+
+	if category.ParentID != nil {
+		v.Check(*category.ParentID > 0, "parent_id", "must be greater than zero")
+		v.Check(*category.ParentID != category.ID, "parent_id", "must not be the category itself")
+	}
 }
 
 type CategoryModel struct {
-	DB *sql.DB
+	DB DBTX
 }
 
 func (m CategoryModel) GetAll(category *Category) ([]*Category, error) {
 	query := `
-		SELECT id, name, img_url
+		SELECT id, parent_id, name, COALESCE(img_url, '')
 		FROM categories
 		WHERE (id = $1 OR $1 = 0)
-		AND (name = $2 OR $2 = '')`
+		AND (name = $2 OR $2 = '')
+		ORDER BY name ASC`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -50,6 +72,7 @@ func (m CategoryModel) GetAll(category *Category) ([]*Category, error) {
 
 		err := rows.Scan(
 			&category.ID,
+			&category.ParentID,
 			&category.Name,
 			&category.ImgUrl,
 		)
@@ -70,14 +93,24 @@ func (m CategoryModel) GetAll(category *Category) ([]*Category, error) {
 
 func (m CategoryModel) Insert(category *Category) error {
 	query := `
-		INSERT INTO categories (name, img_url)
-		VALUES ($1, $2)
+		INSERT INTO categories (name, img_url, parent_id)
+		VALUES ($1, $2, $3)
 		RETURNING id`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return m.DB.QueryRowContext(ctx, query, category.Name, category.ImgUrl).Scan(&category.ID)
+	err := m.DB.QueryRowContext(ctx, query, category.Name, category.ImgUrl, category.ParentID).Scan(&category.ID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "categories_name_key"`:
+			return ErrDuplicateCategory
+		default:
+			return err
+		}
+	}
+
+	return nil
 }
 
 func (m CategoryModel) GetOrInsert(names ...string) ([]*Category, error) {
@@ -157,7 +190,7 @@ func (m CategoryModel) Get(id int64) (*Category, error) {
 	}
 
 	query := `
-		SELECT id, name
+		SELECT id, parent_id, name, COALESCE(img_url, '')
 		FROM categories 
 		WHERE id = $1`
 
@@ -168,7 +201,9 @@ func (m CategoryModel) Get(id int64) (*Category, error) {
 
 	err := m.DB.QueryRowContext(ctx, query, id).Scan(
 		&category.ID,
+		&category.ParentID,
 		&category.Name,
+		&category.ImgUrl,
 	)
 
 	if err != nil {
@@ -182,3 +217,259 @@ func (m CategoryModel) Get(id int64) (*Category, error) {
 
 	return &category, nil
 }
+
+// The Update() method returns ErrCategoryCycle if the new parent of the category is the
+// category itself or one of its descendants.
+func (m CategoryModel) Update(category *Category) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	if category.ParentID != nil {
+		// Categories are re-parented one at a time, otherwise two concurrent updates
+		// could each pass the check below and together create a cycle. The lock
+		// conflicts with itself and other writes, but not with reads.
+		_, err = tx.ExecContext(ctx, `LOCK TABLE categories IN SHARE ROW EXCLUSIVE MODE`)
+		if err != nil {
+			return err
+		}
+
+		query := `
+			WITH RECURSIVE ancestors AS (
+				SELECT id, parent_id FROM categories WHERE id = $1
+				UNION
+				SELECT categories.id, categories.parent_id
+				FROM categories
+				JOIN ancestors ON categories.id = ancestors.parent_id
+			)
+			SELECT EXISTS(SELECT 1 FROM ancestors WHERE id = $2)`
+
+		var cycle bool
+
+		err := tx.QueryRowContext(ctx, query, *category.ParentID, category.ID).Scan(&cycle)
+		if err != nil {
+			return err
+		}
+
+		if cycle {
+			return ErrCategoryCycle
+		}
+	}
+
+	query := `
+		UPDATE categories
+		SET name = $1, img_url = $2, parent_id = $3
+		WHERE id = $4
+		RETURNING id`
+
+	args := []interface{}{category.Name, category.ImgUrl, category.ParentID, category.ID}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&category.ID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "categories_name_key"`:
+			return ErrDuplicateCategory
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// The Delete() method removes a category and moves its children up to its parent.
+// When products, coupons or promotions still reference the category it returns
+// ErrCategoryInUse, unless moveToParent is set and the category has a parent, in which
+// case the products, shops, coupons and promotions are moved to the parent category
+// first.
+func (m CategoryModel) Delete(id int64, moveToParent bool) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	var parentID *int64
+
+	err = tx.QueryRowContext(ctx, `SELECT parent_id FROM categories WHERE id = $1 FOR UPDATE`, id).Scan(&parentID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	var inUse bool
+
+	query := `
+		SELECT EXISTS (SELECT 1 FROM products WHERE category_id = $1)
+			OR EXISTS (SELECT 1 FROM coupons WHERE category_id = $1)
+			OR EXISTS (SELECT 1 FROM promotions WHERE category_id = $1)`
+
+	err = tx.QueryRowContext(ctx, query, id).Scan(&inUse)
+	if err != nil {
+		return err
+	}
+
+	if inUse && (!moveToParent || parentID == nil) {
+		return ErrCategoryInUse
+	}
+
+	if moveToParent && parentID != nil {
+		queries := []string{
+			`UPDATE products SET category_id = $2 WHERE category_id = $1`,
+			`INSERT INTO shops_categories (shop_id, category_id)
+				SELECT shop_id, $2 FROM shops_categories WHERE category_id = $1
+				ON CONFLICT DO NOTHING`,
+			`UPDATE coupons SET category_id = $2 WHERE category_id = $1`,
+			`UPDATE promotions SET category_id = $2 WHERE category_id = $1`,
+		}
+
+		for _, query := range queries {
+			_, err = tx.ExecContext(ctx, query, id, *parentID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE categories SET parent_id = $2 WHERE parent_id = $1`, id, parentID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM categories WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The GetTree() method returns the root categories, each with its descendants and the
+// number of products and shops in the subtree.
+func (m CategoryModel) GetTree() ([]*CategoryNode, error) {
+	query := `
+		SELECT categories.id, categories.parent_id, categories.name, COALESCE(categories.img_url, ''),
+			(SELECT COUNT(*) FROM products WHERE products.category_id = categories.id)
+		FROM categories
+		ORDER BY categories.name ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	nodes := []*CategoryNode{}
+	byID := make(map[int64]*CategoryNode)
+
+	for rows.Next() {
+		var node CategoryNode
+
+		err := rows.Scan(
+			&node.ID,
+			&node.ParentID,
+			&node.Name,
+			&node.ImgUrl,
+			&node.ProductCount,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		nodes = append(nodes, &node)
+		byID[node.ID] = &node
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	shopCategories, err := m.DB.QueryContext(ctx, `SELECT shop_id, category_id FROM shops_categories`)
+	if err != nil {
+		return nil, err
+	}
+
+	defer shopCategories.Close()
+
+	shops := make(map[int64]map[int64]bool)
+
+	for shopCategories.Next() {
+		var shopID, categoryID int64
+
+		err := shopCategories.Scan(&shopID, &categoryID)
+		if err != nil {
+			return nil, err
+		}
+
+		// Mark the shop on the category and all of its ancestors.
+		for node := byID[categoryID]; node != nil; {
+			if shops[node.ID] == nil {
+				shops[node.ID] = make(map[int64]bool)
+			}
+			shops[node.ID][shopID] = true
+
+			if node.ParentID == nil {
+				break
+			}
+			node = byID[*node.ParentID]
+		}
+	}
+
+	if err = shopCategories.Err(); err != nil {
+		return nil, err
+	}
+
+	roots := []*CategoryNode{}
+
+	for _, node := range nodes {
+		node.ShopCount = int64(len(shops[node.ID]))
+
+		if node.ParentID == nil || byID[*node.ParentID] == nil {
+			roots = append(roots, node)
+			continue
+		}
+
+		parent := byID[*node.ParentID]
+		parent.Children = append(parent.Children, node)
+	}
+
+	// Products are only counted on their own category, add them up the tree.
+	var sumProducts func(node *CategoryNode) int64
+	sumProducts = func(node *CategoryNode) int64 {
+		for _, child := range node.Children {
+			node.ProductCount += sumProducts(child)
+		}
+		return node.ProductCount
+	}
+
+	for _, root := range roots {
+		sumProducts(root)
+	}
+
+	return roots, nil
+}
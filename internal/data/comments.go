@@ -1,32 +1,336 @@
 package data
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
 	"time"
 
 	"misarfeh.com/internal/validator"
 )
 
+var (
+	ErrDuplicateComment = errors.New("duplicate comment")
+)
+
 type Comment struct {
 	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"-"`
-	Text      string    `json:"text"`
-	Phone     string    `json:"phone"`
+	CreatedAt time.Time `json:"created_at"`
+	ProductID int64     `json:"product_id"`
+	UserID    int64     `json:"-"`
 	Username  string    `json:"username"`
+	Text      string    `json:"text"`
 	Rate      int8      `json:"rate"`
+	Version   int       `json:"-"`
 }
 
 func ValidateComment(v *validator.Validator, comment *Comment) {
+	v.Check(comment.ProductID > 0, "product_id", "must be provided")
+
 	v.Check(comment.Text != "", "text", "must be provided")
 	v.Check(len(comment.Text) <= 1000, "text", "must not be more than 1000 bytes long")
 
-	v.Check(comment.Phone != "", "phone", "must be provided")
-	v.Check(len(comment.Phone) == 11, "phone", "must be 11 bytes long")
-	v.Check(comment.Phone[0:2] == "09", "phone", "must be start with 09")
-
-	v.Check(comment.Username != "", "username", "must be provided")
-	v.Check(len(comment.Username) <= 100, "username", "must not be more than 100 bytes long")
-
 	v.Check(comment.Rate != 0, "rate", "must be provided")
 	v.Check(comment.Rate >= 1, "rate", "must be greater than 0")
 	v.Check(comment.Rate <= 5, "rate", "must be lesser than 5")
 }
+
+type CommentModel struct {
+	DB DBTX
+}
+
+// The lockShop() helper locks the shop which sells the product the comment matching
+// query is on and returns its ID. Changes to the comments of a shop take this lock
+// before changing the comments table, so a concurrent change only recomputes the
+// rating once the first one committed, and sees its comment.
+func lockShop(ctx context.Context, db DBTX, query string, arg int64) (int64, error) {
+	var shopID int64
+
+	err := db.QueryRowContext(ctx, query, arg).Scan(&shopID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return shopID, nil
+}
+
+func lockShopOfProduct(ctx context.Context, db DBTX, productID int64) (int64, error) {
+	query := `
+		SELECT shops.id
+		FROM shops
+		JOIN products ON products.shop_id = shops.id
+		WHERE products.id = $1
+		FOR UPDATE OF shops`
+
+	return lockShop(ctx, db, query, productID)
+}
+
+func lockShopOfComment(ctx context.Context, db DBTX, commentID int64) (int64, error) {
+	query := `
+		SELECT shops.id
+		FROM shops
+		JOIN products ON products.shop_id = shops.id
+		JOIN comments ON comments.product_id = products.id
+		WHERE comments.id = $1
+		FOR UPDATE OF shops`
+
+	return lockShop(ctx, db, query, commentID)
+}
+
+// The updateShopRating() helper recomputes the rating of a shop from the comments on
+// all of its products. It is called in the same transaction as every change to the
+// comments table, after locking the shop, so the two never disagree.
+func updateShopRating(ctx context.Context, db DBTX, shopID int64) error {
+	query := `
+		UPDATE shops
+		SET rating = (
+				SELECT AVG(comments.rate)
+				FROM comments
+				JOIN products ON comments.product_id = products.id
+				WHERE products.shop_id = shops.id),
+			rating_count = (
+				SELECT COUNT(*)
+				FROM comments
+				JOIN products ON comments.product_id = products.id
+				WHERE products.shop_id = shops.id)
+		WHERE id = $1`
+
+	_, err := db.ExecContext(ctx, query, shopID)
+	return err
+}
+
+func (m CommentModel) Insert(comment *Comment) error {
+	query := `
+		INSERT INTO comments (product_id, user_id, text, rate)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, version`
+
+	args := []interface{}{comment.ProductID, comment.UserID, comment.Text, comment.Rate}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	shopID, err := lockShopOfProduct(ctx, tx, comment.ProductID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&comment.ID, &comment.CreatedAt, &comment.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "comments_product_user_key"`:
+			return ErrDuplicateComment
+		default:
+			return err
+		}
+	}
+
+	err = updateShopRating(ctx, tx, shopID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m CommentModel) Get(id int64) (*Comment, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT comments.id, comments.created_at, comments.product_id, comments.user_id,
+			users.first_name || ' ' || users.last_name, comments.text, comments.rate, comments.version
+		FROM comments
+		JOIN users ON comments.user_id = users.id
+		WHERE comments.id = $1`
+
+	var comment Comment
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&comment.ID,
+		&comment.CreatedAt,
+		&comment.ProductID,
+		&comment.UserID,
+		&comment.Username,
+		&comment.Text,
+		&comment.Rate,
+		&comment.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &comment, nil
+}
+
+func (m CommentModel) GetAll(productID int64, filters Filters) ([]*Comment, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), comments.id, comments.created_at, comments.product_id, comments.user_id,
+			users.first_name || ' ' || users.last_name, comments.text, comments.rate, comments.version
+		FROM comments
+		JOIN users ON comments.user_id = users.id
+		WHERE (comments.product_id = $1 OR $1 = 0)
+		ORDER BY comments.%s %s, comments.id ASC
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{productID, filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	comments := []*Comment{}
+
+	for rows.Next() {
+		var comment Comment
+
+		err := rows.Scan(
+			&totalRecords,
+			&comment.ID,
+			&comment.CreatedAt,
+			&comment.ProductID,
+			&comment.UserID,
+			&comment.Username,
+			&comment.Text,
+			&comment.Rate,
+			&comment.Version,
+		)
+
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		comments = append(comments, &comment)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return comments, metadata, nil
+}
+
+func (m CommentModel) Update(comment *Comment) error {
+	query := `
+		UPDATE comments
+		SET text = $1, rate = $2, version = version + 1
+		WHERE id = $3 AND version = $4
+		RETURNING version`
+
+	args := []interface{}{comment.Text, comment.Rate, comment.ID, comment.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	shopID, err := lockShopOfComment(ctx, tx, comment.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrRecordNotFound):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&comment.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	err = updateShopRating(ctx, tx, shopID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m CommentModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM comments
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	shopID, err := lockShopOfComment(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	err = updateShopRating(ctx, tx, shopID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -20,7 +20,7 @@ func ValidateCountry(v *validator.Validator, country *Country) {
 }
 
 type CountryModel struct {
-	DB *sql.DB
+	DB DBTX
 }
 
 func (m CountryModel) GetAll(country *Country) ([]*Country, error) {
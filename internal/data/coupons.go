@@ -0,0 +1,409 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/lib/pq"
+	"misarfeh.com/internal/validator"
+)
+
+var (
+	ErrDuplicateCouponCode = errors.New("duplicate coupon code")
+	ErrCouponNotActive     = errors.New("the coupon is not active")
+	ErrCouponMinimumTotal  = errors.New("the order total is below the minimum of the coupon")
+	ErrCouponNotApplicable = errors.New("the coupon doesn't apply to any item in the cart")
+	ErrCouponUsedUp        = errors.New("the coupon has reached its usage limit")
+)
+
+var CouponCodeRX = regexp.MustCompile(`^[A-Z0-9_-]{3,32}$`)
+
+const (
+	CouponPercentage = "percentage"
+	CouponFixed      = "fixed"
+)
+
+// Coupons and promotions apply to every product of the shop, to the products of a
+// category and its subcategories, or to a single product.
+const (
+	ScopeShop     = "shop"
+	ScopeCategory = "category"
+	ScopeProduct  = "product"
+)
+
+// The validateScope() helper checks that the category or the product a scope applies
+// to is given exactly when the scope needs it.
+func validateScope(v *validator.Validator, scope string, categoryID, productID *int64) {
+	v.Check(validator.In(scope, ScopeShop, ScopeCategory, ScopeProduct), "scope", "must be shop, category or product")
+
+	v.Check((categoryID != nil) == (scope == ScopeCategory), "category_id", "must be provided only for the category scope")
+	v.Check((productID != nil) == (scope == ScopeProduct), "product_id", "must be provided only for the product scope")
+}
+
+// The subcategoryIDs() helper returns the id of the category with the ids of all of its
+// subcategories.
+func subcategoryIDs(ctx context.Context, db DBTX, categoryID int64) ([]int64, error) {
+	query := `
+		WITH RECURSIVE subcategories AS (
+			SELECT id FROM categories WHERE id = $1
+			UNION
+			SELECT categories.id FROM categories
+			JOIN subcategories ON categories.parent_id = subcategories.id
+		)
+		SELECT array_agg(id) FROM subcategories`
+
+	var ids []int64
+
+	err := db.QueryRowContext(ctx, query, categoryID).Scan(pq.Array(&ids))
+	return ids, err
+}
+
+// Coupon is a discount code of a shop. Value is a percentage for percentage coupons and
+// an amount in toman for fixed ones. Codes are stored in upper case.
+type Coupon struct {
+	ID             int64     `json:"id"`
+	CreatedAt      time.Time `json:"created_at"`
+	ShopID         int64     `json:"shop_id"`
+	Code           string    `json:"code"`
+	Kind           string    `json:"kind"`
+	Value          int64     `json:"value"`
+	MinOrderTotal  int64     `json:"min_order_total"`
+	MaxUses        *int32    `json:"max_uses,omitempty"`
+	MaxUsesPerUser *int32    `json:"max_uses_per_user,omitempty"`
+	Uses           int32     `json:"uses"`
+	StartsAt       time.Time `json:"starts_at"`
+	EndsAt         time.Time `json:"ends_at"`
+	Scope          string    `json:"scope"`
+	CategoryID     *int64    `json:"category_id,omitempty"`
+	ProductID      *int64    `json:"product_id,omitempty"`
+
+	// categoryIDs holds the category of the coupon with its subcategories.
+	categoryIDs []int64
+}
+
+func ValidateCoupon(v *validator.Validator, coupon *Coupon) {
+	v.Check(coupon.Code != "", "code", "must be provided")
+	v.Check(validator.Matches(coupon.Code, CouponCodeRX), "code", "must be 3 to 32 letters, digits, dashes or underscores")
+
+	v.Check(validator.In(coupon.Kind, CouponPercentage, CouponFixed), "kind", "must be percentage or fixed")
+
+	v.Check(coupon.Value > 0, "value", "must be greater than zero")
+	if coupon.Kind == CouponPercentage {
+		v.Check(coupon.Value <= 100, "value", "must not be more than 100 for a percentage coupon")
+	}
+
+	v.Check(coupon.MinOrderTotal >= 0, "min_order_total", "must not be negative")
+
+	v.Check(coupon.MaxUses == nil || *coupon.MaxUses > 0, "max_uses", "must be greater than zero")
+	v.Check(coupon.MaxUsesPerUser == nil || *coupon.MaxUsesPerUser > 0, "max_uses_per_user", "must be greater than zero")
+
+	v.Check(!coupon.StartsAt.IsZero(), "starts_at", "must be provided")
+	v.Check(!coupon.EndsAt.IsZero(), "ends_at", "must be provided")
+	v.Check(coupon.EndsAt.After(coupon.StartsAt), "ends_at", "must be after starts_at")
+
+	validateScope(v, coupon.Scope, coupon.CategoryID, coupon.ProductID)
+}
+
+// The applies() method reports whether the coupon applies to the cart item.
+func (c *Coupon) applies(item *CartItem) bool {
+	switch c.Scope {
+	case ScopeCategory:
+		for _, id := range c.categoryIDs {
+			if id == item.CategoryID {
+				return true
+			}
+		}
+		return false
+	case ScopeProduct:
+		return c.ProductID != nil && *c.ProductID == item.ProductID
+	default:
+		return true
+	}
+}
+
+// The Discount() method returns the discount the coupon gives on the items of the shop
+// at the given time. Usage limits are only checked when the coupon is redeemed.
+func (c *Coupon) Discount(shop *CartShop, now time.Time) (int64, error) {
+	if shop.ShopID != c.ShopID {
+		return 0, ErrCouponNotApplicable
+	}
+
+	if now.Before(c.StartsAt) || !now.Before(c.EndsAt) {
+		return 0, ErrCouponNotActive
+	}
+
+	if shop.Subtotal < c.MinOrderTotal {
+		return 0, ErrCouponMinimumTotal
+	}
+
+	var eligible int64
+
+	for _, item := range shop.Items {
+		if c.applies(item) {
+			eligible += item.Subtotal
+		}
+	}
+
+	if eligible == 0 {
+		return 0, ErrCouponNotApplicable
+	}
+
+	if c.Kind == CouponPercentage {
+		return eligible * c.Value / 100, nil
+	}
+
+	if c.Value > eligible {
+		return eligible, nil
+	}
+
+	return c.Value, nil
+}
+
+type CouponModel struct {
+	DB DBTX
+}
+
+func (m CouponModel) Insert(coupon *Coupon) error {
+	query := `
+		INSERT INTO coupons (shop_id, code, kind, value, min_order_total, max_uses, max_uses_per_user,
+			starts_at, ends_at, scope, category_id, product_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		RETURNING id, created_at, uses`
+
+	args := []interface{}{coupon.ShopID, coupon.Code, coupon.Kind, coupon.Value, coupon.MinOrderTotal,
+		coupon.MaxUses, coupon.MaxUsesPerUser, coupon.StartsAt, coupon.EndsAt, coupon.Scope,
+		coupon.CategoryID, coupon.ProductID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&coupon.ID, &coupon.CreatedAt, &coupon.Uses)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "coupons_code_key"`:
+			return ErrDuplicateCouponCode
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+const couponColumns = `id, created_at, shop_id, code, kind, value, min_order_total, max_uses,
+	max_uses_per_user, uses, starts_at, ends_at, scope, category_id, product_id`
+
+func scanCoupon(row interface{ Scan(...interface{}) error }, coupon *Coupon) error {
+	return row.Scan(
+		&coupon.ID,
+		&coupon.CreatedAt,
+		&coupon.ShopID,
+		&coupon.Code,
+		&coupon.Kind,
+		&coupon.Value,
+		&coupon.MinOrderTotal,
+		&coupon.MaxUses,
+		&coupon.MaxUsesPerUser,
+		&coupon.Uses,
+		&coupon.StartsAt,
+		&coupon.EndsAt,
+		&coupon.Scope,
+		&coupon.CategoryID,
+		&coupon.ProductID,
+	)
+}
+
+// The get() helper returns the coupon matching the condition, with the subcategories
+// of its category loaded.
+func (m CouponModel) get(where string, arg interface{}) (*Coupon, error) {
+	query := `SELECT ` + couponColumns + ` FROM coupons WHERE ` + where
+
+	var coupon Coupon
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := scanCoupon(m.DB.QueryRowContext(ctx, query, arg), &coupon)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if coupon.CategoryID != nil {
+		coupon.categoryIDs, err = subcategoryIDs(ctx, m.DB, *coupon.CategoryID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &coupon, nil
+}
+
+func (m CouponModel) Get(id int64) (*Coupon, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	return m.get(`id = $1`, id)
+}
+
+// The GetByCode() method looks the coupon up by its code, ignoring case.
+func (m CouponModel) GetByCode(code string) (*Coupon, error) {
+	return m.get(`code = UPPER($1)`, code)
+}
+
+func (m CouponModel) GetAllForShop(shopID int64) ([]*Coupon, error) {
+	query := `SELECT ` + couponColumns + ` FROM coupons WHERE shop_id = $1 ORDER BY id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, shopID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	coupons := []*Coupon{}
+
+	for rows.Next() {
+		var coupon Coupon
+
+		err := scanCoupon(rows, &coupon)
+		if err != nil {
+			return nil, err
+		}
+
+		coupons = append(coupons, &coupon)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return coupons, nil
+}
+
+func (m CouponModel) Delete(shopID, id int64) error {
+	query := `
+		DELETE FROM coupons
+		WHERE id = $1 AND shop_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, shopID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// The Redeem() method counts a use of the coupon by the user for the order. The coupon
+// row is locked while its limits are checked, so concurrent orders can't use it more
+// often than allowed. It returns ErrCouponUsedUp if a limit was reached.
+func (m CouponModel) Redeem(coupon *Coupon, userID, orderID, discount int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	query := `
+		SELECT uses, max_uses, max_uses_per_user
+		FROM coupons
+		WHERE id = $1
+		FOR UPDATE`
+
+	var uses, userUses int32
+	var maxUses, maxUsesPerUser *int32
+
+	err = tx.QueryRowContext(ctx, query, coupon.ID).Scan(&uses, &maxUses, &maxUsesPerUser)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	// The redemptions of the user are counted in a separate statement once the lock is
+	// held. A statement which waited for the lock only sees the rows it locked again,
+	// so it would miss the redemption of the transaction which held the lock.
+	query = `
+		SELECT COUNT(*)
+		FROM coupon_redemptions
+		WHERE coupon_id = $1 AND user_id = $2`
+
+	err = tx.QueryRowContext(ctx, query, coupon.ID, userID).Scan(&userUses)
+	if err != nil {
+		return err
+	}
+
+	if (maxUses != nil && uses >= *maxUses) || (maxUsesPerUser != nil && userUses >= *maxUsesPerUser) {
+		return ErrCouponUsedUp
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE coupons SET uses = uses + 1 WHERE id = $1`, coupon.ID)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO coupon_redemptions (coupon_id, user_id, order_id, discount)
+		VALUES ($1, $2, $3, $4)`
+
+	_, err = tx.ExecContext(ctx, query, coupon.ID, userID, orderID, discount)
+	if err != nil {
+		return err
+	}
+
+	coupon.Uses = uses + 1
+
+	return tx.Commit()
+}
+
+// The releaseCouponRedemptions() helper gives the coupon uses of the orders back, when
+// the orders are cancelled.
+func releaseCouponRedemptions(ctx context.Context, db DBTX, orderIDs ...int64) error {
+	query := `
+		WITH released AS (
+			DELETE FROM coupon_redemptions
+			WHERE order_id = ANY($1)
+			RETURNING coupon_id
+		)
+		UPDATE coupons
+		SET uses = coupons.uses - released_uses.count
+		FROM (
+			SELECT coupon_id, COUNT(*) AS count
+			FROM released
+			GROUP BY coupon_id
+		) AS released_uses
+		WHERE coupons.id = released_uses.coupon_id`
+
+	_, err := db.ExecContext(ctx, query, pq.Array(orderIDs))
+	return err
+}
@@ -0,0 +1,100 @@
+package data
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCouponDiscount(t *testing.T) {
+	now := time.Date(2024, 3, 20, 12, 0, 0, 0, time.UTC)
+	productID := int64(7)
+
+	shop := &CartShop{
+		ShopID: 1,
+		Items: []*CartItem{
+			{ProductID: 7, CategoryID: 3, Subtotal: 300000},
+			{ProductID: 8, CategoryID: 4, Subtotal: 200000},
+			{ProductID: 9, CategoryID: 5, Subtotal: 500000},
+		},
+		Subtotal: 1000000,
+	}
+
+	coupon := func(kind string, value int64) Coupon {
+		return Coupon{
+			ShopID:   1,
+			Kind:     kind,
+			Value:    value,
+			StartsAt: now.Add(-time.Hour),
+			EndsAt:   now.Add(time.Hour),
+			Scope:    ScopeShop,
+		}
+	}
+
+	tests := []struct {
+		name    string
+		coupon  func(c *Coupon)
+		kind    string
+		value   int64
+		want    int64
+		wantErr error
+	}{
+		{name: "percentage of the shop", kind: CouponPercentage, value: 10, want: 100000},
+		{name: "fixed", kind: CouponFixed, value: 50000, want: 50000},
+		{name: "fixed above the items", kind: CouponFixed, value: 2000000, want: 1000000},
+		{
+			name: "product", kind: CouponPercentage, value: 50, want: 150000,
+			coupon: func(c *Coupon) { c.Scope, c.ProductID = ScopeProduct, &productID },
+		},
+		{
+			name: "category and subcategory", kind: CouponPercentage, value: 10, want: 50000,
+			coupon: func(c *Coupon) { c.Scope, c.categoryIDs = ScopeCategory, []int64{3, 4} },
+		},
+		{
+			name: "fixed above the eligible items", kind: CouponFixed, value: 400000, want: 300000,
+			coupon: func(c *Coupon) { c.Scope, c.ProductID = ScopeProduct, &productID },
+		},
+		{
+			name: "no eligible item", kind: CouponPercentage, value: 10, wantErr: ErrCouponNotApplicable,
+			coupon: func(c *Coupon) { c.Scope, c.categoryIDs = ScopeCategory, []int64{6} },
+		},
+		{
+			name: "other shop", kind: CouponPercentage, value: 10, wantErr: ErrCouponNotApplicable,
+			coupon: func(c *Coupon) { c.ShopID = 2 },
+		},
+		{
+			name: "not started", kind: CouponPercentage, value: 10, wantErr: ErrCouponNotActive,
+			coupon: func(c *Coupon) { c.StartsAt = now.Add(time.Minute) },
+		},
+		{
+			name: "ended", kind: CouponPercentage, value: 10, wantErr: ErrCouponNotActive,
+			coupon: func(c *Coupon) { c.EndsAt = now },
+		},
+		{
+			name: "minimum total", kind: CouponPercentage, value: 10, wantErr: ErrCouponMinimumTotal,
+			coupon: func(c *Coupon) { c.MinOrderTotal = 1000001 },
+		},
+		{
+			name: "minimum total reached", kind: CouponPercentage, value: 10, want: 100000,
+			coupon: func(c *Coupon) { c.MinOrderTotal = 1000000 },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := coupon(tt.kind, tt.value)
+			if tt.coupon != nil {
+				tt.coupon(&c)
+			}
+
+			got, err := c.Discount(shop, now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v; want %v", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("got discount %d; want %d", got, tt.want)
+			}
+		})
+	}
+}
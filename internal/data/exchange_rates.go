@@ -0,0 +1,139 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"misarfeh.com/internal/validator"
+)
+
+// ExchangeRate is the price in toman a seller sets for one unit of a foreign currency.
+// Products whose original price is in the currency are repriced from it.
+type ExchangeRate struct {
+	SellerID  int64     `json:"-"`
+	Currency  string    `json:"currency"`
+	Rate      int64     `json:"rate"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func ValidateExchangeRate(v *validator.Validator, rate *ExchangeRate) {
+	v.Check(ValidCurrency(rate.Currency), "currency", "must be a supported currency")
+	v.Check(rate.Currency != CurrencyToman, "currency", "must not be toman")
+
+	v.Check(rate.Rate != 0, "rate", "must be provided")
+	v.Check(rate.Rate > 0, "rate", "must be greater than zero")
+}
+
+type ExchangeRateModel struct {
+	DB DBTX
+}
+
+// The Upsert() method sets the rate of the seller for the currency, replacing the
+// previous one.
+func (m ExchangeRateModel) Upsert(rate *ExchangeRate) error {
+	query := `
+		INSERT INTO exchange_rates (seller_id, currency, rate)
+		VALUES ($1, $2, $3)
+		ON CONFLICT ON CONSTRAINT exchange_rates_pk DO UPDATE
+		SET rate = EXCLUDED.rate, updated_at = NOW()
+		RETURNING updated_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, rate.SellerID, rate.Currency, rate.Rate).Scan(&rate.UpdatedAt)
+}
+
+func (m ExchangeRateModel) Get(sellerID int64, currency string) (*ExchangeRate, error) {
+	query := `
+		SELECT seller_id, currency, rate, updated_at
+		FROM exchange_rates
+		WHERE seller_id = $1 AND currency = $2`
+
+	var rate ExchangeRate
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, sellerID, currency).Scan(
+		&rate.SellerID,
+		&rate.Currency,
+		&rate.Rate,
+		&rate.UpdatedAt,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &rate, nil
+}
+
+func (m ExchangeRateModel) GetAllForSeller(sellerID int64) ([]*ExchangeRate, error) {
+	query := `
+		SELECT seller_id, currency, rate, updated_at
+		FROM exchange_rates
+		WHERE seller_id = $1
+		ORDER BY currency`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, sellerID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	rates := []*ExchangeRate{}
+
+	for rows.Next() {
+		var rate ExchangeRate
+
+		err := rows.Scan(&rate.SellerID, &rate.Currency, &rate.Rate, &rate.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		rates = append(rates, &rate)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rates, nil
+}
+
+func (m ExchangeRateModel) Delete(sellerID int64, currency string) error {
+	query := `
+		DELETE FROM exchange_rates
+		WHERE seller_id = $1 AND currency = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, sellerID, currency)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
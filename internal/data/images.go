@@ -3,44 +3,220 @@ package data
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"sort"
 	"time"
+
+	"github.com/lib/pq"
 )
 
+// ImageVariants holds the URLs of the sizes of an image, keyed by size (thumb, card,
+// full) and then format, like a srcset.
+type ImageVariants map[string]map[string]string
+
+// Image is an uploaded image, or an image URL a shop or product was created with.
+// Images which belong to neither a product nor a shop are pending: they were uploaded
+// but not used yet, or were detached from their product. StorageKeys are the keys of
+// the files of the image in the storage, external images have none. Files are content
+// addressed and shared by every image with the same upload Hash, so a file is only
+// deleted once no image references it.
 type Image struct {
-	ID        int64  `json:"id"`
-	Url       string `json:"url"`
-	ProductID *int64 `json:"product_id"`
-	ShopID    *int64 `json:"shop_id"`
+	ID          int64         `json:"id"`
+	CreatedAt   time.Time     `json:"-"`
+	UserID      *int64        `json:"-"`
+	Url         string        `json:"url"`
+	Hash        string        `json:"-"`
+	Variants    ImageVariants `json:"variants"`
+	StorageKeys []string      `json:"-"`
+	Position    int32         `json:"-"`
+	ProductID   *int64        `json:"-"`
+	ShopID      *int64        `json:"-"`
 }
 
 type ImageModel struct {
-	DB *sql.DB
+	DB DBTX
 }
 
 func (m ImageModel) Insert(image *Image) error {
 	query := `
-		INSERT INTO images (url, product_id, shop_id)
-		VALUES ($1, $2, $3)
-		RETURNING id`
+		INSERT INTO images (user_id, url, hash, variants, storage_keys, position, product_id, shop_id)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, $7, $8)
+		RETURNING id, created_at`
+
+	variants, err := json.Marshal(image.Variants)
+	if err != nil {
+		return err
+	}
+
+	args := []interface{}{image.UserID, image.Url, image.Hash, string(variants), pq.Array(image.StorageKeys),
+		image.Position, image.ProductID, image.ShopID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&image.ID, &image.CreatedAt)
+}
+
+// The Attach() method gives a pending image with the URL, uploaded by the user, to the
+// product or shop of the image. Otherwise a new image is inserted, which shares the
+// files of the images already using the URL, if any. It must be called in a
+// transaction, see Lock().
+func (m ImageModel) Attach(image *Image) error {
+	query := `
+		UPDATE images
+		SET product_id = $2, shop_id = $3, position = $4, updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM images
+			WHERE url = $1 AND product_id IS NULL AND shop_id IS NULL
+			AND (user_id = $5 OR user_id IS NULL)
+			ORDER BY user_id NULLS LAST, id
+			LIMIT 1
+			FOR UPDATE
+		)
+		RETURNING id, created_at, COALESCE(hash, ''), variants, storage_keys`
+
+	args := []interface{}{image.Url, image.ProductID, image.ShopID, image.Position, image.UserID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var variants []byte
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&image.ID, &image.CreatedAt, &image.Hash,
+		&variants, pq.Array(&image.StorageKeys))
+	if err == nil {
+		return json.Unmarshal(variants, &image.Variants)
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	query = `
+		SELECT COALESCE(hash, ''), variants, storage_keys
+		FROM images
+		WHERE url = $1
+		ORDER BY id
+		LIMIT 1`
+
+	var shared Image
+
+	err = m.DB.QueryRowContext(ctx, query, image.Url).Scan(&shared.Hash, &variants, pq.Array(&shared.StorageKeys))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return m.Insert(image)
+		default:
+			return err
+		}
+	}
+
+	// Lock the files and check they are still referenced, since they are deleted once
+	// the images using them are gone.
+	err = m.Lock(shared.StorageKeys...)
+	if err != nil {
+		return err
+	}
+
+	referenced, err := m.Referenced(shared.StorageKeys...)
+	if err != nil {
+		return err
+	}
+
+	if referenced {
+		err = json.Unmarshal(variants, &image.Variants)
+		if err != nil {
+			return err
+		}
+
+		image.Hash = shared.Hash
+		image.StorageKeys = shared.StorageKeys
+	}
+
+	return m.Insert(image)
+}
+
+// The GetByHash() method returns an image uploaded with the hash. Pending images of
+// the user are returned first, so uploading the same file twice returns the same
+// image.
+func (m ImageModel) GetByHash(hash string, userID int64) (*Image, error) {
+	query := `
+		SELECT id, created_at, user_id, url, COALESCE(hash, ''), variants, storage_keys, position, product_id, shop_id
+		FROM images
+		WHERE hash = $1
+		ORDER BY (user_id = $2 AND product_id IS NULL AND shop_id IS NULL) DESC, id
+		LIMIT 1`
 
-	args := []interface{}{image.Url, image.ProductID, image.ShopID}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanImage(m.DB.QueryRowContext(ctx, query, hash, userID))
+}
+
+func (m ImageModel) Get(id int64) (*Image, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, user_id, url, COALESCE(hash, ''), variants, storage_keys, position, product_id, shop_id
+		FROM images
+		WHERE id = $1`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&image.ID)
+	return scanImage(m.DB.QueryRowContext(ctx, query, id))
+}
+
+func scanImage(row *sql.Row) (*Image, error) {
+	var image Image
+	var variants []byte
+
+	err := row.Scan(
+		&image.ID,
+		&image.CreatedAt,
+		&image.UserID,
+		&image.Url,
+		&image.Hash,
+		&variants,
+		pq.Array(&image.StorageKeys),
+		&image.Position,
+		&image.ProductID,
+		&image.ShopID,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	err = json.Unmarshal(variants, &image.Variants)
+	if err != nil {
+		return nil, err
+	}
+
+	return &image, nil
 }
 
+// The GetAll() method returns the images of the shop or the product, in the order set
+// by the seller. The first image of a product is its cover.
 func (m ImageModel) GetAll(shop_id, product_id int64) ([]*Image, error) {
 	if shop_id < 0 || product_id < 0 || (shop_id < 1 && product_id < 1) {
 		return nil, ErrRecordNotFound
 	}
 
 	query := `
-		SELECT id, url
+		SELECT id, url, variants, position
 		FROM images
 		WHERE (shop_id = $1 OR $1 = 0)
-		AND (product_id = $2 OR $2 = 0)`
+		AND (product_id = $2 OR $2 = 0)
+		ORDER BY position, id`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -56,16 +232,24 @@ func (m ImageModel) GetAll(shop_id, product_id int64) ([]*Image, error) {
 
 	for rows.Next() {
 		var image Image
+		var variants []byte
 
 		err := rows.Scan(
 			&image.ID,
 			&image.Url,
+			&variants,
+			&image.Position,
 		)
 
 		if err != nil {
 			return nil, err
 		}
 
+		err = json.Unmarshal(variants, &image.Variants)
+		if err != nil {
+			return nil, err
+		}
+
 		images = append(images, &image)
 	}
 
@@ -75,3 +259,217 @@ func (m ImageModel) GetAll(shop_id, product_id int64) ([]*Image, error) {
 
 	return images, nil
 }
+
+// The DetachAllForProduct() method makes the images of the product pending, so the
+// ones which aren't attached again are deleted by the sweeper.
+func (m ImageModel) DetachAllForProduct(productID int64) error {
+	query := `
+		UPDATE images
+		SET product_id = NULL, position = 0, updated_at = NOW()
+		WHERE product_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, productID)
+	return err
+}
+
+// The Reorder() method sets the positions of the images of the product to their
+// positions in ids. It returns ErrRecordNotFound unless ids holds every image of the
+// product exactly once.
+func (m ImageModel) Reorder(productID int64, ids []int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	current, err := queryIDs(ctx, tx, `SELECT id FROM images WHERE product_id = $1 FOR UPDATE`, productID)
+	if err != nil {
+		return err
+	}
+
+	if len(current) != len(ids) {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		UPDATE images
+		SET position = array_position($2::bigint[], id) - 1, updated_at = NOW()
+		WHERE product_id = $1 AND id = ANY($2)`
+
+	result, err := tx.ExecContext(ctx, query, productID, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected != int64(len(current)) {
+		return ErrRecordNotFound
+	}
+
+	return tx.Commit()
+}
+
+// The Delete() method deletes the image and returns the keys of its files, which the
+// caller deletes from the storage.
+func (m ImageModel) Delete(id int64) ([]string, error) {
+	query := `
+		DELETE FROM images
+		WHERE id = $1
+		RETURNING storage_keys`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var keys []string
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(pq.Array(&keys))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return keys, nil
+}
+
+// The DeletePending() method deletes up to limit images which have been pending for
+// longer than ttl, and returns the keys of their files, which the caller deletes from
+// the storage.
+func (m ImageModel) DeletePending(ttl time.Duration, limit int) ([]string, int64, error) {
+	query := `
+		DELETE FROM images
+		WHERE id IN (
+			SELECT id FROM images
+			WHERE product_id IS NULL AND shop_id IS NULL
+			AND updated_at < NOW() - make_interval(secs => $1)
+			ORDER BY updated_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING storage_keys`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, ttl.Seconds(), limit)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defer rows.Close()
+
+	keys := []string{}
+
+	var count int64
+
+	for rows.Next() {
+		var imageKeys []string
+
+		err := rows.Scan(pq.Array(&imageKeys))
+		if err != nil {
+			return nil, 0, err
+		}
+
+		keys = append(keys, imageKeys...)
+		count++
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return keys, count, nil
+}
+
+// The Lock() method takes a lock on every key until the end of the transaction, and
+// must be called in one. Storing a file and inserting the image referencing it, and
+// checking a file is unreferenced and deleting it, are done holding the lock of the
+// file, so a file is never deleted while a new image starts using it.
+func (m ImageModel) Lock(keys ...string) error {
+	// Locking in a consistent order keeps two transactions from deadlocking.
+	sorted := append([]string{}, keys...)
+	sort.Strings(sorted)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, key := range sorted {
+		_, err := m.DB.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The Referenced() method reports whether any image references every one of the keys.
+func (m ImageModel) Referenced(keys ...string) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM images WHERE storage_keys @> $1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var referenced bool
+
+	err := m.DB.QueryRowContext(ctx, query, pq.Array(keys)).Scan(&referenced)
+	return referenced, err
+}
+
+// StoredFile is a file in the storage and the images referencing it.
+type StoredFile struct {
+	Key      string
+	ImageIDs []int64
+}
+
+// The GetAllStoredFiles() method returns every file referenced by an image.
+func (m ImageModel) GetAllStoredFiles() ([]*StoredFile, error) {
+	query := `
+		SELECT key, array_agg(images.id ORDER BY images.id)
+		FROM images, unnest(images.storage_keys) AS key
+		GROUP BY key
+		ORDER BY key`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	files := []*StoredFile{}
+
+	for rows.Next() {
+		var file StoredFile
+
+		err := rows.Scan(&file.Key, pq.Array(&file.ImageIDs))
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, &file)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
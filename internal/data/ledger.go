@@ -0,0 +1,241 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrUnbalancedTransaction = errors.New("ledger transaction does not add up to zero")
+)
+
+// The ledger is double-entry: every movement of money is a transaction made of entries
+// which add up to zero. A positive amount is money added to the account.
+//
+//   - gateway is the money buyers paid through the payment gateway, it goes down as
+//     payments come in.
+//   - escrow holds the money buyers paid for orders which aren't delivered yet.
+//   - commission is the income of the platform.
+//   - seller is the balance the platform owes a seller, there is one per seller.
+//   - payouts holds the money which is being paid out to sellers.
+//   - bank is the money which left the platform to the bank cards of sellers.
+const (
+	AccountGateway    = "gateway"
+	AccountEscrow     = "escrow"
+	AccountCommission = "commission"
+	AccountSeller     = "seller"
+	AccountPayouts    = "payouts"
+	AccountBank       = "bank"
+)
+
+type LedgerEntry struct {
+	ID            int64     `json:"id"`
+	TransactionID int64     `json:"transaction_id"`
+	CreatedAt     time.Time `json:"created_at"`
+	Account       string    `json:"account"`
+	SellerID      *int64    `json:"-"`
+	OrderID       *int64    `json:"order_id,omitempty"`
+	PaymentID     *int64    `json:"payment_id,omitempty"`
+	PayoutID      *int64    `json:"payout_id,omitempty"`
+	Amount        int64     `json:"amount"`
+	Description   string    `json:"description"`
+}
+
+// Balance is the state of the account of a seller. Available is what can be paid out
+// in the next payout batch, and PendingPayouts is what has been batched but not yet
+// transferred.
+type Balance struct {
+	Available      int64 `json:"available"`
+	PendingPayouts int64 `json:"pending_payouts"`
+	PaidOut        int64 `json:"paid_out"`
+}
+
+// Commission returns the commission of the platform on amount, rounded down.
+func Commission(amount int64, percent int) int64 {
+	return amount * int64(percent) / 100
+}
+
+// The insertLedgerTransaction() helper inserts the entries as a single ledger
+// transaction. The database checks the balance of the transaction again when the
+// surrounding database transaction commits, so db should be a transaction.
+func insertLedgerTransaction(ctx context.Context, db DBTX, entries ...*LedgerEntry) error {
+	var sum int64
+	for _, entry := range entries {
+		sum += entry.Amount
+	}
+
+	if sum != 0 {
+		return ErrUnbalancedTransaction
+	}
+
+	var transactionID int64
+
+	err := db.QueryRowContext(ctx, `SELECT nextval('ledger_transaction_id_seq')`).Scan(&transactionID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO ledger_entries (transaction_id, account, seller_id, order_id, payment_id, payout_id, amount, description)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at`
+
+	for _, entry := range entries {
+		entry.TransactionID = transactionID
+
+		args := []interface{}{transactionID, entry.Account, entry.SellerID, entry.OrderID, entry.PaymentID, entry.PayoutID,
+			entry.Amount, entry.Description}
+
+		err := db.QueryRowContext(ctx, query, args...).Scan(&entry.ID, &entry.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type LedgerModel struct {
+	DB DBTX
+}
+
+// The RecordPayment() method moves a verified payment from the gateway into escrow,
+// where it stays until the order is delivered. It must be called in the transaction
+// which marks the payment as paid.
+func (m LedgerModel) RecordPayment(payment *Payment) error {
+	description := fmt.Sprintf("order %d paid", payment.OrderID)
+
+	entries := []*LedgerEntry{
+		{Account: AccountGateway, PaymentID: &payment.ID, Amount: -payment.Amount, Description: description},
+		{Account: AccountEscrow, PaymentID: &payment.ID, Amount: payment.Amount, Description: description},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	err = insertLedgerTransaction(ctx, tx, entries...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The CreditDeliveredOrder() method moves the total of a delivered order out of escrow,
+// to the balance of the seller minus the commission of the platform.
+func (m LedgerModel) CreditDeliveredOrder(order *Order, sellerID int64, commissionPercent int) error {
+	commission := Commission(order.Total, commissionPercent)
+	description := fmt.Sprintf("order %d delivered", order.ID)
+
+	entries := []*LedgerEntry{
+		{Account: AccountEscrow, OrderID: &order.ID, Amount: -order.Total, Description: description},
+		{Account: AccountSeller, SellerID: &sellerID, OrderID: &order.ID, Amount: order.Total - commission, Description: description},
+		{Account: AccountCommission, OrderID: &order.ID, Amount: commission, Description: description},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	err = insertLedgerTransaction(ctx, tx, entries...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m LedgerModel) GetBalance(sellerID int64) (*Balance, error) {
+	query := `
+		SELECT
+			COALESCE((SELECT SUM(amount) FROM ledger_entries
+				WHERE account = 'seller' AND seller_id = $1), 0),
+			COALESCE((SELECT SUM(amount) FROM payouts
+				WHERE status = 'pending' AND seller_id = $1), 0),
+			COALESCE((SELECT SUM(amount) FROM payouts
+				WHERE status = 'paid' AND seller_id = $1), 0)`
+
+	var balance Balance
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, sellerID).Scan(&balance.Available, &balance.PendingPayouts, &balance.PaidOut)
+	if err != nil {
+		return nil, err
+	}
+
+	return &balance, nil
+}
+
+// The GetAllForSeller() method returns the entries of the account of the seller.
+func (m LedgerModel) GetAllForSeller(sellerID int64, filters Filters) ([]*LedgerEntry, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), id, transaction_id, created_at, account, seller_id, order_id,
+			payout_id, amount, description
+		FROM ledger_entries
+		WHERE account = 'seller' AND seller_id = $1
+		ORDER BY %s %s, id ASC
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{sellerID, filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*LedgerEntry{}
+
+	for rows.Next() {
+		var entry LedgerEntry
+
+		err := rows.Scan(
+			&totalRecords,
+			&entry.ID,
+			&entry.TransactionID,
+			&entry.CreatedAt,
+			&entry.Account,
+			&entry.SellerID,
+			&entry.OrderID,
+			&entry.PayoutID,
+			&entry.Amount,
+			&entry.Description,
+		)
+
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return entries, metadata, nil
+}
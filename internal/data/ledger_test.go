@@ -0,0 +1,61 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCommission(t *testing.T) {
+	tests := []struct {
+		amount  int64
+		percent int
+		want    int64
+	}{
+		{1000000, 10, 100000},
+		{999, 10, 99},
+		{1000000, 0, 0},
+		{0, 10, 0},
+		{1234567, 7, 86419},
+	}
+
+	for _, tt := range tests {
+		got := Commission(tt.amount, tt.percent)
+		if got != tt.want {
+			t.Errorf("Commission(%d, %d) = %d; want %d", tt.amount, tt.percent, got, tt.want)
+		}
+
+		// The seller gets the rest, so a delivered order always balances.
+		if seller := tt.amount - got; seller+got != tt.amount || seller < 0 {
+			t.Errorf("Commission(%d, %d) leaves %d for the seller", tt.amount, tt.percent, seller)
+		}
+	}
+}
+
+func TestInsertLedgerTransactionUnbalanced(t *testing.T) {
+	tests := []struct {
+		name    string
+		amounts []int64
+	}{
+		{"single entry", []int64{1000}},
+		{"off by one", []int64{-1000, 999}},
+		{"three entries", []int64{-1000, 900, 90}},
+		{"both positive", []int64{500, 500}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var entries []*LedgerEntry
+			for _, amount := range tt.amounts {
+				entries = append(entries, &LedgerEntry{Account: AccountEscrow, Amount: amount})
+			}
+
+			// An unbalanced transaction is refused before the database is used, so a
+			// nil DBTX is fine.
+			err := insertLedgerTransaction(context.Background(), nil, entries...)
+			if !errors.Is(err, ErrUnbalancedTransaction) {
+				t.Errorf("got error %v; want %v", err, ErrUnbalancedTransaction)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package data
 import (
 	"database/sql"
 	"errors"
+	"time"
 )
 
 var (
@@ -11,12 +12,18 @@ var (
 )
 
 type Models struct {
+	// db is only set on the models returned by NewModels, it is used to start new
+	// transactions.
+	db *sql.DB
+
 	Shops interface {
 		Insert(shop *Shop) error
 		Get(id int64) (*Shop, error)
 		Update(shop *Shop) error
-		Delete(id int64) error
+		UpdateVerified(id int64, verified bool) error
+		Delete(id, sellerID int64) error
 		GetAll(title string, verified bool, countries []string, filters Filters) ([]*Shop, Metadata, error)
+		GetAllForSeller(sellerID int64) ([]*Shop, error)
 	}
 	Countries interface {
 		Insert(country *Country) error
@@ -40,44 +47,196 @@ type Models struct {
 		Get(id int64) (*Product, error)
 		Update(product *Product) error
 		Delete(id int64) error
-		GetAll(title, brand string, shop_id, country_id int64, filters Filters) ([]*Shop, Metadata, error)
+		GetAll(search, brand string, shopID, categoryID, countryID, minPrice, maxPrice int64, minOff int32, filters Filters) ([]*Product, Metadata, error)
+		RepriceForSeller(sellerID int64, currency string, rate int64) (int64, error)
 	}
 	Categories interface {
 		Insert(category *Category) error
 		Get(id int64) (*Category, error)
-		// Update(country *Country) error
-		// Delete(id int64) error
+		Update(category *Category) error
+		Delete(id int64, moveToParent bool) error
 		GetAll(category *Category) ([]*Category, error)
+		GetTree() ([]*CategoryNode, error)
 		GetAllByShopID(id int64) ([]*Category, error)
 		GetOrInsert(categories ...string) ([]*Category, error)
 	}
 	Users interface {
 		Insert(user *User) error
+		Get(id int64) (*User, error)
 		GetByEmailPhone(emailPhone string) (*User, error)
+		GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 		Update(user *User) error
 	}
+	Tokens interface {
+		New(userID int64, ttl time.Duration, scope string) (*Token, error)
+		Insert(token *Token) error
+		Delete(scope string, tokenPlaintext string) error
+		DeleteAllForUser(scope string, userID int64) error
+	}
+	Permissions interface {
+		GetAllForUser(userID int64) (Permissions, error)
+		AddForUser(userID int64, codes ...string) error
+		DeleteAllForUser(userID int64) error
+	}
+	OneTimeCodes interface {
+		New(userID int64, ttl time.Duration, scope string) (*OneTimeCode, error)
+		Check(userID int64, scope, codePlaintext string) error
+		DeleteAllForUser(scope string, userID int64) error
+	}
 	Sellers interface {
 		Insert(seller *Seller) error
 		Get(id int64) (*Seller, error)
 		Update(seller *Seller) error
 	}
+	Comments interface {
+		Insert(comment *Comment) error
+		Get(id int64) (*Comment, error)
+		Update(comment *Comment) error
+		Delete(id int64) error
+		GetAll(productID int64, filters Filters) ([]*Comment, Metadata, error)
+	}
 	Images interface {
 		Insert(image *Image) error
+		Attach(image *Image) error
+		Get(id int64) (*Image, error)
+		GetByHash(hash string, userID int64) (*Image, error)
 		GetAll(shop_id, product_id int64) ([]*Image, error)
+		DetachAllForProduct(productID int64) error
+		Reorder(productID int64, ids []int64) error
+		Delete(id int64) ([]string, error)
+		DeletePending(ttl time.Duration, limit int) ([]string, int64, error)
+		Lock(keys ...string) error
+		Referenced(keys ...string) (bool, error)
+		GetAllStoredFiles() ([]*StoredFile, error)
+	}
+	Uploads interface {
+		Insert(upload *Upload) error
+		Get(id, userID int64, forUpdate bool) (*Upload, error)
+		Advance(upload *Upload, offset, length int64) error
+		SetImage(upload *Upload, imageID int64) error
+		Delete(id, userID int64) error
+		DeleteExpired(ttl time.Duration) ([]int64, error)
+	}
+	Carts interface {
+		Get(userID int64) (*Cart, error)
+		GetItem(userID, itemID int64) (*CartItem, error)
+		AddItem(userID, productID, variantID int64, quantity int32) error
+		UpdateItem(userID, itemID int64, quantity int32, acceptPrice bool) error
+		DeleteItem(userID, itemID int64) error
+		DeleteItems(userID int64, itemIDs []int64) error
+		SetCoupon(userID int64, couponID *int64) error
+	}
+	Orders interface {
+		Insert(order *Order) error
+		Get(id int64) (*Order, error)
+		GetForUpdate(id int64) (*Order, error)
+		UpdateStatus(order *Order, status string) error
+		CancelExpired(timeout time.Duration) (int64, error)
+	}
+	Payments interface {
+		Insert(payment *Payment) error
+		GetByAuthority(authority string) (*Payment, error)
+		GetAllVerified() ([]*Payment, error)
+		MarkVerified(payment *Payment, refID string) error
+		MarkPaid(payment *Payment) error
+		MarkRefundDue(payment *Payment) error
+		MarkFailed(payment *Payment) error
+	}
+	Ledger interface {
+		RecordPayment(payment *Payment) error
+		CreditDeliveredOrder(order *Order, sellerID int64, commissionPercent int) error
+		GetBalance(sellerID int64) (*Balance, error)
+		GetAllForSeller(sellerID int64, filters Filters) ([]*LedgerEntry, Metadata, error)
+	}
+	Variants interface {
+		ReplaceForProduct(productID int64, variants []*ProductVariant) error
+		GetAllForProduct(productID int64) ([]*ProductVariant, error)
+		GetOptionsForProduct(productID int64) ([]*ProductOption, error)
+	}
+	Shipping interface {
+		GetRules(shopID int64) (*ShippingRules, error)
+		UpsertRules(rules *ShippingRules) error
+		PriceLines(shopID int64, lines []*ShippingLine) error
+	}
+	Coupons interface {
+		Insert(coupon *Coupon) error
+		Get(id int64) (*Coupon, error)
+		GetByCode(code string) (*Coupon, error)
+		GetAllForShop(shopID int64) ([]*Coupon, error)
+		Delete(shopID, id int64) error
+		Redeem(coupon *Coupon, userID, orderID, discount int64) error
+	}
+	Promotions interface {
+		Insert(promotion *Promotion) error
+		GetAllForShop(shopID int64) ([]*Promotion, error)
+		Delete(shopID, id int64) error
+		Apply() (started, ended int64, err error)
+	}
+	Wishlist interface {
+		GetAllForUser(userID int64) ([]*WishlistItem, error)
+		Insert(userID, productID, variantID int64) (*WishlistItem, error)
+		Delete(userID, id int64) error
+		DetectChanges() (int64, error)
+	}
+	Notifications interface {
+		GetUnsent(limit int) ([]*Notification, error)
+		MarkSent(id int64) error
+		MarkFailed(id int64) error
+	}
+	Reservations interface {
+		Hold(orderID, variantID int64, quantity int32, ttl time.Duration) error
+		Commit(orderID int64) error
+		ReleaseExpired() (int64, error)
+		GetAllForProduct(productID int64) ([]*VariantReservations, error)
+	}
+	ExchangeRates interface {
+		Upsert(rate *ExchangeRate) error
+		Get(sellerID int64, currency string) (*ExchangeRate, error)
+		GetAllForSeller(sellerID int64) ([]*ExchangeRate, error)
+		Delete(sellerID int64, currency string) error
+	}
+	Payouts interface {
+		CreateBatch() ([]*Payout, error)
+		GetAllPending() ([]*Payout, error)
+		MarkPaid(id int64) error
 	}
 }
 
 func NewModels(db *sql.DB) Models {
+	models := newModels(db)
+	models.db = db
+	return models
+}
+
+func newModels(db DBTX) Models {
 	return Models{
-		Shops:        ShopModel{DB: db},
-		Countries:    CountryModel{DB: db},
-		ShopCountry:  ShopCountryModel{DB: db},
-		ShopCategory: ShopCategoryModel{DB: db},
-		Products:     ProductModel{DB: db},
-		Categories:   CategoryModel{DB: db},
-		Users:        UserModel{DB: db},
-		Sellers:      SellerModel{DB: db},
-		Images:       ImageModel{DB: db},
+		Shops:         ShopModel{DB: db},
+		Countries:     CountryModel{DB: db},
+		ShopCountry:   ShopCountryModel{DB: db},
+		ShopCategory:  ShopCategoryModel{DB: db},
+		Products:      ProductModel{DB: db},
+		Categories:    CategoryModel{DB: db},
+		Users:         UserModel{DB: db},
+		Sellers:       SellerModel{DB: db},
+		Tokens:        TokenModel{DB: db},
+		OneTimeCodes:  OneTimeCodeModel{DB: db},
+		Permissions:   PermissionModel{DB: db},
+		Images:        ImageModel{DB: db},
+		Uploads:       UploadModel{DB: db},
+		Comments:      CommentModel{DB: db},
+		Carts:         CartModel{DB: db},
+		Orders:        OrderModel{DB: db},
+		Payments:      PaymentModel{DB: db},
+		Ledger:        LedgerModel{DB: db},
+		Payouts:       PayoutModel{DB: db},
+		ExchangeRates: ExchangeRateModel{DB: db},
+		Variants:      VariantModel{DB: db},
+		Reservations:  ReservationModel{DB: db},
+		Shipping:      ShippingModel{DB: db},
+		Coupons:       CouponModel{DB: db},
+		Promotions:    PromotionModel{DB: db},
+		Wishlist:      WishlistModel{DB: db},
+		Notifications: NotificationModel{DB: db},
 	}
 }
 
@@ -0,0 +1,113 @@
+package data
+
+import (
+	"strconv"
+	"strings"
+
+	"misarfeh.com/internal/validator"
+)
+
+// CurrencyToman is the currency products are sold in. Toman isn't an ISO 4217 currency,
+// IRT is the code commonly used for it.
+const CurrencyToman = "IRT"
+
+type currency struct {
+	exponent int
+	name     string
+}
+
+// currencies lists the supported currencies with the number of digits of their minor
+// unit and their Persian name.
+var currencies = map[string]currency{
+	CurrencyToman: {exponent: 0, name: "تومان"},
+	"USD":         {exponent: 2, name: "دلار"},
+	"EUR":         {exponent: 2, name: "یورو"},
+	"GBP":         {exponent: 2, name: "پوند"},
+	"AED":         {exponent: 2, name: "درهم"},
+	"TRY":         {exponent: 2, name: "لیر"},
+	"CNY":         {exponent: 2, name: "یوان"},
+	"JPY":         {exponent: 0, name: "ین"},
+}
+
+// Money is an amount in the minor units of a currency, for example cents for US
+// dollars. Toman has no minor unit.
+type Money struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+func Toman(amount int64) Money {
+	return Money{Amount: amount, Currency: CurrencyToman}
+}
+
+// ValidCurrency reports whether code is one of the supported currencies.
+func ValidCurrency(code string) bool {
+	_, ok := currencies[code]
+	return ok
+}
+
+// minorUnits returns the number of minor units in one unit of the currency.
+func minorUnits(code string) int64 {
+	units := int64(1)
+	for i := 0; i < currencies[code].exponent; i++ {
+		units *= 10
+	}
+	return units
+}
+
+// Convert returns the amount in toman, given the rate in toman for one unit of the
+// currency of m. The result is rounded to the nearest toman.
+func (m Money) Convert(rate int64) Money {
+	units := minorUnits(m.Currency)
+	return Toman((m.Amount*rate + units/2) / units)
+}
+
+var persianDigits = strings.NewReplacer(
+	"0", "۰", "1", "۱", "2", "۲", "3", "۳", "4", "۴",
+	"5", "۵", "6", "۶", "7", "۷", "8", "۸", "9", "۹",
+)
+
+// String formats the amount the way prices are written in Persian, with Persian digits,
+// the Arabic thousands and decimal separators and the name of the currency, for example
+// "۱۲٬۵۰۰٬۰۰۰ تومان" or "۱۹٫۹۹ دلار".
+func (m Money) String() string {
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		amount = -amount
+		sign = "-"
+	}
+
+	units := minorUnits(m.Currency)
+	major := strconv.FormatInt(amount/units, 10)
+
+	var b strings.Builder
+	b.WriteString(sign)
+
+	for i, digit := range major {
+		if i > 0 && (len(major)-i)%3 == 0 {
+			b.WriteString("٬")
+		}
+		b.WriteRune(digit)
+	}
+
+	if exponent := currencies[m.Currency].exponent; exponent > 0 {
+		minor := strconv.FormatInt(amount%units, 10)
+		b.WriteString("٫")
+		b.WriteString(strings.Repeat("0", exponent-len(minor)))
+		b.WriteString(minor)
+	}
+
+	name := currencies[m.Currency].name
+	if name == "" {
+		name = m.Currency
+	}
+
+	return persianDigits.Replace(b.String()) + " " + name
+}
+
+func ValidateMoney(v *validator.Validator, key string, m Money) {
+	v.Check(m.Amount > 0, key, "must be greater than zero")
+	v.Check(m.Currency != "", key+"_currency", "must be provided")
+	v.Check(ValidCurrency(m.Currency), key+"_currency", "must be a supported currency")
+}
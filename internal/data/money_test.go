@@ -0,0 +1,49 @@
+package data
+
+import "testing"
+
+func TestMoneyConvert(t *testing.T) {
+	tests := []struct {
+		name  string
+		money Money
+		rate  int64
+		want  int64
+	}{
+		{"toman", Toman(12500), 1, 12500},
+		{"dollars", Money{Amount: 1999, Currency: "USD"}, 60000, 1199400},
+		{"rounded down", Money{Amount: 1, Currency: "USD"}, 49, 0},
+		{"rounded up", Money{Amount: 1, Currency: "USD"}, 50, 1},
+		{"yen", Money{Amount: 1000, Currency: "JPY"}, 400, 400000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.money.Convert(tt.rate)
+			if got != Toman(tt.want) {
+				t.Errorf("got %+v; want %d toman", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		money Money
+		want  string
+	}{
+		{Toman(12500000), "۱۲٬۵۰۰٬۰۰۰ تومان"},
+		{Toman(950), "۹۵۰ تومان"},
+		{Toman(0), "۰ تومان"},
+		{Toman(-1500), "-۱٬۵۰۰ تومان"},
+		{Money{Amount: 1999, Currency: "USD"}, "۱۹٫۹۹ دلار"},
+		{Money{Amount: 5, Currency: "EUR"}, "۰٫۰۵ یورو"},
+		{Money{Amount: 123456789, Currency: "GBP"}, "۱٬۲۳۴٬۵۶۷٫۸۹ پوند"},
+		{Money{Amount: 100, Currency: "XYZ"}, "۱۰۰ XYZ"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.money.String(); got != tt.want {
+			t.Errorf("%+v.String() = %q; want %q", tt.money, got, tt.want)
+		}
+	}
+}
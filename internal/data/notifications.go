@@ -0,0 +1,103 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// maxNotificationAttempts is how often delivering a notification is tried before it is
+// given up.
+const maxNotificationAttempts = 5
+
+// Notification is a message queued for a user. Phone is read from the user when the
+// notification is delivered.
+type Notification struct {
+	ID        int64
+	CreatedAt time.Time
+	UserID    int64
+	Phone     string
+	Kind      string
+	ProductID *int64
+	Message   string
+}
+
+// The insertNotification() helper queues a notification for the user.
+func insertNotification(ctx context.Context, db DBTX, userID int64, kind string, productID int64, message string) error {
+	query := `
+		INSERT INTO notifications (user_id, kind, product_id, message)
+		VALUES ($1, $2, $3, $4)`
+
+	_, err := db.ExecContext(ctx, query, userID, kind, productID, message)
+	return err
+}
+
+type NotificationModel struct {
+	DB DBTX
+}
+
+// The GetUnsent() method returns the oldest notifications which weren't delivered yet
+// and haven't failed too often.
+func (m NotificationModel) GetUnsent(limit int) ([]*Notification, error) {
+	query := `
+		SELECT notifications.id, notifications.created_at, notifications.user_id, users.phone,
+			notifications.kind, notifications.product_id, notifications.message
+		FROM notifications
+		JOIN users ON notifications.user_id = users.id
+		WHERE notifications.sent_at IS NULL AND notifications.attempts < $1
+		ORDER BY notifications.id
+		LIMIT $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, maxNotificationAttempts, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	notifications := []*Notification{}
+
+	for rows.Next() {
+		var notification Notification
+
+		err := rows.Scan(
+			&notification.ID,
+			&notification.CreatedAt,
+			&notification.UserID,
+			&notification.Phone,
+			&notification.Kind,
+			&notification.ProductID,
+			&notification.Message,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		notifications = append(notifications, &notification)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return notifications, nil
+}
+
+func (m NotificationModel) MarkSent(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `UPDATE notifications SET sent_at = NOW(), attempts = attempts + 1 WHERE id = $1`, id)
+	return err
+}
+
+func (m NotificationModel) MarkFailed(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `UPDATE notifications SET attempts = attempts + 1 WHERE id = $1`, id)
+	return err
+}
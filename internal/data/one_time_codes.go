@@ -0,0 +1,195 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"misarfeh.com/internal/validator"
+)
+
+const (
+	// OneTimeCodeMaxAttempts is the number of times a user may try to guess the codes
+	// of a scope within OneTimeCodeAttemptWindow. Requesting a new code doesn't give
+	// the user more attempts, otherwise a 6 digit code could be guessed by resending
+	// it every minute.
+	OneTimeCodeMaxAttempts = 5
+	// OneTimeCodeAttemptWindow is the time after which the attempts of a scope are
+	// reset by the next code sent.
+	OneTimeCodeAttemptWindow = 24 * time.Hour
+	// OneTimeCodeResendInterval is the minimum time between two codes sent to the
+	// same user for the same scope.
+	OneTimeCodeResendInterval = time.Minute
+)
+
+var (
+	ErrInvalidCode      = errors.New("invalid or expired code")
+	ErrTooManyAttempts  = errors.New("too many attempts")
+	ErrCodeRecentlySent = errors.New("code recently sent")
+)
+
+// OneTimeCode is a short numeric code which is sent to the user over SMS. Only the
+// SHA-256 hash of the code is stored in the database.
+type OneTimeCode struct {
+	Plaintext string
+	Hash      []byte
+	UserID    int64
+	Scope     string
+	Expiry    time.Time
+}
+
+func generateOneTimeCode(userID int64, ttl time.Duration, scope string) (*OneTimeCode, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return nil, err
+	}
+
+	code := &OneTimeCode{
+		Plaintext: fmt.Sprintf("%06d", n.Int64()),
+		UserID:    userID,
+		Scope:     scope,
+		Expiry:    time.Now().Add(ttl),
+	}
+
+	hash := sha256.Sum256([]byte(code.Plaintext))
+	code.Hash = hash[:]
+
+	return code, nil
+}
+
+func ValidateCodePlaintext(v *validator.Validator, code string) {
+	v.Check(code != "", "code", "must be provided")
+	v.Check(validator.Matches(code, validator.CodeRX), "code", "must be 6 digits long")
+}
+
+type OneTimeCodeModel struct {
+	DB DBTX
+}
+
+// The New() method generates a code for the user and stores it, replacing any previous
+// code with the same scope. If the previous code was created less than
+// OneTimeCodeResendInterval ago ErrCodeRecentlySent is returned instead, and if the
+// user ran out of attempts in the current window ErrTooManyAttempts.
+func (m OneTimeCodeModel) New(userID int64, ttl time.Duration, scope string) (*OneTimeCode, error) {
+	code, err := generateOneTimeCode(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO one_time_codes (user_id, scope, hash, expiry)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT ON CONSTRAINT one_time_codes_pk DO UPDATE
+		SET hash = EXCLUDED.hash, expiry = EXCLUDED.expiry, created_at = NOW(),
+			attempts = CASE WHEN one_time_codes.window_start < $6 THEN 0 ELSE one_time_codes.attempts END,
+			window_start = CASE WHEN one_time_codes.window_start < $6 THEN NOW() ELSE one_time_codes.window_start END
+		WHERE one_time_codes.created_at < $5
+		AND (one_time_codes.attempts < $7 OR one_time_codes.window_start < $6)
+		RETURNING user_id`
+
+	now := time.Now()
+
+	args := []interface{}{code.UserID, code.Scope, code.Hash, code.Expiry, now.Add(-OneTimeCodeResendInterval),
+		now.Add(-OneTimeCodeAttemptWindow), OneTimeCodeMaxAttempts}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&code.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, m.notSentReason(userID, scope)
+		default:
+			return nil, err
+		}
+	}
+
+	return code, nil
+}
+
+// The notSentReason() method returns why New() didn't replace the existing code of
+// the scope.
+func (m OneTimeCodeModel) notSentReason(userID int64, scope string) error {
+	query := `
+		SELECT attempts >= $3 AND window_start >= $4
+		FROM one_time_codes
+		WHERE user_id = $1 AND scope = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var locked bool
+
+	err := m.DB.QueryRowContext(ctx, query, userID, scope, OneTimeCodeMaxAttempts,
+		time.Now().Add(-OneTimeCodeAttemptWindow)).Scan(&locked)
+	if err != nil {
+		return err
+	}
+
+	if locked {
+		return ErrTooManyAttempts
+	}
+
+	return ErrCodeRecentlySent
+}
+
+// The Check() method compares the plaintext code against the stored one. Every call
+// counts as an attempt, and once OneTimeCodeMaxAttempts is exceeded no code of the
+// scope can be used until the attempt window is over. A matching code is deleted so it can't be used twice.
+func (m OneTimeCodeModel) Check(userID int64, scope, codePlaintext string) error {
+	query := `
+		UPDATE one_time_codes
+		SET attempts = attempts + 1
+		WHERE user_id = $1 AND scope = $2
+		RETURNING hash, expiry, attempts`
+
+	var (
+		hash     []byte
+		expiry   time.Time
+		attempts int
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID, scope).Scan(&hash, &expiry, &attempts)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrInvalidCode
+		default:
+			return err
+		}
+	}
+
+	if attempts > OneTimeCodeMaxAttempts {
+		return ErrTooManyAttempts
+	}
+
+	codeHash := sha256.Sum256([]byte(codePlaintext))
+
+	if time.Now().After(expiry) || subtle.ConstantTimeCompare(codeHash[:], hash) != 1 {
+		return ErrInvalidCode
+	}
+
+	return m.DeleteAllForUser(scope, userID)
+}
+
+func (m OneTimeCodeModel) DeleteAllForUser(scope string, userID int64) error {
+	query := `
+		DELETE FROM one_time_codes
+		WHERE scope = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, scope, userID)
+	return err
+}
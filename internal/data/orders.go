@@ -0,0 +1,440 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"misarfeh.com/internal/validator"
+)
+
+var (
+	ErrInvalidTransition = errors.New("invalid order status transition")
+)
+
+const (
+	OrderPendingPayment = "pending_payment"
+	OrderPaid           = "paid"
+	OrderPreparing      = "preparing"
+	OrderShippedAbroad  = "shipped_abroad"
+	OrderInCustoms      = "in_customs"
+	OrderDelivered      = "delivered"
+	OrderCancelled      = "cancelled"
+)
+
+// orderTransitions maps every order status to the statuses it can move to. Delivered
+// and cancelled orders are final. An order can't be cancelled once it is paid, since
+// cancelling only releases its stock and coupon and doesn't give the buyer their
+// money back.
+var orderTransitions = map[string][]string{
+	OrderPendingPayment: {OrderPaid, OrderCancelled},
+	OrderPaid:           {OrderPreparing},
+	OrderPreparing:      {OrderShippedAbroad},
+	OrderShippedAbroad:  {OrderInCustoms},
+	OrderInCustoms:      {OrderDelivered},
+}
+
+// CanTransition reports whether an order in the from status can be moved to the to
+// status.
+func CanTransition(from, to string) bool {
+	return validator.In(to, orderTransitions[from]...)
+}
+
+type Order struct {
+	ID               int64         `json:"id"`
+	CreatedAt        time.Time     `json:"created_at"`
+	UserID           int64         `json:"-"`
+	ShopID           int64         `json:"shop_id"`
+	Status           string        `json:"status"`
+	ShippingCost     int64         `json:"shipping_cost"`
+	Discount         int64         `json:"discount"`
+	CouponCode       string        `json:"coupon_code,omitempty"`
+	Total            int64         `json:"total"`
+	Destination      Destination   `json:"destination"`
+	EstimatedArrival time.Time     `json:"estimated_arrival"`
+	Items            []*OrderItem  `json:"items"`
+	Events           []*OrderEvent `json:"events,omitempty"`
+	Version          int           `json:"version"`
+}
+
+// OrderItem keeps a copy of the name and price of the product, so the order is not
+// affected by later changes to the product.
+type OrderItem struct {
+	ID          int64  `json:"id"`
+	ProductID   *int64 `json:"product_id"`
+	VariantID   *int64 `json:"variant_id,omitempty"`
+	ProductName string `json:"product_name"`
+	SKU         string `json:"sku,omitempty"`
+	Quantity    int32  `json:"quantity"`
+	SalePrice   int64  `json:"sale_price"`
+	Off         int32  `json:"off"`
+	Subtotal    int64  `json:"subtotal"`
+}
+
+type OrderEvent struct {
+	ID         int64     `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	FromStatus string    `json:"from_status,omitempty"`
+	ToStatus   string    `json:"to_status"`
+}
+
+func ValidateOrderStatus(v *validator.Validator, status string) {
+	v.Check(status != "", "status", "must be provided")
+	v.Check(validator.In(status, OrderPendingPayment, OrderPaid, OrderPreparing, OrderShippedAbroad,
+		OrderInCustoms, OrderDelivered, OrderCancelled), "status", "must be a valid order status")
+}
+
+type OrderModel struct {
+	DB DBTX
+}
+
+// The insertOrderEvent() helper records a change of the status of an order. An empty
+// from status is stored as NULL and marks the creation of the order.
+func insertOrderEvent(ctx context.Context, db DBTX, orderID int64, from, to string) error {
+	query := `
+		INSERT INTO order_events (order_id, from_status, to_status)
+		VALUES ($1, NULLIF($2, ''), $3)`
+
+	_, err := db.ExecContext(ctx, query, orderID, from, to)
+	return err
+}
+
+// The Insert() method inserts the order with its items and the first event of its
+// history. The total of the order is computed from the items, the shipping cost and
+// the discount.
+func (m OrderModel) Insert(order *Order) error {
+	query := `
+		INSERT INTO orders (user_id, shop_id, status, shipping_cost, discount, coupon_code, total,
+			shipping_province, shipping_city, estimated_arrival)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id, created_at, version`
+
+	order.Total = order.ShippingCost - order.Discount
+	for _, item := range order.Items {
+		item.Subtotal = FinalPrice(item.SalePrice, item.Off) * int64(item.Quantity)
+		order.Total += item.Subtotal
+	}
+
+	args := []interface{}{order.UserID, order.ShopID, order.Status, order.ShippingCost, order.Discount,
+		order.CouponCode, order.Total, order.Destination.Province, order.Destination.City,
+		order.EstimatedArrival}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&order.ID, &order.CreatedAt, &order.Version)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range order.Items {
+		query := `
+			INSERT INTO order_items (order_id, product_id, variant_id, product_name, sku, quantity, sale_price, off)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			RETURNING id`
+
+		args := []interface{}{order.ID, item.ProductID, item.VariantID, item.ProductName, item.SKU,
+			item.Quantity, item.SalePrice, item.Off}
+
+		err = tx.QueryRowContext(ctx, query, args...).Scan(&item.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = insertOrderEvent(ctx, tx, order.ID, "", order.Status)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The Get() method returns the order with its items and its status history.
+func (m OrderModel) Get(id int64) (*Order, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, user_id, shop_id, status, shipping_cost, discount, coupon_code, total,
+			shipping_province, shipping_city, estimated_arrival, version
+		FROM orders
+		WHERE id = $1`
+
+	var order Order
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&order.ID,
+		&order.CreatedAt,
+		&order.UserID,
+		&order.ShopID,
+		&order.Status,
+		&order.ShippingCost,
+		&order.Discount,
+		&order.CouponCode,
+		&order.Total,
+		&order.Destination.Province,
+		&order.Destination.City,
+		&order.EstimatedArrival,
+		&order.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	order.Items, err = m.getItems(ctx, order.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	order.Events, err = m.getEvents(ctx, order.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &order, nil
+}
+
+// The GetForUpdate() method locks the order until the end of the transaction and
+// returns it. It must be called in a transaction.
+func (m OrderModel) GetForUpdate(id int64) (*Order, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, `SELECT id FROM orders WHERE id = $1 FOR UPDATE`, id).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return m.Get(id)
+}
+
+func (m OrderModel) getItems(ctx context.Context, orderID int64) ([]*OrderItem, error) {
+	query := `
+		SELECT id, product_id, variant_id, product_name, sku, quantity, sale_price, off
+		FROM order_items
+		WHERE order_id = $1
+		ORDER BY id`
+
+	rows, err := m.DB.QueryContext(ctx, query, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	items := []*OrderItem{}
+
+	for rows.Next() {
+		var item OrderItem
+
+		err := rows.Scan(
+			&item.ID,
+			&item.ProductID,
+			&item.VariantID,
+			&item.ProductName,
+			&item.SKU,
+			&item.Quantity,
+			&item.SalePrice,
+			&item.Off,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		item.Subtotal = FinalPrice(item.SalePrice, item.Off) * int64(item.Quantity)
+
+		items = append(items, &item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+func (m OrderModel) getEvents(ctx context.Context, orderID int64) ([]*OrderEvent, error) {
+	query := `
+		SELECT id, created_at, COALESCE(from_status, ''), to_status
+		FROM order_events
+		WHERE order_id = $1
+		ORDER BY id`
+
+	rows, err := m.DB.QueryContext(ctx, query, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	events := []*OrderEvent{}
+
+	for rows.Next() {
+		var event OrderEvent
+
+		err := rows.Scan(&event.ID, &event.CreatedAt, &event.FromStatus, &event.ToStatus)
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// The UpdateStatus() method moves the order to a new status and records the change in
+// the order history. It returns ErrInvalidTransition if the state machine doesn't allow
+// the change, and ErrEditConflict if the order was changed since it was read. When the
+// order ships the estimated arrival is recomputed from the delivery time of the shop.
+func (m OrderModel) UpdateStatus(order *Order, status string) error {
+	if !CanTransition(order.Status, status) {
+		return ErrInvalidTransition
+	}
+
+	query := `
+		UPDATE orders
+		SET status = $1, version = orders.version + 1,
+			estimated_arrival = CASE WHEN $1 = 'shipped_abroad'
+				THEN NOW() + make_interval(weeks => shops.delivery_time)
+				ELSE orders.estimated_arrival END
+		FROM shops
+		WHERE orders.id = $2 AND orders.version = $3 AND orders.shop_id = shops.id
+		RETURNING orders.version, orders.estimated_arrival`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, status, order.ID, order.Version).Scan(&order.Version, &order.EstimatedArrival)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	err = insertOrderEvent(ctx, tx, order.ID, order.Status, status)
+	if err != nil {
+		return err
+	}
+
+	if status == OrderCancelled {
+		err = releaseReservations(ctx, tx, order.ID)
+		if err != nil {
+			return err
+		}
+
+		err = releaseCouponRedemptions(ctx, tx, order.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	order.Status = status
+
+	return tx.Commit()
+}
+
+// The CancelExpired() method cancels the orders which are still waiting for payment
+// after the timeout, records the change in their history, fails their pending payments,
+// releases the stock reserved for them and gives back the coupon uses. Orders with a
+// verified payment are being settled and are skipped. It returns the number of
+// cancelled orders.
+func (m OrderModel) CancelExpired(timeout time.Duration) (int64, error) {
+	query := `
+		WITH cancelled AS (
+			UPDATE orders
+			SET status = 'cancelled', version = version + 1
+			WHERE status = 'pending_payment' AND created_at < NOW() - make_interval(secs => $1)
+			AND NOT EXISTS (
+				SELECT 1 FROM payments
+				WHERE payments.order_id = orders.id AND payments.status = 'verified'
+			)
+			RETURNING id
+		), events AS (
+			INSERT INTO order_events (order_id, from_status, to_status)
+			SELECT id, 'pending_payment', 'cancelled' FROM cancelled
+		), payments AS (
+			UPDATE payments
+			SET status = 'failed', version = version + 1
+			WHERE status = 'pending' AND order_id IN (SELECT id FROM cancelled)
+		), released AS (
+			UPDATE stock_reservations
+			SET status = 'released'
+			WHERE order_id IN (SELECT id FROM cancelled) AND status IN ('held', 'committed')
+			RETURNING variant_id, quantity
+		), restocked AS (
+			UPDATE product_variants
+			SET stock = product_variants.stock + released_stock.quantity
+			FROM (
+				SELECT variant_id, SUM(quantity) AS quantity
+				FROM released
+				GROUP BY variant_id
+			) AS released_stock
+			WHERE product_variants.id = released_stock.variant_id
+		), redemptions AS (
+			DELETE FROM coupon_redemptions
+			WHERE order_id IN (SELECT id FROM cancelled)
+			RETURNING coupon_id
+		), coupon_uses AS (
+			UPDATE coupons
+			SET uses = coupons.uses - released_uses.count
+			FROM (
+				SELECT coupon_id, COUNT(*) AS count
+				FROM redemptions
+				GROUP BY coupon_id
+			) AS released_uses
+			WHERE coupons.id = released_uses.coupon_id
+		)
+		SELECT COUNT(*) FROM cancelled`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count int64
+
+	err := m.DB.QueryRowContext(ctx, query, timeout.Seconds()).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,33 @@
+package data
+
+import "testing"
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from string
+		to   string
+		want bool
+	}{
+		{OrderPendingPayment, OrderPaid, true},
+		{OrderPendingPayment, OrderCancelled, true},
+		{OrderPendingPayment, OrderPreparing, false},
+		{OrderPaid, OrderPreparing, true},
+		{OrderPaid, OrderCancelled, false},
+		{OrderPaid, OrderPendingPayment, false},
+		{OrderPreparing, OrderShippedAbroad, true},
+		{OrderPreparing, OrderCancelled, false},
+		{OrderShippedAbroad, OrderInCustoms, true},
+		{OrderShippedAbroad, OrderDelivered, false},
+		{OrderInCustoms, OrderDelivered, true},
+		{OrderDelivered, OrderCancelled, false},
+		{OrderCancelled, OrderPaid, false},
+		{OrderPaid, OrderPaid, false},
+		{"unknown", OrderPaid, false},
+	}
+
+	for _, tt := range tests {
+		if got := CanTransition(tt.from, tt.to); got != tt.want {
+			t.Errorf("CanTransition(%q, %q) = %t; want %t", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// A payment is pending until the buyer comes back from the gateway. Once the gateway
+// verified it the buyer has been charged and it is verified, which is committed on its
+// own before the order is settled. Settling moves it to paid, or to refund_due if the
+// order can no longer be paid, for example because it was cancelled in the meantime.
+const (
+	PaymentPending   = "pending"
+	PaymentVerified  = "verified"
+	PaymentPaid      = "paid"
+	PaymentRefundDue = "refund_due"
+	PaymentFailed    = "failed"
+)
+
+// Payment records one attempt to pay an order through the payment gateway. Authority is
+// the id the gateway gave the payment when it was requested, and RefID is the reference
+// id the gateway returns once the payment is verified.
+type Payment struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	OrderID   int64     `json:"order_id"`
+	Amount    int64     `json:"amount"`
+	Authority string    `json:"authority"`
+	RefID     string    `json:"ref_id,omitempty"`
+	Status    string    `json:"status"`
+	Version   int       `json:"-"`
+}
+
+type PaymentModel struct {
+	DB DBTX
+}
+
+func (m PaymentModel) Insert(payment *Payment) error {
+	query := `
+		INSERT INTO payments (order_id, amount, authority, status)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, version`
+
+	args := []interface{}{payment.OrderID, payment.Amount, payment.Authority, payment.Status}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&payment.ID, &payment.CreatedAt, &payment.Version)
+}
+
+func (m PaymentModel) GetByAuthority(authority string) (*Payment, error) {
+	query := `
+		SELECT id, created_at, order_id, amount, authority, COALESCE(ref_id, ''), status, version
+		FROM payments
+		WHERE authority = $1`
+
+	var payment Payment
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, authority).Scan(
+		&payment.ID,
+		&payment.CreatedAt,
+		&payment.OrderID,
+		&payment.Amount,
+		&payment.Authority,
+		&payment.RefID,
+		&payment.Status,
+		&payment.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &payment, nil
+}
+
+// The GetAllVerified() method returns the payments which were verified but not settled,
+// oldest first.
+func (m PaymentModel) GetAllVerified() ([]*Payment, error) {
+	query := `
+		SELECT id, created_at, order_id, amount, authority, COALESCE(ref_id, ''), status, version
+		FROM payments
+		WHERE status = 'verified'
+		ORDER BY created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	payments := []*Payment{}
+
+	for rows.Next() {
+		var payment Payment
+
+		err := rows.Scan(
+			&payment.ID,
+			&payment.CreatedAt,
+			&payment.OrderID,
+			&payment.Amount,
+			&payment.Authority,
+			&payment.RefID,
+			&payment.Status,
+			&payment.Version,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		payments = append(payments, &payment)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return payments, nil
+}
+
+// The updateStatus() helper moves the payment to status, if it is still in one of the
+// from statuses. It returns ErrEditConflict otherwise, so a payment is only ever
+// verified and settled once even if the gateway calls back more than once.
+func (m PaymentModel) updateStatus(payment *Payment, status, refID string, from ...string) error {
+	query := `
+		UPDATE payments
+		SET status = $1, ref_id = COALESCE(NULLIF($2, ''), ref_id), version = version + 1
+		WHERE id = $3 AND status = ANY($4)
+		RETURNING version`
+
+	args := []interface{}{status, refID, payment.ID, pq.Array(from)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&payment.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	payment.Status = status
+	if refID != "" {
+		payment.RefID = refID
+	}
+
+	return nil
+}
+
+// The MarkVerified() method records that the gateway verified the payment and charged
+// the buyer. A payment which failed in the meantime, because its order was cancelled
+// for being unpaid, is verified all the same since the buyer was charged.
+func (m PaymentModel) MarkVerified(payment *Payment, refID string) error {
+	return m.updateStatus(payment, PaymentVerified, refID, PaymentPending, PaymentFailed)
+}
+
+func (m PaymentModel) MarkPaid(payment *Payment) error {
+	return m.updateStatus(payment, PaymentPaid, "", PaymentVerified)
+}
+
+// The MarkRefundDue() method records that a verified payment has to be given back to
+// the buyer, since its order can no longer be paid.
+func (m PaymentModel) MarkRefundDue(payment *Payment) error {
+	return m.updateStatus(payment, PaymentRefundDue, "", PaymentVerified)
+}
+
+func (m PaymentModel) MarkFailed(payment *Payment) error {
+	return m.updateStatus(payment, PaymentFailed, "", PaymentPending)
+}
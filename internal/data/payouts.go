@@ -0,0 +1,210 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	PayoutPending = "pending"
+	PayoutPaid    = "paid"
+)
+
+// payoutBatchLockID is the key of the advisory lock which keeps two payout batches
+// from being created at the same time and paying the same balance twice.
+const payoutBatchLockID = 14001
+
+// Payout is a transfer of the balance of a seller to their bank card. MeliCode and
+// MeliCartUrl are read from the seller when the payout is listed.
+type Payout struct {
+	ID          int64      `json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	SellerID    int64      `json:"seller_id"`
+	MeliCode    string     `json:"meli_code"`
+	MeliCartUrl string     `json:"meli_cart_url"`
+	Amount      int64      `json:"amount"`
+	Status      string     `json:"status"`
+	PaidAt      *time.Time `json:"paid_at,omitempty"`
+}
+
+type PayoutModel struct {
+	DB DBTX
+}
+
+// The CreateBatch() method creates a pending payout for every seller with a positive
+// balance, and moves the balance to the payouts account. It returns the new payouts.
+func (m PayoutModel) CreateBatch() ([]*Payout, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, payoutBatchLockID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT seller_id, SUM(amount)
+		FROM ledger_entries
+		WHERE account = 'seller'
+		GROUP BY seller_id
+		HAVING SUM(amount) > 0
+		ORDER BY seller_id`
+
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	payouts := []*Payout{}
+
+	for rows.Next() {
+		payout := Payout{Status: PayoutPending}
+
+		err := rows.Scan(&payout.SellerID, &payout.Amount)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		payouts = append(payouts, &payout)
+	}
+
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, payout := range payouts {
+		query := `
+			INSERT INTO payouts (seller_id, amount, status)
+			VALUES ($1, $2, $3)
+			RETURNING id, created_at`
+
+		err = tx.QueryRowContext(ctx, query, payout.SellerID, payout.Amount, payout.Status).Scan(&payout.ID, &payout.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		description := fmt.Sprintf("payout %d", payout.ID)
+
+		err = insertLedgerTransaction(ctx, tx,
+			&LedgerEntry{Account: AccountSeller, SellerID: &payout.SellerID, PayoutID: &payout.ID, Amount: -payout.Amount, Description: description},
+			&LedgerEntry{Account: AccountPayouts, PayoutID: &payout.ID, Amount: payout.Amount, Description: description},
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return payouts, tx.Commit()
+}
+
+// The GetAllPending() method returns the payouts which haven't been transferred yet,
+// with the bank details of their sellers.
+func (m PayoutModel) GetAllPending() ([]*Payout, error) {
+	query := `
+		SELECT payouts.id, payouts.created_at, payouts.seller_id, COALESCE(sellers.meli_code, ''),
+			COALESCE(sellers.meli_cart_url, ''), payouts.amount, payouts.status, payouts.paid_at
+		FROM payouts
+		JOIN sellers ON payouts.seller_id = sellers.id
+		WHERE payouts.status = 'pending'
+		ORDER BY payouts.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	payouts := []*Payout{}
+
+	for rows.Next() {
+		var payout Payout
+
+		err := rows.Scan(
+			&payout.ID,
+			&payout.CreatedAt,
+			&payout.SellerID,
+			&payout.MeliCode,
+			&payout.MeliCartUrl,
+			&payout.Amount,
+			&payout.Status,
+			&payout.PaidAt,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		payouts = append(payouts, &payout)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return payouts, nil
+}
+
+// The MarkPaid() method records that the payout was transferred to the bank card of
+// the seller. Paid payouts can't be changed again.
+func (m PayoutModel) MarkPaid(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		UPDATE payouts
+		SET status = 'paid', paid_at = NOW()
+		WHERE id = $1 AND status = 'pending'
+		RETURNING amount`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	var amount int64
+
+	err = tx.QueryRowContext(ctx, query, id).Scan(&amount)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	description := fmt.Sprintf("payout %d transferred", id)
+
+	err = insertLedgerTransaction(ctx, tx,
+		&LedgerEntry{Account: AccountPayouts, PayoutID: &id, Amount: -amount, Description: description},
+		&LedgerEntry{Account: AccountBank, PayoutID: &id, Amount: amount, Description: description},
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
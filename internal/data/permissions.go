@@ -0,0 +1,110 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	PermissionCategoriesWrite  = "categories:write"
+	PermissionShopsVerify      = "shops:verify"
+	PermissionCommentsModerate = "comments:moderate"
+	PermissionUsersManage      = "users:manage"
+	PermissionPayoutsManage    = "payouts:manage"
+)
+
+// Buyers and sellers don't need any permission codes, a buyer is any activated user
+// and a seller is a user with a row in the sellers table. The staff roles are just
+// named sets of permissions.
+const (
+	RoleBuyer     = "buyer"
+	RoleSeller    = "seller"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var RolePermissions = map[string][]string{
+	RoleModerator: {PermissionShopsVerify, PermissionCommentsModerate},
+	RoleAdmin: {PermissionCategoriesWrite, PermissionShopsVerify,
+		PermissionCommentsModerate, PermissionUsersManage, PermissionPayoutsManage},
+}
+
+type Permissions []string
+
+// Include checks whether the Permissions slice contains a specific permission code.
+func (p Permissions) Include(code string) bool {
+	for i := range p {
+		if code == p[i] {
+			return true
+		}
+	}
+	return false
+}
+
+type PermissionModel struct {
+	DB DBTX
+}
+
+func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+	query := `
+		SELECT permissions.code
+		FROM permissions
+		INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+		WHERE users_permissions.user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var permissions Permissions
+
+	for rows.Next() {
+		var permission string
+
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, permission)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
+func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
+	query := `
+		INSERT INTO users_permissions
+		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+		ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	return err
+}
+
+func (m PermissionModel) DeleteAllForUser(userID int64) error {
+	query := `
+		DELETE FROM users_permissions
+		WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
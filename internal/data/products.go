@@ -8,24 +8,30 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/lib/pq"
 	"misarfeh.com/internal/validator"
 )
 
 type Product struct {
-	ID          int64     `json:"id"`
-	ShopID      int64     `json:"-"`
-	CategoryID  int64     `json:"-"`
-	Category    string    `json:"category"`
-	CountryID   int64     `json:"-"`
-	Country     string    `json:"country"`
-	CreatedAt   time.Time `json:"-"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	Price       float32   `json:"price"`
-	SalePrice   int64     `json:"sale_price"`
-	Off         int32     `json:"Off"`
-	Brand       string    `json:"brand"`
-	ImgUrls     []string  `json:"img_urls"`
+	ID          int64             `json:"id"`
+	ShopID      int64             `json:"shop_id"`
+	CategoryID  int64             `json:"-"`
+	Category    string            `json:"category"`
+	CountryID   int64             `json:"-"`
+	Country     string            `json:"country"`
+	CreatedAt   time.Time         `json:"-"`
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Price       *Money            `json:"price,omitempty"`
+	SalePrice   int64             `json:"sale_price"`
+	Off         int32             `json:"Off"`
+	Brand       string            `json:"brand"`
+	Weight      int32             `json:"weight,omitempty"`
+	ImgUrls     []string          `json:"-"`
+	Images      []*Image          `json:"images"`
+	Options     []*ProductOption  `json:"options,omitempty"`
+	Variants    []*ProductVariant `json:"variants,omitempty"`
+	Version     int               `json:"version"`
 }
 
 func (p Product) MarshalJSON() ([]byte, error) {
@@ -37,22 +43,44 @@ func (p Product) MarshalJSON() ([]byte, error) {
 
 	type ProductAlies Product
 
+	// Prices are sent as numbers, like they are received, and also formatted in
+	// Persian for display.
 	aux := struct {
 		ProductAlies
-		Off string `json:"off,omitempty"`
+		Price            *int64 `json:"price,omitempty"`
+		PriceCurrency    string `json:"price_currency,omitempty"`
+		PriceDisplay     string `json:"price_display,omitempty"`
+		SalePriceDisplay string `json:"sale_price_display"`
+		Off              string `json:"off,omitempty"`
 	}{
-		ProductAlies: ProductAlies(p),
-		Off:          off,
+		ProductAlies:     ProductAlies(p),
+		SalePriceDisplay: Toman(p.SalePrice).String(),
+		Off:              off,
+	}
+
+	if p.Price != nil {
+		aux.Price = &p.Price.Amount
+		aux.PriceCurrency = p.Price.Currency
+		aux.PriceDisplay = p.Price.String()
 	}
 
 	return json.Marshal(aux)
 }
 
+// FinalPrice returns the sale price after the off percentage is taken off.
+func FinalPrice(salePrice int64, off int32) int64 {
+	return salePrice * int64(100-off) / 100
+}
+
 func ValidateProduct(v *validator.Validator, product *Product) {
+	v.Check(product.ShopID > 0, "shop_id", "must be provided")
+
 	v.Check(product.Name != "", "name", "must be provided")
 	v.Check(len(product.Name) <= 100, "name", "must not be more than 100 bytes long")
 
-	v.Check(product.Price >= float32(0), "price", "must be greater than zero")
+	if product.Price != nil {
+		ValidateMoney(v, "price", *product.Price)
+	}
 
 	v.Check(product.SalePrice != 0, "sale_price", "must be provided")
 
@@ -64,6 +92,9 @@ func ValidateProduct(v *validator.Validator, product *Product) {
 	v.Check(product.Brand != "", "brand", "must be provided")
 	v.Check(len(product.Brand) <= 100, "brand", "must not be more than 100 bytes long")
 
+	v.Check(product.Weight >= 0, "weight", "must not be negative")
+	v.Check(product.Weight <= 1000000, "weight", "must not be more than 1000000 grams")
+
 	v.Check(product.Category != "", "category", "must be provided")
 	v.Check(len(product.Category) <= 100, "category", "must not be more than 100 bytes long")
 
@@ -74,30 +105,54 @@ func ValidateProduct(v *validator.Validator, product *Product) {
 	v.Check(len(product.ImgUrls) >= 1, "img_urls", "must contain at least 1 img")
 	v.Check(len(product.ImgUrls) <= 5, "img_urls", "must not contain more than 5 img")
 	v.Check(validator.Unique(product.ImgUrls), "img_urls", "must not contain duplicate values")
+
+	if product.Variants != nil {
+		ValidateVariants(v, product.Variants)
+	}
 }
 
 type ProductModel struct {
-	DB *sql.DB
+	DB DBTX
 }
 
-func (m ProductModel) GetAll(title, brand string, shop_id, country_id int64, filters Filters) ([]*Shop, Metadata, error) {
+// The GetAll() method searches the name and description of products, and filters them
+// by the other arguments. Zero values disable a filter. Filtering by a category also
+// matches the products of its subcategories.
+func (m ProductModel) GetAll(search, brand string, shopID, categoryID, countryID, minPrice, maxPrice int64, minOff int32, filters Filters) ([]*Product, Metadata, error) {
 	query := fmt.Sprintf(`
-		SELECT COUNT(*) OVER(), shops.id, created_at, title, year, logo_url, delivery_time
-		FROM shops 
-		JOIN shops_countries ON shops.id = shops_countries.shop_id 
-		JOIN countries ON shops_countries.country_id = countries.id
-		WHERE (title ILIKE $1 OR instagram ILIKE $1 OR $1 = '')
-		AND (name = ANY($2) OR $2 = '{}')
-		GROUP BY shops.id
-		ORDER BY %s %s, id ASC
-		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+		SELECT COUNT(*) OVER(), products.id, COALESCE(products.shop_id, 0), COALESCE(products.category_id, 0),
+			COALESCE(categories.name, ''), COALESCE(products.country_id, 0), COALESCE(countries.name, ''),
+			products.created_at, products.name, products.description, products.price_amount,
+			products.price_currency, products.sale_price, products.off, products.brand, products.weight, products.version,
+			COALESCE((SELECT array_agg(images.url ORDER BY images.position, images.id) FROM images WHERE images.product_id = products.id), '{}'),
+			COALESCE((SELECT jsonb_agg(jsonb_build_object('id', images.id, 'url', images.url, 'variants', images.variants)
+				ORDER BY images.position, images.id) FROM images WHERE images.product_id = products.id), '[]')
+		FROM products
+		LEFT JOIN categories ON products.category_id = categories.id
+		LEFT JOIN countries ON products.country_id = countries.id
+		WHERE (to_tsvector('simple', products.name || ' ' || products.description) @@ plainto_tsquery('simple', $1) OR $1 = '')
+		AND (LOWER(products.brand) = LOWER($2) OR $2 = '')
+		AND (products.shop_id = $3 OR $3 = 0)
+		AND ($4 = 0 OR products.category_id IN (
+			WITH RECURSIVE subcategories AS (
+				SELECT id FROM categories WHERE id = $4
+				UNION
+				SELECT categories.id FROM categories
+				JOIN subcategories ON categories.parent_id = subcategories.id
+			)
+			SELECT id FROM subcategories))
+		AND (products.country_id = $5 OR $5 = 0)
+		AND (products.sale_price >= $6 OR $6 = 0)
+		AND (products.sale_price <= $7 OR $7 = 0)
+		AND products.off >= $8
+		ORDER BY products.%s %s, products.id ASC
+		LIMIT $9 OFFSET $10`, filters.sortColumn(), filters.sortDirection())
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	title = fmt.Sprintf("%%%s%%", title)
-
-	args := []interface{}{title, filters.limit(), filters.offset()}
+	args := []interface{}{search, brand, shopID, categoryID, countryID, minPrice, maxPrice, minOff,
+		filters.limit(), filters.offset()}
 
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -107,26 +162,48 @@ func (m ProductModel) GetAll(title, brand string, shop_id, country_id int64, fil
 	defer rows.Close()
 
 	totalRecords := 0
-	shops := []*Shop{}
+	products := []*Product{}
 
 	for rows.Next() {
-		var shop Shop
+		var product Product
+		var priceAmount sql.NullInt64
+		var priceCurrency sql.NullString
+		var images []byte
 
 		err := rows.Scan(
 			&totalRecords,
-			&shop.ID,
-			&shop.CreatedAt,
-			&shop.Title,
-			&shop.Year,
-			&shop.LogoUrl,
-			&shop.DeliveryTime,
+			&product.ID,
+			&product.ShopID,
+			&product.CategoryID,
+			&product.Category,
+			&product.CountryID,
+			&product.Country,
+			&product.CreatedAt,
+			&product.Name,
+			&product.Description,
+			&priceAmount,
+			&priceCurrency,
+			&product.SalePrice,
+			&product.Off,
+			&product.Brand,
+			&product.Weight,
+			&product.Version,
+			pq.Array(&product.ImgUrls),
+			&images,
 		)
 
 		if err != nil {
 			return nil, Metadata{}, err
 		}
 
-		shops = append(shops, &shop)
+		err = json.Unmarshal(images, &product.Images)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		product.Price = scanMoney(priceAmount, priceCurrency)
+
+		products = append(products, &product)
 	}
 
 	if err = rows.Err(); err != nil {
@@ -135,24 +212,26 @@ func (m ProductModel) GetAll(title, brand string, shop_id, country_id int64, fil
 
 	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
 
-	return shops, metadata, nil
+	return products, metadata, nil
 }
 
 func (m ProductModel) Insert(product *Product) error {
 	query := `
 		INSERT INTO products (shop_id, category_id, country_id,
-			name, description, price, sale_price, off, brand)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
-		RETURNING id, created_at`
+			name, description, price_amount, price_currency, sale_price, off, brand, weight)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING id, created_at, version`
 
-	args := []interface{}{4, product.CategoryID, product.CountryID,
-		product.Name, product.Description, product.Price, product.SalePrice,
-		product.Off, product.Brand}
+	priceAmount, priceCurrency := moneyArgs(product.Price)
+
+	args := []interface{}{product.ShopID, product.CategoryID, product.CountryID,
+		product.Name, product.Description, priceAmount, priceCurrency, product.SalePrice,
+		product.Off, product.Brand, product.Weight}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&product.ID, &product.CreatedAt)
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&product.ID, &product.CreatedAt, &product.Version)
 }
 
 func (m ProductModel) Get(id int64) (*Product, error) {
@@ -162,11 +241,13 @@ func (m ProductModel) Get(id int64) (*Product, error) {
 
 	query := `
 		SELECT id, shop_id, category_id, country_id, name,
-			description, price, sale_price, off, brand
+			description, price_amount, price_currency, sale_price, off, brand, weight, version
 		FROM products 
 		WHERE id = $1`
 
 	var product Product
+	var priceAmount sql.NullInt64
+	var priceCurrency sql.NullString
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -178,10 +259,13 @@ func (m ProductModel) Get(id int64) (*Product, error) {
 		&product.CountryID,
 		&product.Name,
 		&product.Description,
-		&product.Price,
+		&priceAmount,
+		&priceCurrency,
 		&product.SalePrice,
 		&product.Off,
 		&product.Brand,
+		&product.Weight,
+		&product.Version,
 	)
 
 	if err != nil {
@@ -193,6 +277,8 @@ func (m ProductModel) Get(id int64) (*Product, error) {
 		}
 	}
 
+	product.Price = scanMoney(priceAmount, priceCurrency)
+
 	return &product, nil
 }
 
@@ -200,26 +286,42 @@ func (m ProductModel) Update(product *Product) error {
 	query := `
 		UPDATE products
 		SET name = $1, category_id = $2, country_id = $3, description = $4,
-	    	price = $5, sale_price = $6, off = $7, brand = $8
-		WHERE id = $9
-		RETURNING id`
+	    	price_amount = $5, price_currency = $6, sale_price = $7, off = $8, brand = $9,
+	    	weight = $10, version = version + 1
+		WHERE id = $11 AND version = $12
+		RETURNING version`
+
+	priceAmount, priceCurrency := moneyArgs(product.Price)
 
 	args := []interface{}{
 		product.Name,
 		product.CategoryID,
 		product.CountryID,
 		product.Description,
-		product.Price,
+		priceAmount,
+		priceCurrency,
 		product.SalePrice,
 		product.Off,
 		product.Brand,
+		product.Weight,
 		product.ID,
+		product.Version,
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&product.ID)
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&product.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
 }
 
 func (m ProductModel) Delete(id int64) error {
@@ -229,26 +331,81 @@ func (m ProductModel) Delete(id int64) error {
 
 	query := `
 		DELETE FROM products
-		WHERE id = $1`
+		WHERE id = $1
+		RETURNING COALESCE(shop_id, 0)`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	result, err := m.DB.ExecContext(ctx, query, id)
+	tx, err := beginTx(ctx, m.DB)
 	if err != nil {
 		return err
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	defer tx.Rollback()
+
+	var shopID int64
+
+	err = tx.QueryRowContext(ctx, query, id).Scan(&shopID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	// The comments of the product are removed by the cascade, so the shop rating has
+	// to be recomputed without them.
+	err = updateShopRating(ctx, tx, shopID)
 	if err != nil {
 		return err
 	}
 
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
+	return tx.Commit()
+}
+
+// The RepriceForSeller() method recomputes the sale price of every product of the shops
+// of the seller whose original price is in currency, from the rate in toman for one
+// unit of the currency. Prices are rounded to the nearest toman, but never below one
+// toman since sale prices must be positive. It returns the number of repriced
+// products.
+func (m ProductModel) RepriceForSeller(sellerID int64, currency string, rate int64) (int64, error) {
+	query := `
+		UPDATE products
+		SET sale_price = GREATEST(1, ROUND(products.price_amount::numeric * $3 / $4)), version = products.version + 1
+		FROM shops
+		WHERE products.shop_id = shops.id AND shops.seller_id = $1
+		AND products.price_currency = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, sellerID, currency, rate, minorUnits(currency))
+	if err != nil {
+		return 0, err
 	}
 
-	return nil
+	return result.RowsAffected()
+}
+
+// The scanMoney() helper builds the optional original price of a product from its
+// nullable columns.
+func scanMoney(amount sql.NullInt64, currency sql.NullString) *Money {
+	if !amount.Valid || !currency.Valid {
+		return nil
+	}
+
+	return &Money{Amount: amount.Int64, Currency: currency.String}
+}
+
+func moneyArgs(m *Money) (interface{}, interface{}) {
+	if m == nil {
+		return nil, nil
+	}
+
+	return m.Amount, m.Currency
 }
 
 type MockProductModel struct{}
@@ -269,6 +426,10 @@ func (m MockProductModel) Delete(id int64) error {
 	return nil
 }
 
-func (m MockProductModel) GetAll(name, brand string, shop_id, country_id int64, filters Filters) ([]*Shop, Metadata, error) {
+func (m MockProductModel) RepriceForSeller(sellerID int64, currency string, rate int64) (int64, error) {
+	return 0, nil
+}
+
+func (m MockProductModel) GetAll(search, brand string, shopID, categoryID, countryID, minPrice, maxPrice int64, minOff int32, filters Filters) ([]*Product, Metadata, error) {
 	return nil, Metadata{}, nil
 }
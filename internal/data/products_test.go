@@ -0,0 +1,82 @@
+package data
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestFinalPrice(t *testing.T) {
+	tests := []struct {
+		salePrice int64
+		off       int32
+		want      int64
+	}{
+		{100000, 0, 100000},
+		{100000, 15, 85000},
+		{99999, 10, 89999},
+		{100000, 100, 0},
+	}
+
+	for _, tt := range tests {
+		if got := FinalPrice(tt.salePrice, tt.off); got != tt.want {
+			t.Errorf("FinalPrice(%d, %d) = %d; want %d", tt.salePrice, tt.off, got, tt.want)
+		}
+	}
+}
+
+func TestProductMarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		product Product
+		want    map[string]interface{}
+	}{
+		{
+			name:    "toman only",
+			product: Product{SalePrice: 1250000},
+			want: map[string]interface{}{
+				"sale_price":         float64(1250000),
+				"sale_price_display": "۱٬۲۵۰٬۰۰۰ تومان",
+			},
+		},
+		{
+			name:    "foreign price",
+			product: Product{Price: &Money{Amount: 1999, Currency: "USD"}, SalePrice: 1199400, Off: 10},
+			want: map[string]interface{}{
+				"price":              float64(1999),
+				"price_currency":     "USD",
+				"price_display":      "۱۹٫۹۹ دلار",
+				"sale_price":         float64(1199400),
+				"sale_price_display": "۱٬۱۹۹٬۴۰۰ تومان",
+				"off":                "10%",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			js, err := json.Marshal(tt.product)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got map[string]interface{}
+
+			err = json.Unmarshal(js, &got)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for key, want := range tt.want {
+				if got[key] != want {
+					t.Errorf("got %s %#v; want %#v", key, got[key], want)
+				}
+			}
+
+			if tt.product.Price == nil {
+				if _, ok := got["price"]; ok {
+					t.Errorf("got price %#v; want none", got["price"])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,295 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"misarfeh.com/internal/validator"
+)
+
+const (
+	PromotionScheduled = "scheduled"
+	PromotionActive    = "active"
+	PromotionEnded     = "ended"
+)
+
+// Promotion is a sale of a shop. While it is active the off of the products in its
+// scope is set to Off, and when it ends their previous off is put back.
+type Promotion struct {
+	ID         int64     `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	ShopID     int64     `json:"shop_id"`
+	Off        int32     `json:"off"`
+	StartsAt   time.Time `json:"starts_at"`
+	EndsAt     time.Time `json:"ends_at"`
+	Scope      string    `json:"scope"`
+	CategoryID *int64    `json:"category_id,omitempty"`
+	ProductID  *int64    `json:"product_id,omitempty"`
+	Status     string    `json:"status"`
+}
+
+func ValidatePromotion(v *validator.Validator, promotion *Promotion) {
+	v.Check(promotion.Off != 0, "off", "must be provided")
+	v.Check(promotion.Off > 0, "off", "must be greater than zero")
+	v.Check(promotion.Off < 100, "off", "must be less than 100")
+
+	v.Check(!promotion.StartsAt.IsZero(), "starts_at", "must be provided")
+	v.Check(!promotion.EndsAt.IsZero(), "ends_at", "must be provided")
+	v.Check(promotion.EndsAt.After(promotion.StartsAt), "ends_at", "must be after starts_at")
+	v.Check(promotion.EndsAt.After(time.Now()), "ends_at", "must be in the future")
+
+	validateScope(v, promotion.Scope, promotion.CategoryID, promotion.ProductID)
+}
+
+type PromotionModel struct {
+	DB DBTX
+}
+
+func (m PromotionModel) Insert(promotion *Promotion) error {
+	query := `
+		INSERT INTO promotions (shop_id, off, starts_at, ends_at, scope, category_id, product_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at, status`
+
+	args := []interface{}{promotion.ShopID, promotion.Off, promotion.StartsAt, promotion.EndsAt,
+		promotion.Scope, promotion.CategoryID, promotion.ProductID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&promotion.ID, &promotion.CreatedAt, &promotion.Status)
+}
+
+func (m PromotionModel) GetAllForShop(shopID int64) ([]*Promotion, error) {
+	query := `
+		SELECT id, created_at, shop_id, off, starts_at, ends_at, scope, category_id, product_id, status
+		FROM promotions
+		WHERE shop_id = $1
+		ORDER BY starts_at DESC, id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, shopID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	promotions := []*Promotion{}
+
+	for rows.Next() {
+		var promotion Promotion
+
+		err := rows.Scan(
+			&promotion.ID,
+			&promotion.CreatedAt,
+			&promotion.ShopID,
+			&promotion.Off,
+			&promotion.StartsAt,
+			&promotion.EndsAt,
+			&promotion.Scope,
+			&promotion.CategoryID,
+			&promotion.ProductID,
+			&promotion.Status,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		promotions = append(promotions, &promotion)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return promotions, nil
+}
+
+// The restorePromotionProducts() helper puts back the off the products of the
+// promotions had before they started. Products whose off was changed by the seller
+// during the promotion keep the new off.
+func restorePromotionProducts(ctx context.Context, db DBTX, promotionIDs ...int64) error {
+	query := `
+		WITH restored AS (
+			DELETE FROM promotion_products
+			WHERE promotion_id = ANY($1)
+			RETURNING promotion_id, product_id, previous_off
+		)
+		UPDATE products
+		SET off = restored.previous_off, version = products.version + 1
+		FROM restored, promotions
+		WHERE products.id = restored.product_id AND promotions.id = restored.promotion_id
+		AND products.off = promotions.off`
+
+	_, err := db.ExecContext(ctx, query, pq.Array(promotionIDs))
+	return err
+}
+
+// The Delete() method deletes the promotion, putting back the off of its products if
+// it is active.
+func (m PromotionModel) Delete(shopID, id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	var status string
+
+	err = tx.QueryRowContext(ctx, `SELECT status FROM promotions WHERE id = $1 AND shop_id = $2 FOR UPDATE`, id, shopID).Scan(&status)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	if status == PromotionActive {
+		err = restorePromotionProducts(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM promotions WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The Apply() method ends the active promotions whose window closed and starts the
+// scheduled ones whose window opened. A product which is already in an active
+// promotion isn't changed by another one. It returns the number of started and ended
+// promotions.
+func (m PromotionModel) Apply() (started, ended int64, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	defer tx.Rollback()
+
+	query := `
+		UPDATE promotions
+		SET status = 'ended'
+		WHERE id IN (
+			SELECT id FROM promotions
+			WHERE status IN ('scheduled', 'active') AND ends_at <= NOW()
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id`
+
+	endedIDs, err := queryIDs(ctx, tx, query)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	err = restorePromotionProducts(ctx, tx, endedIDs...)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	query = `
+		UPDATE promotions
+		SET status = 'active'
+		WHERE id IN (
+			SELECT id FROM promotions
+			WHERE status = 'scheduled' AND starts_at <= NOW()
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id`
+
+	startedIDs, err := queryIDs(ctx, tx, query)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	query = `
+		WITH RECURSIVE subcategories AS (
+			SELECT promotions.id AS promotion_id, promotions.category_id AS id
+			FROM promotions
+			WHERE promotions.id = ANY($1) AND promotions.scope = 'category'
+			UNION
+			SELECT subcategories.promotion_id, categories.id
+			FROM categories
+			JOIN subcategories ON categories.parent_id = subcategories.id
+		)
+		INSERT INTO promotion_products (promotion_id, product_id, previous_off)
+		SELECT DISTINCT ON (products.id) promotions.id, products.id, products.off
+		FROM promotions
+		JOIN products ON products.shop_id = promotions.shop_id
+		WHERE promotions.id = ANY($1)
+		AND (promotions.scope = 'shop'
+			OR (promotions.scope = 'product' AND products.id = promotions.product_id)
+			OR (promotions.scope = 'category' AND products.category_id IN (
+				SELECT id FROM subcategories WHERE subcategories.promotion_id = promotions.id)))
+		AND NOT EXISTS (SELECT 1 FROM promotion_products WHERE promotion_products.product_id = products.id)
+		ORDER BY products.id, promotions.id`
+
+	_, err = tx.ExecContext(ctx, query, pq.Array(startedIDs))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	query = `
+		UPDATE products
+		SET off = promotions.off, version = products.version + 1
+		FROM promotion_products, promotions
+		WHERE promotion_products.promotion_id = ANY($1)
+		AND products.id = promotion_products.product_id AND promotions.id = promotion_products.promotion_id`
+
+	_, err = tx.ExecContext(ctx, query, pq.Array(startedIDs))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return int64(len(startedIDs)), int64(len(endedIDs)), nil
+}
+
+// The queryIDs() helper runs a query returning a single id column and collects the ids.
+func queryIDs(ctx context.Context, db DBTX, query string, args ...interface{}) ([]int64, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	ids := []int64{}
+
+	for rows.Next() {
+		var id int64
+
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
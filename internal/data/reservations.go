@@ -0,0 +1,229 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrReservationExpired = errors.New("stock reservation expired")
+)
+
+// A reservation takes stock out of a variant for an order. It is held until the order
+// is paid, when it is committed, or until it expires, when the sweeper releases it
+// and puts the stock back. Committed reservations are released if the order is
+// cancelled.
+const (
+	ReservationHeld      = "held"
+	ReservationCommitted = "committed"
+	ReservationReleased  = "released"
+)
+
+// VariantReservations is the reservation state of one variant of a product, as shown to
+// the owner of the shop. Stock is what is left to sell.
+type VariantReservations struct {
+	VariantID int64  `json:"variant_id"`
+	SKU       string `json:"sku"`
+	Stock     int32  `json:"stock"`
+	Held      int32  `json:"held"`
+	Committed int32  `json:"committed"`
+}
+
+type ReservationModel struct {
+	DB DBTX
+}
+
+// The Hold() method takes quantity items of the variant out of stock for the order,
+// until the ttl expires. The stock is only decremented if enough is left, so concurrent
+// checkouts can't oversell it.
+func (m ReservationModel) Hold(orderID, variantID int64, quantity int32, ttl time.Duration) error {
+	query := `
+		UPDATE product_variants
+		SET stock = stock - $2
+		WHERE id = $1 AND stock >= $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, query, variantID, quantity)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrOutOfStock
+	}
+
+	query = `
+		INSERT INTO stock_reservations (expires_at, order_id, variant_id, quantity)
+		VALUES (NOW() + make_interval(secs => $1), $2, $3, $4)`
+
+	_, err = tx.ExecContext(ctx, query, ttl.Seconds(), orderID, variantID, quantity)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The Commit() method keeps the reservations of the order from expiring, it is called
+// before the order is paid. It returns ErrReservationExpired if any of them was already
+// released. Committing the reservations of an order twice is allowed.
+func (m ReservationModel) Commit(orderID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	// Lock the reservations, so the sweeper can't release them between the check and
+	// the update.
+	query := `
+		SELECT COUNT(*) FILTER (WHERE status = 'released')
+		FROM (SELECT status FROM stock_reservations WHERE order_id = $1 FOR UPDATE) AS reservations`
+
+	var released int
+
+	err = tx.QueryRowContext(ctx, query, orderID).Scan(&released)
+	if err != nil {
+		return err
+	}
+
+	if released > 0 {
+		return ErrReservationExpired
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE stock_reservations SET status = 'committed' WHERE order_id = $1 AND status = 'held'`, orderID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The releaseReservations() helper releases the held and committed reservations of the
+// orders and puts their stock back.
+func releaseReservations(ctx context.Context, db DBTX, orderIDs ...int64) error {
+	query := `
+		WITH released AS (
+			UPDATE stock_reservations
+			SET status = 'released'
+			WHERE order_id = ANY($1) AND status IN ('held', 'committed')
+			RETURNING variant_id, quantity
+		)
+		UPDATE product_variants
+		SET stock = product_variants.stock + released_stock.quantity
+		FROM (
+			SELECT variant_id, SUM(quantity) AS quantity
+			FROM released
+			GROUP BY variant_id
+		) AS released_stock
+		WHERE product_variants.id = released_stock.variant_id`
+
+	_, err := db.ExecContext(ctx, query, pq.Array(orderIDs))
+	return err
+}
+
+// The ReleaseExpired() method releases the held reservations which expired and puts
+// their stock back. It returns the number of released reservations.
+func (m ReservationModel) ReleaseExpired() (int64, error) {
+	query := `
+		WITH expired AS (
+			SELECT id
+			FROM stock_reservations
+			WHERE status = 'held' AND expires_at < NOW()
+			FOR UPDATE SKIP LOCKED
+		), released AS (
+			UPDATE stock_reservations
+			SET status = 'released'
+			WHERE id IN (SELECT id FROM expired)
+			RETURNING variant_id, quantity
+		), restocked AS (
+			UPDATE product_variants
+			SET stock = product_variants.stock + released_stock.quantity
+			FROM (
+				SELECT variant_id, SUM(quantity) AS quantity
+				FROM released
+				GROUP BY variant_id
+			) AS released_stock
+			WHERE product_variants.id = released_stock.variant_id
+		)
+		SELECT COUNT(*) FROM released`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count int64
+
+	err := m.DB.QueryRowContext(ctx, query).Scan(&count)
+	return count, err
+}
+
+// The GetAllForProduct() method returns the reservation state of every variant of the
+// product.
+func (m ReservationModel) GetAllForProduct(productID int64) ([]*VariantReservations, error) {
+	query := `
+		SELECT product_variants.id, product_variants.sku, product_variants.stock,
+			COALESCE(SUM(stock_reservations.quantity) FILTER (WHERE stock_reservations.status = 'held'), 0),
+			COALESCE(SUM(stock_reservations.quantity) FILTER (WHERE stock_reservations.status = 'committed'), 0)
+		FROM product_variants
+		LEFT JOIN stock_reservations ON stock_reservations.variant_id = product_variants.id
+		WHERE product_variants.product_id = $1
+		GROUP BY product_variants.id
+		ORDER BY product_variants.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	reservations := []*VariantReservations{}
+
+	for rows.Next() {
+		var reservation VariantReservations
+
+		err := rows.Scan(
+			&reservation.VariantID,
+			&reservation.SKU,
+			&reservation.Stock,
+			&reservation.Held,
+			&reservation.Committed,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		reservations = append(reservations, &reservation)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return reservations, nil
+}
@@ -16,7 +16,7 @@ type Seller struct {
 }
 
 type SellerModel struct {
-	DB *sql.DB
+	DB DBTX
 }
 
 func (m SellerModel) Insert(seller *Seller) error {
@@ -0,0 +1,320 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"misarfeh.com/internal/validator"
+)
+
+// provinces maps the codes of the provinces of Iran to their Persian names.
+var provinces = map[string]string{
+	"alborz":                 "البرز",
+	"ardabil":                "اردبیل",
+	"bushehr":                "بوشهر",
+	"chaharmahal-bakhtiari":  "چهارمحال و بختیاری",
+	"east-azerbaijan":        "آذربایجان شرقی",
+	"fars":                   "فارس",
+	"gilan":                  "گیلان",
+	"golestan":               "گلستان",
+	"hamadan":                "همدان",
+	"hormozgan":              "هرمزگان",
+	"ilam":                   "ایلام",
+	"isfahan":                "اصفهان",
+	"kerman":                 "کرمان",
+	"kermanshah":             "کرمانشاه",
+	"khuzestan":              "خوزستان",
+	"kohgiluyeh-boyer-ahmad": "کهگیلویه و بویراحمد",
+	"kurdistan":              "کردستان",
+	"lorestan":               "لرستان",
+	"markazi":                "مرکزی",
+	"mazandaran":             "مازندران",
+	"north-khorasan":         "خراسان شمالی",
+	"qazvin":                 "قزوین",
+	"qom":                    "قم",
+	"razavi-khorasan":        "خراسان رضوی",
+	"semnan":                 "سمنان",
+	"sistan-baluchestan":     "سیستان و بلوچستان",
+	"south-khorasan":         "خراسان جنوبی",
+	"tehran":                 "تهران",
+	"west-azerbaijan":        "آذربایجان غربی",
+	"yazd":                   "یزد",
+	"zanjan":                 "زنجان",
+}
+
+// ValidProvince reports whether code is the code of a province of Iran.
+func ValidProvince(code string) bool {
+	_, ok := provinces[code]
+	return ok
+}
+
+// Destination is where an order is shipped to in Iran.
+type Destination struct {
+	Province string `json:"province"`
+	City     string `json:"city"`
+}
+
+func ValidateDestination(v *validator.Validator, destination Destination) {
+	v.Check(destination.Province != "", "province", "must be provided")
+	v.Check(ValidProvince(destination.Province), "province", "must be a province of Iran")
+
+	v.Check(destination.City != "", "city", "must be provided")
+	v.Check(len(destination.City) <= 100, "city", "must not be more than 100 bytes long")
+}
+
+// ProvinceRate overrides the rates of the shipping rules for one province.
+type ProvinceRate struct {
+	Province  string `json:"province"`
+	FlatRate  int64  `json:"flat_rate"`
+	PerKgRate int64  `json:"per_kg_rate"`
+}
+
+// ShippingRules is what a shop charges for shipping an order: a flat rate plus a rate
+// for every started kilogram, both in toman. Orders whose subtotal reaches the free
+// threshold ship for free.
+type ShippingRules struct {
+	ShopID        int64           `json:"-"`
+	FlatRate      int64           `json:"flat_rate"`
+	PerKgRate     int64           `json:"per_kg_rate"`
+	FreeThreshold *int64          `json:"free_threshold,omitempty"`
+	Provinces     []*ProvinceRate `json:"provinces"`
+	Version       int             `json:"version"`
+}
+
+func ValidateShippingRules(v *validator.Validator, rules *ShippingRules) {
+	v.Check(rules.FlatRate >= 0, "flat_rate", "must not be negative")
+	v.Check(rules.PerKgRate >= 0, "per_kg_rate", "must not be negative")
+
+	v.Check(rules.FreeThreshold == nil || *rules.FreeThreshold > 0, "free_threshold", "must be greater than zero")
+
+	v.Check(len(rules.Provinces) <= len(provinces), "provinces", "must not contain more than one rate for every province")
+
+	codes := make([]string, 0, len(rules.Provinces))
+
+	for _, rate := range rules.Provinces {
+		codes = append(codes, rate.Province)
+
+		v.Check(ValidProvince(rate.Province), "provinces", "must only contain provinces of Iran")
+		v.Check(rate.FlatRate >= 0 && rate.PerKgRate >= 0, "provinces", "rates must not be negative")
+	}
+
+	v.Check(validator.Unique(codes), "provinces", "must not contain duplicate provinces")
+}
+
+// ShippingLine is a line of a shipping quote. Subtotal and Weight are of the whole
+// line, the weight is in grams.
+type ShippingLine struct {
+	ProductID int64 `json:"product_id"`
+	VariantID int64 `json:"variant_id"`
+	Quantity  int32 `json:"quantity"`
+	Subtotal  int64 `json:"-"`
+	Weight    int64 `json:"-"`
+}
+
+type ShippingQuote struct {
+	Destination  Destination `json:"destination"`
+	ProvinceName string      `json:"province_name"`
+	Subtotal     int64       `json:"subtotal"`
+	Weight       int64       `json:"weight"`
+	ShippingCost int64       `json:"shipping_cost"`
+	FreeShipping bool        `json:"free_shipping"`
+	Total        int64       `json:"total"`
+}
+
+// QuoteShipping returns what shipping the lines to the destination costs under the
+// rules. Shops without shipping rules ship for free.
+func QuoteShipping(rules *ShippingRules, lines []*ShippingLine, destination Destination) *ShippingQuote {
+	quote := &ShippingQuote{
+		Destination:  destination,
+		ProvinceName: provinces[destination.Province],
+	}
+
+	for _, line := range lines {
+		quote.Subtotal += line.Subtotal
+		quote.Weight += line.Weight
+	}
+
+	switch {
+	case rules == nil:
+		quote.FreeShipping = true
+	case rules.FreeThreshold != nil && quote.Subtotal >= *rules.FreeThreshold:
+		quote.FreeShipping = true
+	default:
+		flatRate, perKgRate := rules.FlatRate, rules.PerKgRate
+
+		for _, rate := range rules.Provinces {
+			if rate.Province == destination.Province {
+				flatRate, perKgRate = rate.FlatRate, rate.PerKgRate
+			}
+		}
+
+		kilograms := (quote.Weight + 999) / 1000
+		quote.ShippingCost = flatRate + kilograms*perKgRate
+	}
+
+	quote.Total = quote.Subtotal + quote.ShippingCost
+
+	return quote
+}
+
+type ShippingModel struct {
+	DB DBTX
+}
+
+// The GetRules() method returns the shipping rules of the shop, with the rates of the
+// provinces ordered by province.
+func (m ShippingModel) GetRules(shopID int64) (*ShippingRules, error) {
+	query := `
+		SELECT shop_id, flat_rate, per_kg_rate, free_threshold, version
+		FROM shipping_rules
+		WHERE shop_id = $1`
+
+	var rules ShippingRules
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, shopID).Scan(
+		&rules.ShopID,
+		&rules.FlatRate,
+		&rules.PerKgRate,
+		&rules.FreeThreshold,
+		&rules.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	query = `
+		SELECT province, flat_rate, per_kg_rate
+		FROM shipping_province_rates
+		WHERE shop_id = $1
+		ORDER BY province`
+
+	rows, err := m.DB.QueryContext(ctx, query, shopID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	rules.Provinces = []*ProvinceRate{}
+
+	for rows.Next() {
+		var rate ProvinceRate
+
+		err := rows.Scan(&rate.Province, &rate.FlatRate, &rate.PerKgRate)
+		if err != nil {
+			return nil, err
+		}
+
+		rules.Provinces = append(rules.Provinces, &rate)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &rules, nil
+}
+
+// The UpsertRules() method sets the shipping rules of the shop, replacing the previous
+// ones and their province rates.
+func (m ShippingModel) UpsertRules(rules *ShippingRules) error {
+	query := `
+		INSERT INTO shipping_rules (shop_id, flat_rate, per_kg_rate, free_threshold)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (shop_id) DO UPDATE
+		SET flat_rate = EXCLUDED.flat_rate, per_kg_rate = EXCLUDED.per_kg_rate,
+			free_threshold = EXCLUDED.free_threshold, version = shipping_rules.version + 1
+		RETURNING version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	args := []interface{}{rules.ShopID, rules.FlatRate, rules.PerKgRate, rules.FreeThreshold}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&rules.Version)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM shipping_province_rates WHERE shop_id = $1`, rules.ShopID)
+	if err != nil {
+		return err
+	}
+
+	for _, rate := range rules.Provinces {
+		query := `
+			INSERT INTO shipping_province_rates (shop_id, province, flat_rate, per_kg_rate)
+			VALUES ($1, $2, $3, $4)`
+
+		_, err = tx.ExecContext(ctx, query, rules.ShopID, rate.Province, rate.FlatRate, rate.PerKgRate)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// The PriceLines() method fills in the subtotal and weight of the lines from the
+// current prices of the products of the shop. It returns ErrRecordNotFound if a
+// product isn't sold by the shop, or a variant doesn't belong to its product.
+func (m ShippingModel) PriceLines(shopID int64, lines []*ShippingLine) error {
+	query := `
+		SELECT COALESCE(product_variants.sale_price, products.sale_price), products.off, products.weight,
+			product_variants.id IS NOT NULL
+		FROM products
+		LEFT JOIN product_variants ON product_variants.id = $3 AND product_variants.product_id = products.id
+		WHERE products.id = $1 AND products.shop_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	for _, line := range lines {
+		var salePrice int64
+		var off, weight int32
+		var variantFound bool
+
+		err := m.DB.QueryRowContext(ctx, query, line.ProductID, shopID, line.VariantID).Scan(
+			&salePrice,
+			&off,
+			&weight,
+			&variantFound,
+		)
+
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+
+		if line.VariantID != 0 && !variantFound {
+			return ErrRecordNotFound
+		}
+
+		line.Subtotal = FinalPrice(salePrice, off) * int64(line.Quantity)
+		line.Weight = int64(weight) * int64(line.Quantity)
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package data
+
+import "testing"
+
+func TestQuoteShipping(t *testing.T) {
+	threshold := int64(2000000)
+
+	rules := &ShippingRules{
+		FlatRate:      30000,
+		PerKgRate:     10000,
+		FreeThreshold: &threshold,
+		Provinces: []*ProvinceRate{
+			{Province: "sistan-baluchestan", FlatRate: 60000, PerKgRate: 20000},
+		},
+	}
+
+	lines := func(subtotal, weight int64) []*ShippingLine {
+		return []*ShippingLine{
+			{Quantity: 1, Subtotal: subtotal / 2, Weight: weight / 2},
+			{Quantity: 1, Subtotal: subtotal - subtotal/2, Weight: weight - weight/2},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		rules    *ShippingRules
+		lines    []*ShippingLine
+		province string
+		cost     int64
+		free     bool
+	}{
+		{"no rules", nil, lines(500000, 2500), "tehran", 0, true},
+		{"flat rate only", rules, lines(500000, 0), "tehran", 30000, false},
+		{"started kilogram", rules, lines(500000, 1001), "tehran", 50000, false},
+		{"whole kilograms", rules, lines(500000, 2000), "tehran", 50000, false},
+		{"province rate", rules, lines(500000, 2500), "sistan-baluchestan", 120000, false},
+		{"below the threshold", rules, lines(1999999, 2500), "tehran", 60000, false},
+		{"free threshold", rules, lines(2000000, 2500), "sistan-baluchestan", 0, true},
+		{"no lines", rules, nil, "tehran", 30000, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			destination := Destination{Province: tt.province, City: "city"}
+
+			quote := QuoteShipping(tt.rules, tt.lines, destination)
+
+			var subtotal, weight int64
+			for _, line := range tt.lines {
+				subtotal += line.Subtotal
+				weight += line.Weight
+			}
+
+			if quote.Subtotal != subtotal || quote.Weight != weight {
+				t.Errorf("got subtotal %d and weight %d; want %d and %d", quote.Subtotal, quote.Weight, subtotal, weight)
+			}
+
+			if quote.ShippingCost != tt.cost || quote.FreeShipping != tt.free {
+				t.Errorf("got cost %d and free shipping %t; want %d and %t", quote.ShippingCost, quote.FreeShipping, tt.cost, tt.free)
+			}
+
+			if quote.Total != subtotal+tt.cost {
+				t.Errorf("got total %d; want %d", quote.Total, subtotal+tt.cost)
+			}
+
+			if quote.ProvinceName != provinces[tt.province] {
+				t.Errorf("got province name %q; want %q", quote.ProvinceName, provinces[tt.province])
+			}
+		})
+	}
+}
@@ -13,8 +13,13 @@ import (
 	"misarfeh.com/internal/validator"
 )
 
+var (
+	ErrShopHasOrders = errors.New("shop has orders")
+)
+
 type Shop struct {
 	ID            int64     `json:"id"`
+	SellerID      int64     `json:"-"`
 	CreatedAt     time.Time `json:"-"`
 	Title         string    `json:"title"`
 	Description   string    `json:"description,omitempty"`
@@ -29,8 +34,10 @@ type Shop struct {
 	RatingCount   int64     `json:"rating_count,omitempty"`
 	Countries     []string  `json:"countries,omitempty"`
 	Categories    []string  `json:"categories,omitempty"`
-	ImgUrls       []string  `json:"img_urls,omitempty"`
+	ImgUrls       []string  `json:"-"`
+	Images        []*Image  `json:"images,omitempty"`
 	DeliveryTime  int8      `json:"delivery_time"`
+	Version       int       `json:"version"`
 }
 
 func (s Shop) MarshalJSON() ([]byte, error) {
@@ -88,7 +95,7 @@ func ValidateShop(v *validator.Validator, shop *Shop) {
 }
 
 type ShopModel struct {
-	DB *sql.DB
+	DB DBTX
 }
 
 func (m ShopModel) GetAll(title string, verified bool, countries []string, filters Filters) ([]*Shop, Metadata, error) {
@@ -164,17 +171,17 @@ func (m ShopModel) GetAll(title string, verified bool, countries []string, filte
 
 func (m ShopModel) Insert(shop *Shop) error {
 	query := `
-		INSERT INTO shops (title, year, description, telegram, instagram, phone, logo_url, delivery_time)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-		RETURNING id, created_at`
+		INSERT INTO shops (seller_id, title, year, description, telegram, instagram, phone, logo_url, delivery_time)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id, created_at, version`
 
-	args := []interface{}{shop.Title, shop.Year, shop.Description, shop.Telegram,
+	args := []interface{}{shop.SellerID, shop.Title, shop.Year, shop.Description, shop.Telegram,
 		shop.Instagram, shop.Phone, shop.LogoUrl, shop.DeliveryTime}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&shop.ID, &shop.CreatedAt)
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&shop.ID, &shop.CreatedAt, &shop.Version)
 }
 
 func (m ShopModel) Get(id int64) (*Shop, error) {
@@ -183,9 +190,9 @@ func (m ShopModel) Get(id int64) (*Shop, error) {
 	}
 
 	query := `
-		SELECT id, created_at, title, year, description, follower_count,
+		SELECT id, COALESCE(seller_id, 0), created_at, title, year, description, follower_count,
 			telegram, instagram, phone, logo_url, rating, rating_count,
-			verified, delivery_time
+			verified, delivery_time, version
 		FROM shops 
 		WHERE id = $1`
 
@@ -196,6 +203,7 @@ func (m ShopModel) Get(id int64) (*Shop, error) {
 
 	err := m.DB.QueryRowContext(ctx, query, id).Scan(
 		&shop.ID,
+		&shop.SellerID,
 		&shop.CreatedAt,
 		&shop.Title,
 		&shop.Year,
@@ -209,6 +217,7 @@ func (m ShopModel) Get(id int64) (*Shop, error) {
 		&shop.RatingCount,
 		&shop.Verified,
 		&shop.DeliveryTime,
+		&shop.Version,
 	)
 
 	if err != nil {
@@ -227,9 +236,9 @@ func (m ShopModel) Update(shop *Shop) error {
 	query := `
 		UPDATE shops
 		SET title = $1, year = $2, description = $3, telegram = $4,
-	    	instagram = $5, phone = $6, logo_url = $7, delivery_time = $8
-		WHERE id = $9
-		RETURNING id`
+	    	instagram = $5, phone = $6, logo_url = $7, delivery_time = $8, version = version + 1
+		WHERE id = $9 AND seller_id = $10 AND version = $11
+		RETURNING version`
 
 	args := []interface{}{
 		shop.Title,
@@ -241,31 +250,81 @@ func (m ShopModel) Update(shop *Shop) error {
 		shop.LogoUrl,
 		shop.DeliveryTime,
 		shop.ID,
+		shop.SellerID,
+		shop.Version,
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&shop.ID)
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&shop.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
 }
 
-func (m ShopModel) Delete(id int64) error {
+func (m ShopModel) UpdateVerified(id int64, verified bool) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
 	query := `
-		DELETE FROM shops
-		WHERE id = $1`
+		UPDATE shops
+		SET verified = $1, version = version + 1
+		WHERE id = $2`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	result, err := m.DB.ExecContext(ctx, query, id)
+	result, err := m.DB.ExecContext(ctx, query, verified, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
 
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// The Delete() method only removes the shop when it is owned by the given seller. It
+// returns ErrShopHasOrders if the shop was ever ordered from, the orders are kept for
+// the buyers and the ledger.
+func (m ShopModel) Delete(id, sellerID int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM shops
+		WHERE id = $1 AND seller_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, sellerID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: update or delete on table "shops" violates foreign key constraint "orders_shop_id_fkey" on table "orders"`:
+			return ErrShopHasOrders
+		default:
+			return err
+		}
+	}
+
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
 		return err
@@ -278,6 +337,53 @@ func (m ShopModel) Delete(id int64) error {
 	return nil
 }
 
+func (m ShopModel) GetAllForSeller(sellerID int64) ([]*Shop, error) {
+	query := `
+		SELECT id, seller_id, created_at, title, year, logo_url, verified, delivery_time
+		FROM shops
+		WHERE seller_id = $1
+		ORDER BY id ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, sellerID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	shops := []*Shop{}
+
+	for rows.Next() {
+		var shop Shop
+
+		err := rows.Scan(
+			&shop.ID,
+			&shop.SellerID,
+			&shop.CreatedAt,
+			&shop.Title,
+			&shop.Year,
+			&shop.LogoUrl,
+			&shop.Verified,
+			&shop.DeliveryTime,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		shops = append(shops, &shop)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return shops, nil
+}
+
 type MockShopModel struct{}
 
 func (m MockShopModel) Insert(shop *Shop) error {
@@ -292,10 +398,18 @@ func (m MockShopModel) Update(shop *Shop) error {
 	return nil
 }
 
-func (m MockShopModel) Delete(id int64) error {
+func (m MockShopModel) Delete(id, sellerID int64) error {
+	return nil
+}
+
+func (m MockShopModel) UpdateVerified(id int64, verified bool) error {
 	return nil
 }
 
+func (m MockShopModel) GetAllForSeller(sellerID int64) ([]*Shop, error) {
+	return nil, nil
+}
+
 func (m MockShopModel) GetAll(title string, verified bool, countries []string, filters Filters) ([]*Shop, Metadata, error) {
 	return nil, Metadata{}, nil
 }
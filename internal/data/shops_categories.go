@@ -2,7 +2,6 @@ package data
 
 import (
 	"context"
-	"database/sql"
 	"errors"
 	"time"
 )
@@ -17,7 +16,7 @@ type ShopCategory struct {
 }
 
 type ShopCategoryModel struct {
-	DB *sql.DB
+	DB DBTX
 }
 
 func (m ShopCategoryModel) Insert(shopCategory *ShopCategory) error {
@@ -2,7 +2,6 @@ package data
 
 import (
 	"context"
-	"database/sql"
 	"errors"
 	"time"
 )
@@ -17,7 +16,7 @@ type ShopCountry struct {
 }
 
 type ShopCountryModel struct {
-	DB *sql.DB
+	DB DBTX
 }
 
 func (m ShopCountryModel) Insert(shopCountry *ShopCountry) error {
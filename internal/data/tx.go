@@ -0,0 +1,72 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+)
+
+// DBTX is implemented by both *sql.DB and *sql.Tx, so every model can run either
+// directly against the connection pool or inside a transaction.
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+type transaction interface {
+	DBTX
+	Commit() error
+	Rollback() error
+}
+
+// nestedTx is used when a model method which needs a transaction is called on a model
+// which already runs inside one. Commit and Rollback are left to the owner of the
+// outer transaction.
+type nestedTx struct {
+	*sql.Tx
+}
+
+func (tx nestedTx) Commit() error {
+	return nil
+}
+
+func (tx nestedTx) Rollback() error {
+	return nil
+}
+
+// The beginTx() helper starts a new transaction on db, or joins the transaction db
+// already is.
+func beginTx(ctx context.Context, db DBTX) (transaction, error) {
+	switch db := db.(type) {
+	case *sql.DB:
+		return db.BeginTx(ctx, nil)
+	case *sql.Tx:
+		return nestedTx{Tx: db}, nil
+	default:
+		panic("unsupported DBTX type")
+	}
+}
+
+// The WithTx() method runs fn with a copy of the models which all share a single
+// transaction. The transaction is committed if fn returns nil and rolled back
+// otherwise. Calling WithTx on models which already run in a transaction just runs fn
+// in that transaction.
+func (m Models) WithTx(ctx context.Context, fn func(tx Models) error) error {
+	if m.db == nil {
+		return fn(m)
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	err = fn(newModels(tx))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,197 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"misarfeh.com/internal/validator"
+)
+
+var (
+	ErrUploadOffsetMismatch = errors.New("upload offset mismatch")
+	ErrUploadTooLong        = errors.New("upload longer than its size")
+)
+
+// Upload is an image uploaded in chunks, so an upload over a poor connection can be
+// resumed from Offset instead of starting over. The received bytes are kept in a
+// temporary file until all Size bytes arrived, then the image is processed and stored
+// like any other upload and its ID is kept in ImageID.
+type Upload struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    int64     `json:"-"`
+	Filename  string    `json:"filename"`
+	Size      int64     `json:"size"`
+	Offset    int64     `json:"offset"`
+	ImageID   *int64    `json:"-"`
+	Image     *Image    `json:"image,omitempty"`
+}
+
+// Complete reports whether every byte of the upload was received.
+func (u *Upload) Complete() bool {
+	return u.Offset == u.Size
+}
+
+func ValidateUpload(v *validator.Validator, upload *Upload, maxSize int64) {
+	v.Check(upload.Filename != "", "filename", "must be provided")
+	v.Check(len(upload.Filename) <= 255, "filename", "must not be more than 255 bytes long")
+
+	v.Check(upload.Size > 0, "size", "must be greater than zero")
+	v.Check(upload.Size <= maxSize, "size", "must not be more than the maximum upload size")
+}
+
+type UploadModel struct {
+	DB DBTX
+}
+
+func (m UploadModel) Insert(upload *Upload) error {
+	query := `
+		INSERT INTO uploads (user_id, filename, size)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at, received`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, upload.UserID, upload.Filename, upload.Size).Scan(
+		&upload.ID, &upload.CreatedAt, &upload.Offset)
+}
+
+// The Get() method returns an upload of the user. With forUpdate the row is locked
+// until the transaction ends, so chunks of the same upload are appended one at a time.
+func (m UploadModel) Get(id, userID int64, forUpdate bool) (*Upload, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, user_id, filename, size, received, image_id
+		FROM uploads
+		WHERE id = $1 AND user_id = $2`
+
+	if forUpdate {
+		query += `
+		FOR UPDATE`
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var upload Upload
+
+	err := m.DB.QueryRowContext(ctx, query, id, userID).Scan(
+		&upload.ID,
+		&upload.CreatedAt,
+		&upload.UserID,
+		&upload.Filename,
+		&upload.Size,
+		&upload.Offset,
+		&upload.ImageID,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &upload, nil
+}
+
+// The Advance() method records that length bytes were received at offset. The offset
+// must be the current offset of the upload, otherwise the chunk was sent twice or a
+// chunk is missing and ErrUploadOffsetMismatch is returned. It must be called in a
+// transaction, on an upload got for update.
+func (m UploadModel) Advance(upload *Upload, offset, length int64) error {
+	if upload.ImageID != nil || offset != upload.Offset {
+		return ErrUploadOffsetMismatch
+	}
+
+	if offset+length > upload.Size {
+		return ErrUploadTooLong
+	}
+
+	query := `
+		UPDATE uploads
+		SET received = $2
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, upload.ID, offset+length)
+	if err != nil {
+		return err
+	}
+
+	upload.Offset = offset + length
+
+	return nil
+}
+
+func (m UploadModel) SetImage(upload *Upload, imageID int64) error {
+	query := `
+		UPDATE uploads
+		SET image_id = $2
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, upload.ID, imageID)
+	if err != nil {
+		return err
+	}
+
+	upload.ImageID = &imageID
+
+	return nil
+}
+
+func (m UploadModel) Delete(id, userID int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM uploads
+		WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// The DeleteExpired() method deletes the uploads started more than ttl ago, finished
+// or not, and returns their IDs so their temporary files can be removed.
+func (m UploadModel) DeleteExpired(ttl time.Duration) ([]int64, error) {
+	query := `
+		DELETE FROM uploads
+		WHERE created_at < NOW() - make_interval(secs => $1)
+		RETURNING id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return queryIDs(ctx, m.DB, query, ttl.Seconds())
+}
@@ -0,0 +1,351 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"misarfeh.com/internal/validator"
+)
+
+var (
+	ErrOutOfStock      = errors.New("out of stock")
+	ErrVariantReserved = errors.New("variant has open reservations")
+)
+
+// ProductOption is one of the ways the variants of a product differ, like size or
+// color, with the values the variants use.
+type ProductOption struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+// ProductVariant is a sellable version of a product, like a shirt in one size and
+// color. SalePrice overrides the sale price of the product when it is set.
+type ProductVariant struct {
+	ID        int64             `json:"id"`
+	ProductID int64             `json:"-"`
+	SKU       string            `json:"sku"`
+	Options   map[string]string `json:"options"`
+	SalePrice *int64            `json:"sale_price,omitempty"`
+	Stock     int32             `json:"stock"`
+}
+
+func (pv ProductVariant) MarshalJSON() ([]byte, error) {
+	type ProductVariantAlias ProductVariant
+
+	aux := struct {
+		ProductVariantAlias
+		SalePriceDisplay string `json:"sale_price_display,omitempty"`
+	}{
+		ProductVariantAlias: ProductVariantAlias(pv),
+	}
+
+	if pv.SalePrice != nil {
+		aux.SalePriceDisplay = Toman(*pv.SalePrice).String()
+	}
+
+	return json.Marshal(aux)
+}
+
+// optionsKey returns the option values of the variant in the order of their names, so
+// variants with the same values have the same key.
+func (pv ProductVariant) optionsKey() string {
+	names := make([]string, 0, len(pv.Options))
+	for name := range pv.Options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	values := make([]string, len(names))
+	for i, name := range names {
+		values[i] = name + "=" + pv.Options[name]
+	}
+
+	return strings.Join(values, "\x00")
+}
+
+// VariantOptions returns the options used by the variants, with their values in the
+// order they first appear.
+func VariantOptions(variants []*ProductVariant) []*ProductOption {
+	options := []*ProductOption{}
+	index := make(map[string]*ProductOption)
+
+	for _, variant := range variants {
+		names := make([]string, 0, len(variant.Options))
+		for name := range variant.Options {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			option, ok := index[name]
+			if !ok {
+				option = &ProductOption{Name: name}
+				index[name] = option
+				options = append(options, option)
+			}
+
+			if !validator.In(variant.Options[name], option.Values...) {
+				option.Values = append(option.Values, variant.Options[name])
+			}
+		}
+	}
+
+	return options
+}
+
+func ValidateVariants(v *validator.Validator, variants []*ProductVariant) {
+	v.Check(len(variants) <= 100, "variants", "must not contain more than 100 variants")
+
+	skus := make([]string, 0, len(variants))
+	keys := make([]string, 0, len(variants))
+
+	for _, variant := range variants {
+		skus = append(skus, variant.SKU)
+		keys = append(keys, variant.optionsKey())
+
+		v.Check(variant.SKU != "", "variants", "sku must be provided")
+		v.Check(len(variant.SKU) <= 100, "variants", "sku must not be more than 100 bytes long")
+
+		v.Check(len(variant.Options) >= 1, "variants", "options must be provided")
+		v.Check(len(variant.Options) <= 3, "variants", "must not have more than 3 options")
+		v.Check(len(variant.Options) == len(variants[0].Options), "variants", "must all have the same options")
+
+		for name, value := range variant.Options {
+			_, ok := variants[0].Options[name]
+			v.Check(ok, "variants", "must all have the same options")
+
+			v.Check(name != "" && value != "", "variants", "option names and values must not be empty")
+			v.Check(len(name) <= 50 && len(value) <= 50, "variants", "option names and values must not be more than 50 bytes long")
+		}
+
+		v.Check(variant.SalePrice == nil || *variant.SalePrice > 0, "variants", "sale_price must be greater than zero")
+
+		v.Check(variant.Stock >= 0, "variants", "stock must not be negative")
+	}
+
+	v.Check(validator.Unique(skus), "variants", "must not contain duplicate skus")
+	v.Check(validator.Unique(keys), "variants", "must not contain duplicate options")
+}
+
+type VariantModel struct {
+	DB DBTX
+}
+
+// The ReplaceForProduct() method makes the variants of the product match the given
+// ones. Variants are matched by sku, so existing variants keep their id and the cart
+// items and orders which refer to them. Variants which are not given are deleted,
+// and the options of the product are rebuilt from the variants.
+//
+// The stock of a variant is what is left after the stock held for orders, which is
+// put back when the orders are cancelled. While a variant has such open reservations
+// its stock can't be changed and it can't be deleted, ErrVariantReserved is returned
+// instead.
+func (m VariantModel) ReplaceForProduct(productID int64, variants []*ProductVariant) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	// The variants are locked so no stock is held for them until the transaction ends.
+	query := `
+		SELECT sku, stock, EXISTS(
+			SELECT 1 FROM stock_reservations
+			JOIN orders ON stock_reservations.order_id = orders.id
+			WHERE stock_reservations.variant_id = product_variants.id
+			AND (stock_reservations.status = 'held'
+				OR (stock_reservations.status = 'committed' AND orders.status = 'pending_payment'))
+		)
+		FROM product_variants
+		WHERE product_id = $1
+		FOR UPDATE OF product_variants`
+
+	rows, err := tx.QueryContext(ctx, query, productID)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	// reserved maps the sku of the variants with open reservations to their stock.
+	reserved := make(map[string]int32)
+
+	for rows.Next() {
+		var sku string
+		var stock int32
+		var isReserved bool
+
+		err := rows.Scan(&sku, &stock, &isReserved)
+		if err != nil {
+			return err
+		}
+
+		if isReserved {
+			reserved[sku] = stock
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	skus := make([]string, 0, len(variants))
+
+	for _, variant := range variants {
+		if stock, ok := reserved[variant.SKU]; ok && variant.Stock != stock {
+			return ErrVariantReserved
+		}
+
+		delete(reserved, variant.SKU)
+	}
+
+	// The reserved variants left are the ones which would be deleted.
+	if len(reserved) > 0 {
+		return ErrVariantReserved
+	}
+
+	for _, variant := range variants {
+		options, err := json.Marshal(variant.Options)
+		if err != nil {
+			return err
+		}
+
+		query = `
+			INSERT INTO product_variants (product_id, sku, options, sale_price, stock)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT ON CONSTRAINT product_variants_product_sku_key DO UPDATE
+			SET options = EXCLUDED.options, sale_price = EXCLUDED.sale_price, stock = EXCLUDED.stock
+			RETURNING id`
+
+		args := []interface{}{productID, variant.SKU, string(options), variant.SalePrice, variant.Stock}
+
+		err = tx.QueryRowContext(ctx, query, args...).Scan(&variant.ID)
+		if err != nil {
+			return err
+		}
+
+		variant.ProductID = productID
+		skus = append(skus, variant.SKU)
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM product_variants WHERE product_id = $1 AND NOT (sku = ANY($2))`, productID, pq.Array(skus))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM product_options WHERE product_id = $1`, productID)
+	if err != nil {
+		return err
+	}
+
+	for i, option := range VariantOptions(variants) {
+		query = `
+			INSERT INTO product_options (product_id, name, option_values, position)
+			VALUES ($1, $2, $3, $4)`
+
+		_, err = tx.ExecContext(ctx, query, productID, option.Name, pq.Array(option.Values), i)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (m VariantModel) GetAllForProduct(productID int64) ([]*ProductVariant, error) {
+	query := `
+		SELECT id, product_id, sku, options, sale_price, stock
+		FROM product_variants
+		WHERE product_id = $1
+		ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	variants := []*ProductVariant{}
+
+	for rows.Next() {
+		var variant ProductVariant
+		var options []byte
+
+		err := rows.Scan(
+			&variant.ID,
+			&variant.ProductID,
+			&variant.SKU,
+			&options,
+			&variant.SalePrice,
+			&variant.Stock,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(options, &variant.Options)
+		if err != nil {
+			return nil, err
+		}
+
+		variants = append(variants, &variant)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return variants, nil
+}
+
+func (m VariantModel) GetOptionsForProduct(productID int64) ([]*ProductOption, error) {
+	query := `
+		SELECT name, option_values
+		FROM product_options
+		WHERE product_id = $1
+		ORDER BY position`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	options := []*ProductOption{}
+
+	for rows.Next() {
+		var option ProductOption
+
+		err := rows.Scan(&option.Name, pq.Array(&option.Values))
+		if err != nil {
+			return nil, err
+		}
+
+		options = append(options, &option)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return options, nil
+}
@@ -0,0 +1,272 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrDuplicateWishlistItem = errors.New("duplicate wishlist item")
+)
+
+const (
+	NotificationPriceDrop   = "price_drop"
+	NotificationBackInStock = "back_in_stock"
+)
+
+// WishlistItem is a product, or one variant of it, saved by a buyer. LastPrice and
+// LastInStock are what the buyer was last told about it, they are compared with the
+// current price and stock to detect price drops and restocks.
+type WishlistItem struct {
+	ID          int64     `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UserID      int64     `json:"-"`
+	ProductID   int64     `json:"product_id"`
+	VariantID   *int64    `json:"variant_id,omitempty"`
+	ProductName string    `json:"product_name"`
+	SKU         string    `json:"sku,omitempty"`
+	Price       int64     `json:"price"`
+	InStock     bool      `json:"in_stock"`
+	LastPrice   int64     `json:"-"`
+	LastInStock bool      `json:"-"`
+}
+
+// wishlistCurrentColumns selects the current final price of a wishlist item and whether
+// it is in stock, from the product and the variant of the item joined as
+// product_variants. A product without variants doesn't track its stock and is always
+// in stock, a product with variants is in stock while any of them is.
+const wishlistCurrentColumns = `
+	COALESCE(product_variants.sale_price, products.sale_price) * (100 - products.off) / 100,
+	CASE WHEN product_variants.id IS NOT NULL THEN product_variants.stock > 0
+		ELSE NOT EXISTS (SELECT 1 FROM product_variants WHERE product_variants.product_id = products.id)
+			OR EXISTS (SELECT 1 FROM product_variants WHERE product_variants.product_id = products.id AND product_variants.stock > 0)
+	END`
+
+type WishlistModel struct {
+	DB DBTX
+}
+
+func (m WishlistModel) GetAllForUser(userID int64) ([]*WishlistItem, error) {
+	query := `
+		SELECT wishlist_items.id, wishlist_items.created_at, wishlist_items.user_id, wishlist_items.product_id,
+			wishlist_items.variant_id, products.name, COALESCE(product_variants.sku, ''),` + wishlistCurrentColumns + `
+		FROM wishlist_items
+		JOIN products ON wishlist_items.product_id = products.id
+		LEFT JOIN product_variants ON wishlist_items.variant_id = product_variants.id
+		WHERE wishlist_items.user_id = $1
+		ORDER BY wishlist_items.id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	items := []*WishlistItem{}
+
+	for rows.Next() {
+		var item WishlistItem
+
+		err := rows.Scan(
+			&item.ID,
+			&item.CreatedAt,
+			&item.UserID,
+			&item.ProductID,
+			&item.VariantID,
+			&item.ProductName,
+			&item.SKU,
+			&item.Price,
+			&item.InStock,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, &item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// The Insert() method saves the product or variant to the wishlist of the user, with
+// its current price and stock. A variantID of 0 saves the product. It returns
+// ErrRecordNotFound if the variant doesn't belong to the product.
+func (m WishlistModel) Insert(userID, productID, variantID int64) (*WishlistItem, error) {
+	query := `
+		INSERT INTO wishlist_items (user_id, product_id, variant_id, last_price, last_in_stock)
+		SELECT $1, products.id, product_variants.id,` + wishlistCurrentColumns + `
+		FROM products
+		LEFT JOIN product_variants ON product_variants.id = $3 AND product_variants.product_id = products.id
+		WHERE products.id = $2 AND ($3 = 0 OR product_variants.id IS NOT NULL)
+		RETURNING id, created_at`
+
+	item := &WishlistItem{UserID: userID, ProductID: productID}
+
+	if variantID != 0 {
+		item.VariantID = &variantID
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID, productID, variantID).Scan(&item.ID, &item.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		case err.Error() == `pq: duplicate key value violates unique constraint "wishlist_items_user_product_variant_key"`:
+			return nil, ErrDuplicateWishlistItem
+		default:
+			return nil, err
+		}
+	}
+
+	return item, nil
+}
+
+func (m WishlistModel) Delete(userID, id int64) error {
+	query := `
+		DELETE FROM wishlist_items
+		WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// wishlistNotification is a notification about a wishlist item, before it is queued
+// for its user.
+type wishlistNotification struct {
+	Kind    string
+	Message string
+}
+
+// wishlistNotifications returns the notifications the change of the item since the
+// buyer was last told about it calls for: one if its price dropped and one if it is
+// back in stock. A price rise or the item running out of stock calls for none.
+func wishlistNotifications(item *WishlistItem) []wishlistNotification {
+	notifications := []wishlistNotification{}
+
+	if item.Price < item.LastPrice {
+		notifications = append(notifications, wishlistNotification{
+			Kind:    NotificationPriceDrop,
+			Message: fmt.Sprintf("قیمت %s در لیست علاقه‌مندی‌های شما به %s کاهش یافت", item.ProductName, Toman(item.Price)),
+		})
+	}
+
+	if item.InStock && !item.LastInStock {
+		notifications = append(notifications, wishlistNotification{
+			Kind:    NotificationBackInStock,
+			Message: fmt.Sprintf("%s در لیست علاقه‌مندی‌های شما دوباره موجود شد", item.ProductName),
+		})
+	}
+
+	return notifications
+}
+
+// The DetectChanges() method compares every wishlist item with its product, queues a
+// notification for every price drop and restock, and remembers the current price and
+// stock for the next run. It returns the number of queued notifications.
+func (m WishlistModel) DetectChanges() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return 0, err
+	}
+
+	defer tx.Rollback()
+
+	query := `
+		SELECT wishlist_items.id, wishlist_items.user_id, wishlist_items.product_id, products.name,
+			wishlist_items.last_price, wishlist_items.last_in_stock,` + wishlistCurrentColumns + `
+		FROM wishlist_items
+		JOIN products ON wishlist_items.product_id = products.id
+		LEFT JOIN product_variants ON wishlist_items.variant_id = product_variants.id
+		FOR UPDATE OF wishlist_items SKIP LOCKED`
+
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	defer rows.Close()
+
+	changed := []*WishlistItem{}
+
+	for rows.Next() {
+		var item WishlistItem
+
+		err := rows.Scan(
+			&item.ID,
+			&item.UserID,
+			&item.ProductID,
+			&item.ProductName,
+			&item.LastPrice,
+			&item.LastInStock,
+			&item.Price,
+			&item.InStock,
+		)
+
+		if err != nil {
+			return 0, err
+		}
+
+		if item.Price != item.LastPrice || item.InStock != item.LastInStock {
+			changed = append(changed, &item)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var count int64
+
+	for _, item := range changed {
+		for _, n := range wishlistNotifications(item) {
+			err = insertNotification(ctx, tx, item.UserID, n.Kind, item.ProductID, n.Message)
+			if err != nil {
+				return 0, err
+			}
+
+			count++
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE wishlist_items SET last_price = $2, last_in_stock = $3 WHERE id = $1`,
+			item.ID, item.Price, item.InStock)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return count, tx.Commit()
+}
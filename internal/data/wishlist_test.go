@@ -0,0 +1,73 @@
+package data
+
+import "testing"
+
+func TestWishlistNotifications(t *testing.T) {
+	tests := []struct {
+		name        string
+		lastPrice   int64
+		price       int64
+		lastInStock bool
+		inStock     bool
+		want        []wishlistNotification
+	}{
+		{
+			name: "unchanged", lastPrice: 100000, price: 100000, lastInStock: true, inStock: true,
+		},
+		{
+			name: "price drop", lastPrice: 100000, price: 85000, lastInStock: true, inStock: true,
+			want: []wishlistNotification{
+				{NotificationPriceDrop, "قیمت کیف در لیست علاقه‌مندی‌های شما به ۸۵٬۰۰۰ تومان کاهش یافت"},
+			},
+		},
+		{
+			name: "price rise", lastPrice: 100000, price: 120000, lastInStock: true, inStock: true,
+		},
+		{
+			name: "restock", lastPrice: 100000, price: 100000, lastInStock: false, inStock: true,
+			want: []wishlistNotification{
+				{NotificationBackInStock, "کیف در لیست علاقه‌مندی‌های شما دوباره موجود شد"},
+			},
+		},
+		{
+			name: "out of stock", lastPrice: 100000, price: 100000, lastInStock: true, inStock: false,
+		},
+		{
+			name: "restock at a lower price", lastPrice: 100000, price: 90000, lastInStock: false, inStock: true,
+			want: []wishlistNotification{
+				{NotificationPriceDrop, "قیمت کیف در لیست علاقه‌مندی‌های شما به ۹۰٬۰۰۰ تومان کاهش یافت"},
+				{NotificationBackInStock, "کیف در لیست علاقه‌مندی‌های شما دوباره موجود شد"},
+			},
+		},
+		{
+			name: "price drop while out of stock", lastPrice: 100000, price: 90000, lastInStock: false, inStock: false,
+			want: []wishlistNotification{
+				{NotificationPriceDrop, "قیمت کیف در لیست علاقه‌مندی‌های شما به ۹۰٬۰۰۰ تومان کاهش یافت"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := &WishlistItem{
+				ProductName: "کیف",
+				Price:       tt.price,
+				InStock:     tt.inStock,
+				LastPrice:   tt.lastPrice,
+				LastInStock: tt.lastInStock,
+			}
+
+			got := wishlistNotifications(item)
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d notifications %v; want %d", len(got), got, len(tt.want))
+			}
+
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("got %+v; want %+v", got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+// exifSegment returns an APP1 segment with EXIF data holding only the orientation tag,
+// in the given byte order.
+func exifSegment(order binary.ByteOrder, orientation uint16) []byte {
+	tiff := make([]byte, 26)
+
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 1)
+	order.PutUint16(tiff[10:], 0x0112)
+	order.PutUint16(tiff[12:], 3)
+	order.PutUint32(tiff[14:], 1)
+	order.PutUint16(tiff[18:], orientation)
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+
+	return append(segment, payload...)
+}
+
+// withSegment inserts the segment right after the start of image marker of a JPEG.
+func withSegment(data, segment []byte) []byte {
+	out := append([]byte{}, data[:2]...)
+	out = append(out, segment...)
+	return append(out, data[2:]...)
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	err := jpeg.Encode(&buf, img, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestExifOrientation(t *testing.T) {
+	plain := encodeJPEG(t, image.NewRGBA(image.Rect(0, 0, 8, 8)))
+
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"no exif", plain, 1},
+		{"little endian", withSegment(plain, exifSegment(binary.LittleEndian, 6)), 6},
+		{"big endian", withSegment(plain, exifSegment(binary.BigEndian, 8)), 8},
+		{"upright", withSegment(plain, exifSegment(binary.LittleEndian, 1)), 1},
+		{"out of range", withSegment(plain, exifSegment(binary.BigEndian, 9)), 1},
+		{"truncated segment", withSegment(plain, exifSegment(binary.BigEndian, 3)[:20]), 1},
+		{"not a jpeg", []byte("\x89PNG\r\n\x1a\n"), 1},
+		{"empty", nil, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exifOrientation(tt.data); got != tt.want {
+				t.Errorf("got orientation %d; want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// The source image is
+	//
+	//	1 2
+	//	3 4
+	//
+	// with the numbers stored in the red channel.
+	src := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	for i, v := range []uint8{1, 2, 3, 4} {
+		src.Pix[i*4] = v
+	}
+
+	tests := []struct {
+		orientation int
+		want        [4]uint8
+	}{
+		{1, [4]uint8{1, 2, 3, 4}},
+		{2, [4]uint8{2, 1, 4, 3}},
+		{3, [4]uint8{4, 3, 2, 1}},
+		{4, [4]uint8{3, 4, 1, 2}},
+		{5, [4]uint8{1, 3, 2, 4}},
+		{6, [4]uint8{3, 1, 4, 2}},
+		{7, [4]uint8{4, 2, 3, 1}},
+		{8, [4]uint8{2, 4, 1, 3}},
+		{0, [4]uint8{1, 2, 3, 4}},
+	}
+
+	for _, tt := range tests {
+		dst := orient(src, tt.orientation)
+
+		var got [4]uint8
+		for i := range got {
+			got[i] = dst.Pix[i*4]
+		}
+
+		if got != tt.want {
+			t.Errorf("orientation %d: got %v; want %v", tt.orientation, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png"
+	"path"
+	"strings"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("imaging: unsupported image format")
+	ErrTooLarge          = errors.New("imaging: image dimensions are too large")
+)
+
+// MaxPixels bounds the dimensions of the images Process decodes, so a small file
+// claiming huge dimensions can't exhaust the memory of the server.
+const MaxPixels = 50_000_000
+
+// Size is a fixed size images are scaled down to, so their longer side is at most
+// MaxSide pixels. Images which are already smaller aren't scaled up.
+type Size struct {
+	Name    string
+	MaxSide int
+}
+
+var Sizes = []Size{
+	{Name: "thumb", MaxSide: 160},
+	{Name: "card", MaxSide: 480},
+	{Name: "full", MaxSide: 1280},
+}
+
+const (
+	FormatWebP = "webp"
+	FormatJPEG = "jpeg"
+)
+
+// Formats are the formats every size is encoded in. Browsers pick WebP when they
+// support it, since it is smaller, and fall back to JPEG. WebP needs cgo, builds
+// without it only encode JPEG. Adding a format means adding it here and to encode.
+var Formats = formats()
+
+func formats() []string {
+	if webpSupported {
+		return []string{FormatWebP, FormatJPEG}
+	}
+
+	return []string{FormatJPEG}
+}
+
+// Variant is an encoded size of an image in one format.
+type Variant struct {
+	Size   string
+	Format string
+	Width  int
+	Height int
+	Data   []byte
+}
+
+// Extension returns the file extension of the format of the variant.
+func (v *Variant) Extension() string {
+	return extensions[v.Format]
+}
+
+func (v *Variant) ContentType() string {
+	return "image/" + v.Format
+}
+
+// VariantKey returns the key of a size of an image stored under base, like
+// "1690000000/thumb.jpg". Uploads were stored this way before they were content
+// addressed.
+func VariantKey(base, size, format string) string {
+	return base + "/" + size + extensions[format]
+}
+
+var extensions = map[string]string{
+	FormatWebP: ".webp",
+	FormatJPEG: ".jpg",
+}
+
+// Process decodes a JPEG or PNG image, applies its EXIF orientation and encodes it in
+// every size and format. The variants are encoded from the decoded pixels, so none of
+// the metadata of the upload, like the EXIF and GPS tags, ends up in them.
+func Process(data []byte) ([]*Variant, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedFormat
+	}
+
+	if format != "jpeg" && format != "png" {
+		return nil, ErrUnsupportedFormat
+	}
+
+	if config.Width*config.Height > MaxPixels {
+		return nil, ErrTooLarge
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	img := flatten(src)
+
+	if format == "jpeg" {
+		img = orient(img, exifOrientation(data))
+	}
+
+	variants := []*Variant{}
+
+	for _, size := range Sizes {
+		scaled := resize(img, size.MaxSide)
+
+		for _, format := range Formats {
+			var buf bytes.Buffer
+
+			err := encode(&buf, scaled, format)
+			if err != nil {
+				return nil, err
+			}
+
+			variants = append(variants, &Variant{
+				Size:   size.Name,
+				Format: format,
+				Width:  scaled.Bounds().Dx(),
+				Height: scaled.Bounds().Dy(),
+				Data:   buf.Bytes(),
+			})
+		}
+	}
+
+	return variants, nil
+}
+
+func encode(buf *bytes.Buffer, img image.Image, format string) error {
+	switch format {
+	case FormatWebP:
+		return encodeWebP(buf, img)
+	case FormatJPEG:
+		return jpeg.Encode(buf, img, &jpeg.Options{Quality: 85})
+	default:
+		return ErrUnsupportedFormat
+	}
+}
+
+// Srcset returns the URLs of every size of an image uploaded before uploads were content
+// addressed, whose full JPEG is served at fullURL, keyed by size and then format. These
+// uploads were only stored as JPEG. Other URLs, like external images, only have a full
+// size.
+func Srcset(fullURL string) map[string]map[string]string {
+	fullName := "/" + path.Base(VariantKey("", "full", FormatJPEG))
+
+	if !strings.HasSuffix(fullURL, fullName) {
+		format := strings.TrimPrefix(strings.ToLower(path.Ext(fullURL)), ".")
+		if format == "jpg" {
+			format = FormatJPEG
+		}
+
+		return map[string]map[string]string{"full": {format: fullURL}}
+	}
+
+	base := strings.TrimSuffix(fullURL, fullName)
+	srcset := make(map[string]map[string]string, len(Sizes))
+
+	for _, size := range Sizes {
+		srcset[size.Name] = map[string]string{
+			FormatJPEG: VariantKey(base, size.Name, FormatJPEG),
+		}
+	}
+
+	return srcset
+}
+
+// flatten draws the image over a white background, since JPEG has no transparency.
+func flatten(src image.Image) *image.RGBA {
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Over)
+
+	return dst
+}
+
+// resize scales the image down with a box filter, averaging the source pixels every
+// destination pixel covers, so its longer side is at most maxSide.
+func resize(src *image.RGBA, maxSide int) *image.RGBA {
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+
+	if w <= maxSide && h <= maxSide {
+		return src
+	}
+
+	dw, dh := maxSide, maxSide
+
+	if w >= h {
+		dh = max(1, (h*maxSide+w/2)/w)
+	} else {
+		dw = max(1, (w*maxSide+h/2)/h)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < dh; y++ {
+		sy0, sy1 := y*h/dh, max((y+1)*h/dh, y*h/dh+1)
+
+		for x := 0; x < dw; x++ {
+			sx0, sx1 := x*w/dw, max((x+1)*w/dw, x*w/dw+1)
+
+			var r, g, b, a, n int
+
+			for sy := sy0; sy < sy1; sy++ {
+				i := src.PixOffset(sx0, sy)
+
+				for sx := sx0; sx < sx1; sx++ {
+					r += int(src.Pix[i])
+					g += int(src.Pix[i+1])
+					b += int(src.Pix[i+2])
+					a += int(src.Pix[i+3])
+					n++
+					i += 4
+				}
+			}
+
+			j := dst.PixOffset(x, y)
+			dst.Pix[j] = uint8(r / n)
+			dst.Pix[j+1] = uint8(g / n)
+			dst.Pix[j+2] = uint8(b / n)
+			dst.Pix[j+3] = uint8(a / n)
+		}
+	}
+
+	return dst
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+
+	return b
+}
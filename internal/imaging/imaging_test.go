@@ -0,0 +1,191 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestProcess(t *testing.T) {
+	landscape := image.NewRGBA(image.Rect(0, 0, 2000, 1000))
+
+	var pngData bytes.Buffer
+
+	err := png.Encode(&pngData, image.NewNRGBA(image.Rect(0, 0, 100, 300)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		data  []byte
+		sizes map[string][2]int
+	}{
+		{
+			name:  "jpeg",
+			data:  encodeJPEG(t, landscape),
+			sizes: map[string][2]int{"thumb": {160, 80}, "card": {480, 240}, "full": {1280, 640}},
+		},
+		{
+			name:  "rotated jpeg",
+			data:  withSegment(encodeJPEG(t, landscape), exifSegment(binary.BigEndian, 6)),
+			sizes: map[string][2]int{"thumb": {80, 160}, "card": {240, 480}, "full": {640, 1280}},
+		},
+		{
+			name:  "small png",
+			data:  pngData.Bytes(),
+			sizes: map[string][2]int{"thumb": {53, 160}, "card": {100, 300}, "full": {100, 300}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			variants, err := Process(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(variants) != len(Sizes)*len(Formats) {
+				t.Fatalf("got %d variants; want %d", len(variants), len(Sizes)*len(Formats))
+			}
+
+			for _, variant := range variants {
+				want := tt.sizes[variant.Size]
+
+				if variant.Width != want[0] || variant.Height != want[1] {
+					t.Errorf("%s %s: got %dx%d; want %dx%d", variant.Size, variant.Format,
+						variant.Width, variant.Height, want[0], want[1])
+				}
+
+				var config image.Config
+
+				switch variant.Format {
+				case FormatWebP:
+					// The WebP decoder is registered by the encoder package.
+					config, _, err = image.DecodeConfig(bytes.NewReader(variant.Data))
+				case FormatJPEG:
+					config, err = jpeg.DecodeConfig(bytes.NewReader(variant.Data))
+
+					if bytes.Contains(variant.Data, []byte("Exif\x00\x00")) {
+						t.Errorf("%s %s: kept the EXIF data", variant.Size, variant.Format)
+					}
+				default:
+					t.Fatalf("got unexpected format %q", variant.Format)
+				}
+
+				if err != nil {
+					t.Fatalf("%s %s: %v", variant.Size, variant.Format, err)
+				}
+
+				if config.Width != want[0] || config.Height != want[1] {
+					t.Errorf("%s %s: decoded %dx%d; want %dx%d", variant.Size, variant.Format,
+						config.Width, config.Height, want[0], want[1])
+				}
+			}
+		})
+	}
+}
+
+func TestProcessErrors(t *testing.T) {
+	var gif bytes.Buffer
+	gif.WriteString("GIF89a")
+
+	// A PNG header claiming dimensions far above MaxPixels, with no pixels at all.
+	var huge bytes.Buffer
+
+	err := png.Encode(&huge, image.NewGray(image.Rect(0, 0, 1, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := huge.Bytes()
+	binary.BigEndian.PutUint32(header[16:], 100000)
+	binary.BigEndian.PutUint32(header[20:], 100000)
+	binary.BigEndian.PutUint32(header[29:], crc32.ChecksumIEEE(header[12:29]))
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"empty", nil, ErrUnsupportedFormat},
+		{"text", []byte("not an image"), ErrUnsupportedFormat},
+		{"gif", gif.Bytes(), ErrUnsupportedFormat},
+		{"too large", header, ErrTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Process(tt.data)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got error %v; want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	src.Set(0, 0, color.NRGBA{A: 0})
+
+	got := flatten(src).RGBAAt(0, 0)
+	if got != (color.RGBA{255, 255, 255, 255}) {
+		t.Errorf("got %v for a transparent pixel; want white", got)
+	}
+}
+
+func TestSrcset(t *testing.T) {
+	tests := []struct {
+		name    string
+		fullURL string
+		want    map[string]map[string]string
+	}{
+		{
+			name:    "legacy upload",
+			fullURL: "https://cdn.example.com/images/1690000000/full.jpg",
+			want: map[string]map[string]string{
+				"thumb": {FormatJPEG: "https://cdn.example.com/images/1690000000/thumb.jpg"},
+				"card":  {FormatJPEG: "https://cdn.example.com/images/1690000000/card.jpg"},
+				"full":  {FormatJPEG: "https://cdn.example.com/images/1690000000/full.jpg"},
+			},
+		},
+		{
+			name:    "external jpeg",
+			fullURL: "https://example.com/photo.JPG",
+			want:    map[string]map[string]string{"full": {FormatJPEG: "https://example.com/photo.JPG"}},
+		},
+		{
+			name:    "external png",
+			fullURL: "https://example.com/photo.png",
+			want:    map[string]map[string]string{"full": {"png": "https://example.com/photo.png"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Srcset(tt.fullURL)
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d sizes; want %d", len(got), len(tt.want))
+			}
+
+			for size, formats := range tt.want {
+				for format, url := range formats {
+					if got[size][format] != url {
+						t.Errorf("got %s %s %q; want %q", size, format, got[size][format], url)
+					}
+				}
+
+				if len(got[size]) != len(formats) {
+					t.Errorf("got %d formats for %s; want %d", len(got[size]), size, len(formats))
+				}
+			}
+		})
+	}
+}
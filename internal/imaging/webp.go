@@ -0,0 +1,18 @@
+//go:build cgo
+
+package imaging
+
+import (
+	"bytes"
+	"image"
+
+	"github.com/chai2010/webp"
+)
+
+// WebP is encoded with libwebp, which is linked through cgo. Builds without cgo, like
+// static builds with CGO_ENABLED=0, only encode JPEG.
+const webpSupported = true
+
+func encodeWebP(buf *bytes.Buffer, img image.Image) error {
+	return webp.Encode(buf, img, &webp.Options{Quality: 80})
+}
@@ -0,0 +1,14 @@
+//go:build !cgo
+
+package imaging
+
+import (
+	"bytes"
+	"image"
+)
+
+const webpSupported = false
+
+func encodeWebP(buf *bytes.Buffer, img image.Image) error {
+	return ErrUnsupportedFormat
+}
@@ -0,0 +1,72 @@
+package notify
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"misarfeh.com/internal/sms"
+)
+
+// Notification is a message for a user about one of their products, like a price drop
+// of a product on their wishlist.
+type Notification struct {
+	ID        int64  `json:"id"`
+	UserID    int64  `json:"user_id"`
+	Phone     string `json:"phone"`
+	Kind      string `json:"kind"`
+	ProductID int64  `json:"product_id,omitempty"`
+	Message   string `json:"message"`
+}
+
+// Notifier is implemented by anything which can deliver a notification to its user.
+type Notifier interface {
+	Notify(n *Notification) error
+}
+
+// LogNotifier doesn't deliver anything, it writes every notification as a JSON line to
+// the provided io.Writer. It is meant for development and tests, where the writer is
+// usually os.Stdout or a file.
+type LogNotifier struct {
+	out io.Writer
+	mu  sync.Mutex
+}
+
+func NewLogNotifier(out io.Writer) *LogNotifier {
+	return &LogNotifier{out: out}
+}
+
+func (l *LogNotifier) Notify(n *Notification) error {
+	aux := struct {
+		Time string `json:"time"`
+		*Notification
+	}{
+		Time:         time.Now().UTC().Format(time.RFC3339),
+		Notification: n,
+	}
+
+	line, err := json.Marshal(aux)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, err = l.out.Write(append(line, '\n'))
+	return err
+}
+
+// SMSNotifier delivers notifications as text messages to the phone of the user.
+type SMSNotifier struct {
+	sender sms.Sender
+}
+
+func NewSMSNotifier(sender sms.Sender) *SMSNotifier {
+	return &SMSNotifier{sender: sender}
+}
+
+func (s *SMSNotifier) Notify(n *Notification) error {
+	return s.sender.Send(n.Phone, n.Message)
+}
@@ -0,0 +1,111 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogNotifier(t *testing.T) {
+	tests := []struct {
+		name         string
+		notification *Notification
+		want         map[string]interface{}
+	}{
+		{
+			name: "price drop",
+			notification: &Notification{
+				ID: 1, UserID: 7, Phone: "+989121234567", Kind: "price_drop", ProductID: 42,
+				Message: "قیمت کیف در لیست علاقه‌مندی‌های شما به ۸۵٬۰۰۰ تومان کاهش یافت",
+			},
+			want: map[string]interface{}{
+				"id": float64(1), "user_id": float64(7), "phone": "+989121234567", "kind": "price_drop",
+				"product_id": float64(42), "message": "قیمت کیف در لیست علاقه‌مندی‌های شما به ۸۵٬۰۰۰ تومان کاهش یافت",
+			},
+		},
+		{
+			name:         "without product",
+			notification: &Notification{ID: 2, UserID: 7, Phone: "+989121234567", Kind: "notice", Message: "hello"},
+			want: map[string]interface{}{
+				"id": float64(2), "user_id": float64(7), "phone": "+989121234567", "kind": "notice", "message": "hello",
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+
+	notifier := NewLogNotifier(&buf)
+
+	for _, tt := range tests {
+		err := notifier.Notify(tt.notification)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != len(tests) {
+		t.Fatalf("got %d lines; want %d", len(lines), len(tests))
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got map[string]interface{}
+
+			err := json.Unmarshal([]byte(lines[i]), &got)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := time.Parse(time.RFC3339, got["time"].(string)); err != nil {
+				t.Errorf("got time %v: %v", got["time"], err)
+			}
+
+			delete(got, "time")
+
+			if len(got) != len(tt.want) {
+				t.Errorf("got fields %v; want %v", got, tt.want)
+			}
+
+			for key, value := range tt.want {
+				if got[key] != value {
+					t.Errorf("got %s %#v; want %#v", key, got[key], value)
+				}
+			}
+		})
+	}
+}
+
+// recordingSender keeps the messages it is asked to send.
+type recordingSender struct {
+	sent []string
+	err  error
+}
+
+func (s *recordingSender) Send(phone, message string) error {
+	s.sent = append(s.sent, phone+": "+message)
+	return s.err
+}
+
+func TestSMSNotifier(t *testing.T) {
+	sender := &recordingSender{}
+
+	err := NewSMSNotifier(sender).Notify(&Notification{Phone: "+989121234567", Message: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sender.sent) != 1 || sender.sent[0] != "+989121234567: hello" {
+		t.Errorf("got sent messages %q", sender.sent)
+	}
+
+	sender.err = errors.New("provider down")
+
+	err = NewSMSNotifier(sender).Notify(&Notification{Phone: "+989121234567", Message: "hello"})
+	if !errors.Is(err, sender.err) {
+		t.Errorf("got error %v; want %v", err, sender.err)
+	}
+}
@@ -0,0 +1,134 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+const (
+	fakePending = iota
+	fakePaid
+	fakeFailed
+)
+
+type fakePayment struct {
+	amount      int64
+	callbackURL string
+	status      int
+	refID       string
+}
+
+// FakeGateway is an in-process gateway for development and tests. Payments are
+// completed either by calling Pay directly, or by opening the redirect URL, which is
+// served by the FakeGateway itself and pays the payment unless the query string has
+// status=NOK.
+type FakeGateway struct {
+	BaseURL string
+
+	mu       sync.Mutex
+	next     int
+	nextRef  int
+	payments map[string]*fakePayment
+}
+
+// NewFakeGateway returns a fake gateway whose redirect URLs point to baseURL, where
+// the gateway is expected to be mounted at /v1/payments/fake.
+func NewFakeGateway(baseURL string) *FakeGateway {
+	return &FakeGateway{
+		BaseURL:  baseURL,
+		payments: make(map[string]*fakePayment),
+	}
+}
+
+func (g *FakeGateway) RequestPayment(ctx context.Context, amount int64, description, callbackURL string) (string, string, error) {
+	if amount <= 0 {
+		return "", "", errors.New("payment: amount must be greater than zero")
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.next++
+	authority := fmt.Sprintf("FAKE%016d", g.next)
+
+	g.payments[authority] = &fakePayment{
+		amount:      amount,
+		callbackURL: callbackURL,
+		status:      fakePending,
+	}
+
+	return authority, g.BaseURL + "/v1/payments/fake?authority=" + url.QueryEscape(authority), nil
+}
+
+// Pay completes the payment as the buyer would on the gateway page, and returns the
+// callback URL the buyer would be redirected to. A payment can only be completed once.
+func (g *FakeGateway) Pay(authority string, ok bool) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	p, exists := g.payments[authority]
+	if !exists {
+		return "", fmt.Errorf("payment: unknown authority %q", authority)
+	}
+
+	if p.status == fakePending {
+		if ok {
+			p.status = fakePaid
+			g.nextRef++
+			p.refID = fmt.Sprintf("%d", 100000+g.nextRef)
+		} else {
+			p.status = fakeFailed
+		}
+	}
+
+	status := "NOK"
+	if p.status == fakePaid {
+		status = "OK"
+	}
+
+	callback, err := url.Parse(p.callbackURL)
+	if err != nil {
+		return "", err
+	}
+
+	qs := callback.Query()
+	qs.Set("Authority", authority)
+	qs.Set("Status", status)
+	callback.RawQuery = qs.Encode()
+
+	return callback.String(), nil
+}
+
+// ServeHTTP plays the gateway page: it completes the payment named by the authority
+// query string parameter and redirects to the callback URL.
+func (g *FakeGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	callbackURL, err := g.Pay(qs.Get("authority"), qs.Get("status") != "NOK")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	http.Redirect(w, r, callbackURL, http.StatusSeeOther)
+}
+
+func (g *FakeGateway) ParseCallback(r *http.Request) (*Callback, error) {
+	return parseCallback(r)
+}
+
+func (g *FakeGateway) Verify(ctx context.Context, authority string, amount int64) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	p, exists := g.payments[authority]
+	if !exists || p.status != fakePaid || p.amount != amount {
+		return "", ErrNotVerified
+	}
+
+	return p.refID, nil
+}
@@ -0,0 +1,183 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var (
+	ErrInvalidCallback = errors.New("payment: invalid callback")
+	ErrNotVerified     = errors.New("payment: not verified")
+)
+
+// Gateway is implemented by payment gateways which follow the request, redirect,
+// callback and verify flow used by the Iranian gateways:
+//
+//  1. RequestPayment registers the payment with the gateway and returns the authority
+//     which identifies it, and the URL the buyer is redirected to.
+//  2. After paying, the buyer is redirected back to the callback URL, which is parsed
+//     by ParseCallback.
+//  3. Verify confirms the payment with the gateway and returns its reference id. The
+//     money is only settled once the payment is verified, and verifying a payment
+//     twice returns the same reference id.
+//
+// Amounts are in toman, the currency orders are priced in.
+type Gateway interface {
+	RequestPayment(ctx context.Context, amount int64, description, callbackURL string) (authority, redirectURL string, err error)
+	ParseCallback(r *http.Request) (*Callback, error)
+	Verify(ctx context.Context, authority string, amount int64) (refID string, err error)
+}
+
+// Callback holds the query string parameters a gateway redirects the buyer back with.
+// OK is false if the buyer cancelled or the payment failed.
+type Callback struct {
+	Authority string
+	OK        bool
+}
+
+// parseCallback reads the Authority and Status query string parameters, which is the
+// format both Zarinpal and the fake gateway use.
+func parseCallback(r *http.Request) (*Callback, error) {
+	qs := r.URL.Query()
+
+	authority := qs.Get("Authority")
+	if authority == "" {
+		return nil, ErrInvalidCallback
+	}
+
+	return &Callback{Authority: authority, OK: qs.Get("Status") == "OK"}, nil
+}
+
+// ZarinpalGateway talks to the Zarinpal v4 REST API. Zarinpal takes amounts in rials,
+// the amounts in toman it is given are converted with rials.
+type ZarinpalGateway struct {
+	MerchantID string
+	Sandbox    bool
+	Client     *http.Client
+}
+
+func NewZarinpalGateway(merchantID string, sandbox bool) *ZarinpalGateway {
+	return &ZarinpalGateway{
+		MerchantID: merchantID,
+		Sandbox:    sandbox,
+		Client:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// rials converts an amount in toman to rials, ten rials make a toman.
+func rials(toman int64) int64 {
+	return toman * 10
+}
+
+func (g *ZarinpalGateway) host() string {
+	if g.Sandbox {
+		return "https://sandbox.zarinpal.com"
+	}
+	return "https://payment.zarinpal.com"
+}
+
+// The post() helper posts body to the endpoint and decodes the data field of the
+// response into dst. Zarinpal returns its errors in the errors field, with an empty
+// data array.
+func (g *ZarinpalGateway) post(ctx context.Context, endpoint string, body, dst interface{}) error {
+	js, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.host()+"/pg/v4/payment/"+endpoint, bytes.NewReader(js))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := g.Client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	var aux struct {
+		Data   json.RawMessage `json:"data"`
+		Errors json.RawMessage `json:"errors"`
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&aux)
+	if err != nil {
+		return fmt.Errorf("payment: zarinpal responded with %s", resp.Status)
+	}
+
+	var zerr struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+
+	if json.Unmarshal(aux.Errors, &zerr) == nil && zerr.Code != 0 {
+		return fmt.Errorf("payment: zarinpal error %d: %s", zerr.Code, zerr.Message)
+	}
+
+	return json.Unmarshal(aux.Data, dst)
+}
+
+func (g *ZarinpalGateway) RequestPayment(ctx context.Context, amount int64, description, callbackURL string) (string, string, error) {
+	body := map[string]interface{}{
+		"merchant_id":  g.MerchantID,
+		"amount":       rials(amount),
+		"description":  description,
+		"callback_url": callbackURL,
+	}
+
+	var data struct {
+		Code      int    `json:"code"`
+		Authority string `json:"authority"`
+	}
+
+	err := g.post(ctx, "request.json", body, &data)
+	if err != nil {
+		return "", "", err
+	}
+
+	if data.Code != 100 || data.Authority == "" {
+		return "", "", fmt.Errorf("payment: zarinpal request failed with code %d", data.Code)
+	}
+
+	return data.Authority, g.host() + "/pg/StartPay/" + data.Authority, nil
+}
+
+func (g *ZarinpalGateway) ParseCallback(r *http.Request) (*Callback, error) {
+	return parseCallback(r)
+}
+
+// Verify treats code 101, which Zarinpal returns for a payment which was already
+// verified, as a success.
+func (g *ZarinpalGateway) Verify(ctx context.Context, authority string, amount int64) (string, error) {
+	body := map[string]interface{}{
+		"merchant_id": g.MerchantID,
+		"amount":      rials(amount),
+		"authority":   authority,
+	}
+
+	var data struct {
+		Code  int         `json:"code"`
+		RefID json.Number `json:"ref_id"`
+	}
+
+	err := g.post(ctx, "verify.json", body, &data)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrNotVerified, err)
+	}
+
+	if data.Code != 100 && data.Code != 101 {
+		return "", ErrNotVerified
+	}
+
+	return data.RefID.String(), nil
+}
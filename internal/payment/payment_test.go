@@ -0,0 +1,290 @@
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseCallback(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		authority string
+		ok        bool
+		err       error
+	}{
+		{"paid", "Authority=A00001&Status=OK", "A00001", true, nil},
+		{"failed", "Authority=A00001&Status=NOK", "A00001", false, nil},
+		{"no status", "Authority=A00001", "A00001", false, nil},
+		{"no authority", "Status=OK", "", false, ErrInvalidCallback},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/v1/payments/callback?"+tt.query, nil)
+
+			callback, err := parseCallback(r)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v; want %v", err, tt.err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if callback.Authority != tt.authority || callback.OK != tt.ok {
+				t.Errorf("got %+v; want authority %q and ok %t", callback, tt.authority, tt.ok)
+			}
+		})
+	}
+}
+
+// The fakePay() helper requests a payment of amount and completes it, returning the
+// authority and the callback the buyer is redirected to.
+func fakePay(t *testing.T, g *FakeGateway, amount int64, ok bool) (string, *Callback) {
+	t.Helper()
+
+	authority, _, err := g.RequestPayment(context.Background(), amount, "order", "http://localhost/v1/payments/callback?order=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	callbackURL, err := g.Pay(authority, ok)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	callback, err := g.ParseCallback(httptest.NewRequest(http.MethodGet, callbackURL, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return authority, callback
+}
+
+func TestFakeGateway(t *testing.T) {
+	tests := []struct {
+		name         string
+		amount       int64
+		ok           bool
+		verifyAmount int64
+		wantOK       bool
+		wantErr      error
+	}{
+		{"paid", 250000, true, 250000, true, nil},
+		{"cancelled", 250000, false, 250000, false, ErrNotVerified},
+		{"wrong amount", 250000, true, 25000, true, ErrNotVerified},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewFakeGateway("http://localhost:4000")
+
+			authority, callback := fakePay(t, g, tt.amount, tt.ok)
+
+			if callback.Authority != authority {
+				t.Errorf("got callback authority %q; want %q", callback.Authority, authority)
+			}
+
+			if callback.OK != tt.wantOK {
+				t.Errorf("got callback ok %t; want %t", callback.OK, tt.wantOK)
+			}
+
+			refID, err := g.Verify(context.Background(), authority, tt.verifyAmount)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v; want %v", err, tt.wantErr)
+			}
+
+			if err == nil && refID == "" {
+				t.Error("got an empty reference id")
+			}
+		})
+	}
+}
+
+func TestFakeGatewayVerifyTwice(t *testing.T) {
+	g := NewFakeGateway("http://localhost:4000")
+
+	authority, _ := fakePay(t, g, 120000, true)
+
+	first, err := g.Verify(context.Background(), authority, 120000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Paying again must neither fail the payment nor change its reference id.
+	_, err = g.Pay(authority, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := g.Verify(context.Background(), authority, 120000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first != second {
+		t.Errorf("got reference ids %q and %q; want the same one", first, second)
+	}
+}
+
+func TestFakeGatewayRequestPayment(t *testing.T) {
+	g := NewFakeGateway("http://localhost:4000")
+
+	_, _, err := g.RequestPayment(context.Background(), 0, "order", "http://localhost/v1/payments/callback")
+	if err == nil {
+		t.Error("got no error for a zero amount")
+	}
+
+	_, err = g.Pay("FAKE0000000000000042", true)
+	if err == nil {
+		t.Error("got no error for an unknown authority")
+	}
+
+	_, err = g.Verify(context.Background(), "FAKE0000000000000042", 1000)
+	if !errors.Is(err, ErrNotVerified) {
+		t.Errorf("got error %v; want %v", err, ErrNotVerified)
+	}
+}
+
+func TestFakeGatewayServeHTTP(t *testing.T) {
+	tests := []struct {
+		name   string
+		status string
+		wantOK bool
+	}{
+		{"paid", "", true},
+		{"cancelled", "NOK", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewFakeGateway("http://localhost:4000")
+
+			authority, redirectURL, err := g.RequestPayment(context.Background(), 5000, "order", "http://localhost/v1/payments/callback")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.status != "" {
+				redirectURL += "&status=" + tt.status
+			}
+
+			rr := httptest.NewRecorder()
+			g.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, redirectURL, nil))
+
+			if rr.Code != http.StatusSeeOther {
+				t.Fatalf("got status %d; want %d", rr.Code, http.StatusSeeOther)
+			}
+
+			location, err := url.Parse(rr.Header().Get("Location"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			callback, err := g.ParseCallback(httptest.NewRequest(http.MethodGet, location.String(), nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if callback.Authority != authority || callback.OK != tt.wantOK {
+				t.Errorf("got %+v; want authority %q and ok %t", callback, authority, tt.wantOK)
+			}
+		})
+	}
+
+	rr := httptest.NewRecorder()
+	NewFakeGateway("").ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/payments/fake?authority=unknown", nil))
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("got status %d for an unknown authority; want %d", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestRials(t *testing.T) {
+	tests := []struct {
+		toman int64
+		want  int64
+	}{
+		{0, 0},
+		{1, 10},
+		{12500000, 125000000},
+	}
+
+	for _, tt := range tests {
+		if got := rials(tt.toman); got != tt.want {
+			t.Errorf("rials(%d) = %d; want %d", tt.toman, got, tt.want)
+		}
+	}
+}
+
+// roundTripFunc lets a test answer the requests of an http.Client.
+type roundTripFunc func(r *http.Request) *http.Response
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r), nil
+}
+
+func TestZarinpalGateway(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint string
+		response string
+		wantErr  error
+		wantRef  string
+	}{
+		{"verified", "verify.json", `{"data":{"code":100,"ref_id":201},"errors":[]}`, nil, "201"},
+		{"verified before", "verify.json", `{"data":{"code":101,"ref_id":201},"errors":[]}`, nil, "201"},
+		{"not paid", "verify.json", `{"data":[],"errors":{"code":-51,"message":"Session is not valid"}}`, ErrNotVerified, ""},
+		{"request", "request.json", `{"data":{"code":100,"authority":"A00001"},"errors":[]}`, nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body map[string]interface{}
+
+			g := NewZarinpalGateway("merchant", true)
+			g.Client.Transport = roundTripFunc(func(r *http.Request) *http.Response {
+				if !strings.HasSuffix(r.URL.Path, "/pg/v4/payment/"+tt.endpoint) {
+					t.Errorf("got request to %s; want %s", r.URL.Path, tt.endpoint)
+				}
+
+				err := json.NewDecoder(r.Body).Decode(&body)
+				if err != nil {
+					t.Error(err)
+				}
+
+				rr := httptest.NewRecorder()
+				rr.WriteString(tt.response)
+				return rr.Result()
+			})
+
+			var err error
+			var refID string
+
+			if tt.endpoint == "request.json" {
+				_, _, err = g.RequestPayment(context.Background(), 25000, "order", "http://localhost/v1/payments/callback")
+			} else {
+				refID, err = g.Verify(context.Background(), "A00001", 25000)
+			}
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v; want %v", err, tt.wantErr)
+			}
+
+			if refID != tt.wantRef {
+				t.Errorf("got reference id %q; want %q", refID, tt.wantRef)
+			}
+
+			if body["amount"] != float64(250000) {
+				t.Errorf("got amount %v; want 250000 rials", body["amount"])
+			}
+		})
+	}
+}
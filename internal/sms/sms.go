@@ -0,0 +1,106 @@
+package sms
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Sender is implemented by anything which can deliver a text message to a phone
+// number.
+type Sender interface {
+	Send(phone, message string) error
+}
+
+// LogSender doesn't deliver anything, it writes every message as a JSON line to the
+// provided io.Writer. It is meant for development, where the writer is usually
+// os.Stdout or a file.
+type LogSender struct {
+	out io.Writer
+	mu  sync.Mutex
+}
+
+func NewLogSender(out io.Writer) *LogSender {
+	return &LogSender{out: out}
+}
+
+func (s *LogSender) Send(phone, message string) error {
+	aux := struct {
+		Time    string `json:"time"`
+		Phone   string `json:"phone"`
+		Message string `json:"message"`
+	}{
+		Time:    time.Now().UTC().Format(time.RFC3339),
+		Phone:   phone,
+		Message: message,
+	}
+
+	line, err := json.Marshal(aux)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.out.Write(append(line, '\n'))
+	return err
+}
+
+// HTTPSender sends messages through an SMS provider's HTTP API. The message is posted
+// as a JSON body to URL, and the API key is sent in the Authorization header. Any
+// non-2xx response is treated as a failure.
+type HTTPSender struct {
+	URL    string
+	APIKey string
+	From   string
+	Client *http.Client
+}
+
+func NewHTTPSender(url, apiKey, from string) *HTTPSender {
+	return &HTTPSender{
+		URL:    url,
+		APIKey: apiKey,
+		From:   from,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *HTTPSender) Send(phone, message string) error {
+	body, err := json.Marshal(map[string]string{
+		"from": s.From,
+		"to":   phone,
+		"text": message,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+s.APIKey)
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		// Read a bounded part of the body so the provider's error message ends up in
+		// our logs.
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("sms: provider responded with %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
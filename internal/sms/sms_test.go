@@ -0,0 +1,144 @@
+package sms
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeProvider is a local SMS provider which accepts messages sent with apiKey, and
+// answers every accepted message with status.
+type fakeProvider struct {
+	apiKey   string
+	status   int
+	body     string
+	received []map[string]string
+}
+
+func (p *fakeProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if r.Header.Get("Authorization") != "Bearer "+p.apiKey {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":"invalid api key"}`))
+		return
+	}
+
+	var message map[string]string
+
+	err := json.NewDecoder(r.Body).Decode(&message)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	p.received = append(p.received, message)
+
+	w.WriteHeader(p.status)
+	w.Write([]byte(p.body))
+}
+
+func TestHTTPSender(t *testing.T) {
+	tests := []struct {
+		name    string
+		apiKey  string
+		status  int
+		body    string
+		wantErr string
+	}{
+		{name: "sent", apiKey: "secret", status: http.StatusOK},
+		{name: "accepted", apiKey: "secret", status: http.StatusAccepted},
+		{name: "wrong api key", apiKey: "guessed", status: http.StatusOK, wantErr: "401 Unauthorized: {\"error\":\"invalid api key\"}"},
+		{name: "rejected", apiKey: "secret", status: http.StatusUnprocessableEntity, body: "invalid number\n", wantErr: "422 Unprocessable Entity: invalid number"},
+		{name: "provider down", apiKey: "secret", status: http.StatusServiceUnavailable, body: strings.Repeat("x", 1000), wantErr: "503 Service Unavailable: " + strings.Repeat("x", 512)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &fakeProvider{apiKey: "secret", status: tt.status, body: tt.body}
+
+			server := httptest.NewServer(provider)
+			defer server.Close()
+
+			sender := NewHTTPSender(server.URL, tt.apiKey, "3000123")
+
+			err := sender.Send("+989121234567", "کد تایید شما: 123456")
+
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+			} else if err == nil || !strings.HasSuffix(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v; want one ending in %q", err, tt.wantErr)
+			}
+
+			if tt.apiKey != provider.apiKey {
+				if len(provider.received) != 0 {
+					t.Errorf("got %d messages with a wrong api key; want none", len(provider.received))
+				}
+				return
+			}
+
+			if len(provider.received) != 1 {
+				t.Fatalf("got %d messages; want 1", len(provider.received))
+			}
+
+			want := map[string]string{"from": "3000123", "to": "+989121234567", "text": "کد تایید شما: 123456"}
+
+			for key, value := range want {
+				if provider.received[0][key] != value {
+					t.Errorf("got %s %q; want %q", key, provider.received[0][key], value)
+				}
+			}
+		})
+	}
+}
+
+func TestHTTPSenderUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	err := NewHTTPSender(server.URL, "secret", "3000123").Send("+989121234567", "hello")
+	if err == nil {
+		t.Error("got no error for an unreachable provider")
+	}
+}
+
+func TestLogSender(t *testing.T) {
+	var buf bytes.Buffer
+
+	sender := NewLogSender(&buf)
+
+	for _, phone := range []string{"+989121234567", "+989351234567"} {
+		err := sender.Send(phone, "کد تایید شما: 123456")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines; want 2", len(lines))
+	}
+
+	var message struct {
+		Time    string `json:"time"`
+		Phone   string `json:"phone"`
+		Message string `json:"message"`
+	}
+
+	err := json.Unmarshal([]byte(lines[1]), &message)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if message.Phone != "+989351234567" || message.Message != "کد تایید شما: 123456" || message.Time == "" {
+		t.Errorf("got %+v", message)
+	}
+}
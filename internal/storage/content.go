@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"path"
+	"strings"
+)
+
+var (
+	ErrCorrupted = errors.New("storage: object content doesn't match its hash")
+)
+
+// Hash returns the hex encoded SHA-256 hash of data.
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// ContentKey returns the key data is stored under when it is addressed by its
+// content, like "ab/ab12...ef.jpg". The same data always gets the same key, so it is
+// only stored once. Keys are spread over directories by the first byte of the hash.
+func ContentKey(data []byte, ext string) string {
+	hash := Hash(data)
+	return hash[:2] + "/" + hash + ext
+}
+
+// ContentHash returns the hash a content addressed key was derived from. It returns
+// false for keys which aren't content addressed.
+func ContentHash(key string) (string, bool) {
+	dir, name := path.Split(key)
+
+	hash := strings.TrimSuffix(name, path.Ext(name))
+	if len(hash) != sha256.Size*2 || dir != hash[:2]+"/" {
+		return "", false
+	}
+
+	if _, err := hex.DecodeString(hash); err != nil {
+		return "", false
+	}
+
+	return hash, true
+}
+
+// Verify checks that the object stored under key exists and, when key is content
+// addressed, that its content still hashes to the key. It returns ErrNotFound or
+// ErrCorrupted otherwise.
+func Verify(ctx context.Context, store Store, key string) error {
+	object, err := store.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	defer object.Body.Close()
+
+	hash, ok := ContentHash(key)
+	if !ok {
+		return nil
+	}
+
+	h := sha256.New()
+
+	_, err = io.Copy(h, object.Body)
+	if err != nil {
+		return err
+	}
+
+	if hex.EncodeToString(h.Sum(nil)) != hash {
+		return ErrCorrupted
+	}
+
+	return nil
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	store := NewLocalStore(t.TempDir(), "")
+	ctx := context.Background()
+
+	data := []byte("image data")
+	key := ContentKey(data, ".jpg")
+
+	if hash, ok := ContentHash(key); !ok || hash != Hash(data) {
+		t.Fatalf("ContentHash(%q) = %q, %t; want %q", key, hash, ok, Hash(data))
+	}
+
+	tests := []struct {
+		name    string
+		key     string
+		content string
+		want    error
+	}{
+		{"intact", key, string(data), nil},
+		{"corrupted", key, "other data", ErrCorrupted},
+		{"not content addressed", "legacy/full.jpg", "anything", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := store.Put(ctx, tt.key, strings.NewReader(tt.content), int64(len(tt.content)), "image/jpeg")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := Verify(ctx, store, tt.key); !errors.Is(err, tt.want) {
+				t.Errorf("got error %v; want %v", err, tt.want)
+			}
+		})
+	}
+
+	if err := Verify(ctx, store, "missing/full.jpg"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got error %v for a missing object; want %v", err, ErrNotFound)
+	}
+}
@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type fakeObject struct {
+	data        []byte
+	contentType string
+	modTime     time.Time
+}
+
+// FakeS3 is an in-memory, MinIO-style S3 server for development and tests. It serves
+// PUT, GET and DELETE of objects with path-style addressing, creates buckets on first
+// use, and checks the Signature Version 4 signature of every request against its
+// AccessKey and SecretKey. Mount it with httptest.NewServer and point an S3Store at
+// the server URL.
+type FakeS3 struct {
+	AccessKey string
+	SecretKey string
+
+	mu      sync.Mutex
+	objects map[string]*fakeObject
+}
+
+func NewFakeS3(accessKey, secretKey string) *FakeS3 {
+	return &FakeS3{
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		objects:   make(map[string]*fakeObject),
+	}
+}
+
+func (f *FakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if code, ok := f.authenticate(r); !ok {
+		fakeS3Error(w, http.StatusForbidden, code)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	if !strings.Contains(name, "/") {
+		fakeS3Error(w, http.StatusBadRequest, "InvalidRequest")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			fakeS3Error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+
+		f.mu.Lock()
+		f.objects[name] = &fakeObject{
+			data:        data,
+			contentType: r.Header.Get("Content-Type"),
+			modTime:     time.Now().UTC(),
+		}
+		f.mu.Unlock()
+
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet:
+		f.mu.Lock()
+		object, ok := f.objects[name]
+		f.mu.Unlock()
+
+		if !ok {
+			fakeS3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+
+		w.Header().Set("Content-Type", object.contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(object.data)))
+		w.Header().Set("Last-Modified", object.modTime.Format(http.TimeFormat))
+		w.WriteHeader(http.StatusOK)
+		w.Write(object.data)
+	case http.MethodDelete:
+		f.mu.Lock()
+		delete(f.objects, name)
+		f.mu.Unlock()
+
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		fakeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+// authenticate checks the Authorization header of the request, and returns the S3
+// error code when it isn't valid.
+func (f *FakeS3) authenticate(r *http.Request) (string, bool) {
+	auth := strings.TrimPrefix(r.Header.Get("Authorization"), sigV4Algorithm+" ")
+	if auth == r.Header.Get("Authorization") {
+		return "AccessDenied", false
+	}
+
+	fields := map[string]string{}
+
+	for _, field := range strings.Split(auth, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(field), "=")
+		fields[name] = value
+	}
+
+	// The credential is AccessKey/date/region/s3/aws4_request.
+	credential := strings.Split(fields["Credential"], "/")
+	if len(credential) != 5 {
+		return "AuthorizationHeaderMalformed", false
+	}
+
+	if credential[0] != f.AccessKey {
+		return "InvalidAccessKeyId", false
+	}
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	if len(amzDate) != len(amzDateFormat) || amzDate[:8] != credential[1] {
+		return "AuthorizationHeaderMalformed", false
+	}
+
+	signature := computeSignature(r, r.Host, strings.Split(fields["SignedHeaders"], ";"), f.SecretKey, credential[2], amzDate)
+
+	if !hmac.Equal([]byte(signature), []byte(fields["Signature"])) {
+		return "SignatureDoesNotMatch", false
+	}
+
+	return "", true
+}
+
+func fakeS3Error(w http.ResponseWriter, status int, code string) {
+	body, _ := xml.Marshal(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}{
+		Code:    code,
+		Message: fmt.Sprintf("fake s3: %s", code),
+	})
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write(body)
+}
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"image.jpg", true},
+		{"ab/ab12.jpg", true},
+		{"2023/07/01/card.webp", true},
+		{"..data/a.jpg", true},
+		{"", false},
+		{"/etc/passwd", false},
+		{"../secret", false},
+		{"a/../../secret", false},
+		{"a/./b.jpg", false},
+		{"a//b.jpg", false},
+		{"a/b/", false},
+		{".", false},
+		{"..", false},
+		{"a\\..\\b.jpg", false},
+		{strings.Repeat("a", 513), false},
+	}
+
+	for _, tt := range tests {
+		if got := ValidKey(tt.key); got != tt.want {
+			t.Errorf("ValidKey(%q) = %t; want %t", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestLocalStore(t *testing.T) {
+	root := t.TempDir()
+	store := NewLocalStore(filepath.Join(root, "uploads"), "http://localhost:4000/uploads/")
+	ctx := context.Background()
+
+	err := store.Put(ctx, "ab/ab12.jpg", strings.NewReader("jpeg data"), 9, "image/jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	object, err := store.Get(ctx, "ab/ab12.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := io.ReadAll(object.Body)
+	object.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(got) != "jpeg data" || object.Size != 9 || object.ContentType != "image/jpeg" {
+		t.Errorf("got %q of size %d and type %q", got, object.Size, object.ContentType)
+	}
+
+	if url := store.URL("ab/ab12.jpg"); url != "http://localhost:4000/uploads/ab/ab12.jpg" {
+		t.Errorf("got URL %q", url)
+	}
+
+	// A directory isn't an object.
+	_, err = store.Get(ctx, "ab")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("got error %v for a directory; want %v", err, ErrNotFound)
+	}
+
+	err = store.Delete(ctx, "ab/ab12.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = store.Get(ctx, "ab/ab12.jpg")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("got error %v after deleting; want %v", err, ErrNotFound)
+	}
+
+	err = store.Delete(ctx, "ab/ab12.jpg")
+	if err != nil {
+		t.Errorf("got error %v deleting a missing object", err)
+	}
+}
+
+// Keys which would escape the root are refused before the disk is touched.
+func TestLocalStoreEscape(t *testing.T) {
+	root := t.TempDir()
+	store := NewLocalStore(filepath.Join(root, "uploads"), "")
+	ctx := context.Background()
+
+	err := os.WriteFile(filepath.Join(root, "secret"), []byte("secret"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"../secret", "a/../../secret", "/secret", filepath.Join(root, "secret")} {
+		err := store.Put(ctx, key, strings.NewReader("overwritten"), 11, "text/plain")
+		if !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Put(%q): got error %v; want %v", key, err, ErrInvalidKey)
+		}
+
+		_, err = store.Get(ctx, key)
+		if !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Get(%q): got error %v; want %v", key, err, ErrInvalidKey)
+		}
+
+		err = store.Delete(ctx, key)
+		if !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Delete(%q): got error %v; want %v", key, err, ErrInvalidKey)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(root, "secret"))
+	if err != nil || string(data) != "secret" {
+		t.Errorf("got %q, %v; want the file outside the root untouched", data, err)
+	}
+}
@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestS3Store(t *testing.T, accessKey, secretKey string) (*S3Store, *FakeS3) {
+	t.Helper()
+
+	fake := NewFakeS3("minio", "minio-secret")
+
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	return NewS3Store(server.URL, "uploads", "us-east-1", accessKey, secretKey, ""), fake
+}
+
+func TestS3Store(t *testing.T) {
+	tests := []struct {
+		name        string
+		key         string
+		data        string
+		contentType string
+	}{
+		{"image", "ab/ab12.jpg", "\xff\xd8\xff\xe0jpeg data", "image/jpeg"},
+		{"nested key", "2023/07/01/card.webp", "RIFF webp data", "image/webp"},
+		{"escaped key", "shops/1/my photo+1.jpg", "jpeg data", "image/jpeg"},
+		{"empty object", "empty.txt", "", "text/plain"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, _ := newTestS3Store(t, "minio", "minio-secret")
+			ctx := context.Background()
+
+			err := store.Put(ctx, tt.key, strings.NewReader(tt.data), int64(len(tt.data)), tt.contentType)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			object, err := store.Get(ctx, tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := io.ReadAll(object.Body)
+			object.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(got) != tt.data {
+				t.Errorf("got content %q; want %q", got, tt.data)
+			}
+
+			if object.ContentType != tt.contentType {
+				t.Errorf("got content type %q; want %q", object.ContentType, tt.contentType)
+			}
+
+			if object.Size != int64(len(tt.data)) {
+				t.Errorf("got size %d; want %d", object.Size, len(tt.data))
+			}
+
+			if time.Since(object.ModTime) > time.Minute {
+				t.Errorf("got modification time %v; want about now", object.ModTime)
+			}
+
+			err = store.Delete(ctx, tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = store.Get(ctx, tt.key)
+			if !errors.Is(err, ErrNotFound) {
+				t.Errorf("got error %v after deleting; want %v", err, ErrNotFound)
+			}
+
+			// Deleting a missing object isn't an error.
+			err = store.Delete(ctx, tt.key)
+			if err != nil {
+				t.Errorf("got error %v deleting a missing object", err)
+			}
+		})
+	}
+}
+
+func TestS3StoreOverwrite(t *testing.T) {
+	store, _ := newTestS3Store(t, "minio", "minio-secret")
+	ctx := context.Background()
+
+	for _, data := range []string{"first", "second"} {
+		err := store.Put(ctx, "a/b.txt", strings.NewReader(data), int64(len(data)), "text/plain")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	object, err := store.Get(ctx, "a/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer object.Body.Close()
+
+	got, _ := io.ReadAll(object.Body)
+	if string(got) != "second" {
+		t.Errorf("got content %q; want %q", got, "second")
+	}
+}
+
+func TestS3StoreCredentials(t *testing.T) {
+	tests := []struct {
+		name      string
+		accessKey string
+		secretKey string
+		code      string
+	}{
+		{"wrong access key", "someone", "minio-secret", "InvalidAccessKeyId"},
+		{"wrong secret key", "minio", "guessed", "SignatureDoesNotMatch"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, fake := newTestS3Store(t, tt.accessKey, tt.secretKey)
+			ctx := context.Background()
+
+			err := store.Put(ctx, "a/b.txt", strings.NewReader("data"), 4, "text/plain")
+			if err == nil || !strings.Contains(err.Error(), tt.code) {
+				t.Errorf("got error %v from Put; want %s", err, tt.code)
+			}
+
+			if len(fake.objects) != 0 {
+				t.Errorf("got %d stored objects; want none", len(fake.objects))
+			}
+
+			_, err = store.Get(ctx, "a/b.txt")
+			if err == nil || errors.Is(err, ErrNotFound) || !strings.Contains(err.Error(), tt.code) {
+				t.Errorf("got error %v from Get; want %s", err, tt.code)
+			}
+
+			err = store.Delete(ctx, "a/b.txt")
+			if err == nil || !strings.Contains(err.Error(), tt.code) {
+				t.Errorf("got error %v from Delete; want %s", err, tt.code)
+			}
+		})
+	}
+}
+
+func TestFakeS3Unsigned(t *testing.T) {
+	server := httptest.NewServer(NewFakeS3("minio", "minio-secret"))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/uploads/a/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("got status %d; want %d", resp.StatusCode, http.StatusForbidden)
+	}
+}
+
+func TestS3StoreInvalidKey(t *testing.T) {
+	store, _ := newTestS3Store(t, "minio", "minio-secret")
+	ctx := context.Background()
+
+	for _, key := range []string{"", "/a.jpg", "../a.jpg", "a/../../b.jpg"} {
+		err := store.Put(ctx, key, strings.NewReader("data"), 4, "text/plain")
+		if !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Put(%q): got error %v; want %v", key, err, ErrInvalidKey)
+		}
+
+		_, err = store.Get(ctx, key)
+		if !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Get(%q): got error %v; want %v", key, err, ErrInvalidKey)
+		}
+	}
+}
+
+func TestS3StoreURL(t *testing.T) {
+	tests := []struct {
+		name      string
+		endpoint  string
+		publicURL string
+		key       string
+		want      string
+	}{
+		{"endpoint", "http://localhost:9000/", "", "ab/ab12.jpg", "http://localhost:9000/uploads/ab/ab12.jpg"},
+		{"public url", "http://localhost:9000", "https://cdn.example.com/", "ab/ab12.jpg", "https://cdn.example.com/ab/ab12.jpg"},
+		{"escaped", "http://localhost:9000", "https://cdn.example.com", "a/my photo.jpg", "https://cdn.example.com/a/my%20photo.jpg"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewS3Store(tt.endpoint, "uploads", "us-east-1", "minio", "minio-secret", tt.publicURL)
+
+			if got := store.URL(tt.key); got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"path"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound   = errors.New("storage: object not found")
+	ErrInvalidKey = errors.New("storage: invalid key")
+)
+
+// Store is implemented by anything which can keep uploaded files. Files are addressed
+// by keys, which are slash separated relative paths like "2023/image.jpg".
+type Store interface {
+	// Put stores size bytes read from r under key, replacing any existing object.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get returns the object stored under key, or ErrNotFound. The caller must close
+	// the body of the object.
+	Get(ctx context.Context, key string) (*Object, error)
+	// Delete removes the object stored under key. Deleting a missing object isn't an
+	// error.
+	Delete(ctx context.Context, key string) error
+	// URL returns the public URL the object stored under key is served at.
+	URL(key string) string
+}
+
+// Object is a stored file. Body is an io.ReadSeeker when the store supports it, so
+// range requests can be served from it.
+type Object struct {
+	Body        io.ReadCloser
+	ContentType string
+	Size        int64
+	ModTime     time.Time
+}
+
+// ValidKey reports whether key is a clean relative path which can't escape the root
+// of a store.
+func ValidKey(key string) bool {
+	if key == "" || len(key) > 512 || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+
+	if path.Clean(key) != key {
+		return false
+	}
+
+	for _, part := range strings.Split(key, "/") {
+		if part == "." || part == ".." {
+			return false
+		}
+	}
+
+	return true
+}
+
+// contentType guesses the content type of key from its extension.
+func contentType(key string) string {
+	if t := mime.TypeByExtension(path.Ext(key)); t != "" {
+		return t
+	}
+
+	return "application/octet-stream"
+}
+
+// joinURL joins the base URL and the key with a single slash.
+func joinURL(baseURL, key string) string {
+	return strings.TrimRight(baseURL, "/") + "/" + key
+}
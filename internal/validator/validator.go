@@ -6,6 +6,7 @@ var (
 	EmailRX    = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 	PhoneRX    = regexp.MustCompile(`^09\d{9}$`)
 	MeliCodeRX = regexp.MustCompile("^[0-9]+$")
+	CodeRX     = regexp.MustCompile(`^\d{6}$`)
 )
 
 // Define a new Validator type which contains a map of validation errors.
@@ -53,9 +54,9 @@ func Matches(value string, rx *regexp.Regexp) bool {
 	return rx.MatchString(value)
 }
 
-// Unique returns true if all string values in a slice are unique.
-func Unique(values []string) bool {
-	uniqueValues := make(map[string]bool)
+// Unique returns true if all values in a slice are unique.
+func Unique[T comparable](values []T) bool {
+	uniqueValues := make(map[T]bool)
 	for _, value := range values {
 		uniqueValues[value] = true
 	}
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS shops_seller_id_idx;
+
+ALTER TABLE shops DROP COLUMN IF EXISTS seller_id;
\ No newline at end of file
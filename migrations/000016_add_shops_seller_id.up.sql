@@ -0,0 +1,3 @@
+ALTER TABLE shops ADD COLUMN IF NOT EXISTS seller_id bigint REFERENCES sellers(id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS shops_seller_id_idx ON shops (seller_id);
\ No newline at end of file
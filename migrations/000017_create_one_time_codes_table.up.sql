@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS one_time_codes (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    scope text NOT NULL,
+    hash bytea NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    expiry timestamp(0) with time zone NOT NULL,
+    attempts integer NOT NULL DEFAULT 0,
+    CONSTRAINT one_time_codes_pk PRIMARY KEY(user_id, scope)
+);
\ No newline at end of file
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS permissions (
+    id bigserial PRIMARY KEY,
+    code text NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS users_permissions (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    CONSTRAINT users_permissions_pk PRIMARY KEY (user_id, permission_id)
+);
+
+INSERT INTO permissions (code)
+VALUES
+    ('categories:write'),
+    ('shops:verify'),
+    ('comments:moderate'),
+    ('users:manage')
+ON CONFLICT DO NOTHING;
\ No newline at end of file
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS comments (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    product_id bigint NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    text text NOT NULL,
+    rate smallint NOT NULL,
+    version integer NOT NULL DEFAULT 1,
+    CONSTRAINT comments_product_user_key UNIQUE (product_id, user_id),
+    CONSTRAINT comments_rate_check CHECK (rate BETWEEN 1 AND 5)
+);
+
+CREATE INDEX IF NOT EXISTS comments_product_id_idx ON comments (product_id);
\ No newline at end of file
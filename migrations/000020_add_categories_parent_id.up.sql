@@ -0,0 +1,7 @@
+ALTER TABLE categories ADD COLUMN IF NOT EXISTS parent_id integer REFERENCES categories(id) ON DELETE RESTRICT;
+
+ALTER TABLE categories ADD CONSTRAINT categories_parent_id_check CHECK (parent_id <> id);
+
+CREATE INDEX IF NOT EXISTS categories_parent_id_idx ON categories (parent_id);
+
+CREATE INDEX IF NOT EXISTS products_category_id_idx ON products (category_id);
\ No newline at end of file
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS products_sale_price_idx;
+
+DROP INDEX IF EXISTS products_shop_id_idx;
+
+DROP INDEX IF EXISTS products_search_idx;
\ No newline at end of file
@@ -0,0 +1,5 @@
+CREATE INDEX IF NOT EXISTS products_search_idx ON products USING gin (to_tsvector('simple', name || ' ' || description));
+
+CREATE INDEX IF NOT EXISTS products_shop_id_idx ON products (shop_id);
+
+CREATE INDEX IF NOT EXISTS products_sale_price_idx ON products (sale_price);
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE products DROP COLUMN IF EXISTS version;
+
+ALTER TABLE shops DROP COLUMN IF EXISTS version;
\ No newline at end of file
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS carts (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL UNIQUE REFERENCES users(id) ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS cart_items (
+    id bigserial PRIMARY KEY,
+    cart_id bigint NOT NULL REFERENCES carts(id) ON DELETE CASCADE,
+    product_id bigint NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    quantity integer NOT NULL,
+    sale_price integer NOT NULL,
+    off integer NOT NULL DEFAULT 0,
+    CONSTRAINT cart_items_cart_product_key UNIQUE (cart_id, product_id),
+    CONSTRAINT cart_items_quantity_check CHECK (quantity BETWEEN 1 AND 100)
+);
\ No newline at end of file
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS order_events;
+
+DROP TABLE IF EXISTS order_items;
+
+DROP TABLE IF EXISTS orders;
\ No newline at end of file
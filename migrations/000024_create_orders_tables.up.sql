@@ -0,0 +1,34 @@
+CREATE TABLE IF NOT EXISTS orders (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id bigint NOT NULL REFERENCES users(id) ON DELETE RESTRICT,
+    shop_id bigint NOT NULL REFERENCES shops(id) ON DELETE RESTRICT,
+    status text NOT NULL DEFAULT 'pending_payment',
+    total bigint NOT NULL,
+    estimated_arrival timestamp(0) with time zone NOT NULL,
+    version integer NOT NULL DEFAULT 1,
+    CONSTRAINT orders_status_check CHECK (status IN ('pending_payment', 'paid', 'preparing', 'shipped_abroad', 'in_customs', 'delivered', 'cancelled'))
+);
+
+CREATE INDEX IF NOT EXISTS orders_user_id_idx ON orders (user_id);
+CREATE INDEX IF NOT EXISTS orders_shop_id_idx ON orders (shop_id);
+
+CREATE TABLE IF NOT EXISTS order_items (
+    id bigserial PRIMARY KEY,
+    order_id bigint NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    product_id bigint REFERENCES products(id) ON DELETE SET NULL,
+    product_name text NOT NULL,
+    quantity integer NOT NULL,
+    sale_price integer NOT NULL,
+    off integer NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS order_events (
+    id bigserial PRIMARY KEY,
+    order_id bigint NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    from_status text,
+    to_status text NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS order_events_order_id_idx ON order_events (order_id);
\ No newline at end of file
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS orders_pending_payment_idx;
+
+DROP TABLE IF EXISTS payments;
\ No newline at end of file
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS payments (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    order_id bigint NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    amount bigint NOT NULL,
+    authority text NOT NULL,
+    ref_id text,
+    status text NOT NULL DEFAULT 'pending',
+    version integer NOT NULL DEFAULT 1,
+    CONSTRAINT payments_authority_key UNIQUE (authority),
+    CONSTRAINT payments_status_check CHECK (status IN ('pending', 'paid', 'failed'))
+);
+
+CREATE INDEX IF NOT EXISTS payments_order_id_idx ON payments (order_id);
+
+CREATE INDEX IF NOT EXISTS orders_pending_payment_idx ON orders (created_at) WHERE status = 'pending_payment';
\ No newline at end of file
@@ -0,0 +1,11 @@
+DELETE FROM permissions WHERE code = 'payouts:manage';
+
+DROP TABLE IF EXISTS ledger_entries;
+
+DROP FUNCTION IF EXISTS ledger_entries_balanced();
+
+DROP FUNCTION IF EXISTS ledger_entries_immutable();
+
+DROP SEQUENCE IF EXISTS ledger_transaction_id_seq;
+
+DROP TABLE IF EXISTS payouts;
\ No newline at end of file
@@ -0,0 +1,63 @@
+CREATE TABLE IF NOT EXISTS payouts (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    seller_id bigint NOT NULL REFERENCES sellers(id) ON DELETE RESTRICT,
+    amount bigint NOT NULL,
+    status text NOT NULL DEFAULT 'pending',
+    paid_at timestamp(0) with time zone,
+    CONSTRAINT payouts_amount_check CHECK (amount > 0),
+    CONSTRAINT payouts_status_check CHECK (status IN ('pending', 'paid'))
+);
+
+CREATE SEQUENCE IF NOT EXISTS ledger_transaction_id_seq;
+
+CREATE TABLE IF NOT EXISTS ledger_entries (
+    id bigserial PRIMARY KEY,
+    transaction_id bigint NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    account text NOT NULL,
+    seller_id bigint REFERENCES sellers(id) ON DELETE RESTRICT,
+    order_id bigint REFERENCES orders(id) ON DELETE RESTRICT,
+    payout_id bigint REFERENCES payouts(id) ON DELETE RESTRICT,
+    amount bigint NOT NULL,
+    description text NOT NULL DEFAULT '',
+    CONSTRAINT ledger_entries_account_check CHECK (account IN ('escrow', 'commission', 'seller', 'payouts', 'bank')),
+    CONSTRAINT ledger_entries_seller_check CHECK ((account = 'seller') = (seller_id IS NOT NULL))
+);
+
+CREATE INDEX IF NOT EXISTS ledger_entries_transaction_id_idx ON ledger_entries (transaction_id);
+CREATE INDEX IF NOT EXISTS ledger_entries_seller_id_idx ON ledger_entries (seller_id);
+
+-- An order is only ever credited once.
+CREATE UNIQUE INDEX IF NOT EXISTS ledger_entries_order_account_idx ON ledger_entries (order_id, account) WHERE order_id IS NOT NULL;
+
+-- Entries are never changed or deleted, mistakes are corrected with new entries.
+CREATE OR REPLACE FUNCTION ledger_entries_immutable() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'ledger entries are immutable';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER ledger_entries_immutable
+    BEFORE UPDATE OR DELETE ON ledger_entries
+    FOR EACH ROW EXECUTE PROCEDURE ledger_entries_immutable();
+
+-- The entries of every transaction must add up to zero. The check is deferred to the
+-- end of the database transaction, so all entries can be inserted first.
+CREATE OR REPLACE FUNCTION ledger_entries_balanced() RETURNS trigger AS $$
+BEGIN
+    IF (SELECT SUM(amount) FROM ledger_entries WHERE transaction_id = NEW.transaction_id) <> 0 THEN
+        RAISE EXCEPTION 'ledger transaction % does not add up to zero', NEW.transaction_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE CONSTRAINT TRIGGER ledger_entries_balanced
+    AFTER INSERT ON ledger_entries
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE PROCEDURE ledger_entries_balanced();
+
+INSERT INTO permissions (code)
+VALUES ('payouts:manage')
+ON CONFLICT DO NOTHING;
\ No newline at end of file
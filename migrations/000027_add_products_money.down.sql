@@ -0,0 +1,14 @@
+DROP TABLE IF EXISTS exchange_rates;
+
+ALTER TABLE products DROP CONSTRAINT IF EXISTS products_price_check;
+
+ALTER TABLE products ALTER COLUMN sale_price TYPE integer;
+
+ALTER TABLE products ADD COLUMN IF NOT EXISTS price real;
+
+UPDATE products SET price = price_amount / 100.0 WHERE price_amount > 0;
+
+ALTER TABLE products ADD CONSTRAINT products_price_check CHECK (price > 0);
+
+ALTER TABLE products DROP COLUMN IF EXISTS price_currency;
+ALTER TABLE products DROP COLUMN IF EXISTS price_amount;
\ No newline at end of file
@@ -0,0 +1,20 @@
+ALTER TABLE products ADD COLUMN IF NOT EXISTS price_amount bigint;
+ALTER TABLE products ADD COLUMN IF NOT EXISTS price_currency text;
+
+-- Prices were stored as a real without a currency, they are assumed to be US dollars.
+UPDATE products SET price_amount = ROUND(price * 100), price_currency = 'USD' WHERE price IS NOT NULL;
+
+ALTER TABLE products DROP COLUMN IF EXISTS price;
+
+ALTER TABLE products ALTER COLUMN sale_price TYPE bigint;
+
+ALTER TABLE products ADD CONSTRAINT products_price_check CHECK ((price_amount IS NULL) = (price_currency IS NULL) AND price_amount >= 0);
+
+CREATE TABLE IF NOT EXISTS exchange_rates (
+    seller_id bigint NOT NULL REFERENCES sellers(id) ON DELETE CASCADE,
+    currency text NOT NULL,
+    rate bigint NOT NULL,
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    CONSTRAINT exchange_rates_pk PRIMARY KEY (seller_id, currency),
+    CONSTRAINT exchange_rates_rate_check CHECK (rate > 0)
+);
\ No newline at end of file
@@ -0,0 +1,11 @@
+ALTER TABLE order_items DROP COLUMN IF EXISTS sku;
+ALTER TABLE order_items DROP COLUMN IF EXISTS variant_id;
+
+DROP INDEX IF EXISTS cart_items_cart_product_variant_key;
+DELETE FROM cart_items WHERE variant_id IS NOT NULL;
+ALTER TABLE cart_items DROP COLUMN IF EXISTS variant_id;
+ALTER TABLE cart_items ADD CONSTRAINT cart_items_cart_product_key UNIQUE (cart_id, product_id);
+
+DROP TABLE IF EXISTS product_variants;
+
+DROP TABLE IF EXISTS product_options;
\ No newline at end of file
@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS product_options (
+    id bigserial PRIMARY KEY,
+    product_id bigint NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    name text NOT NULL,
+    option_values text[] NOT NULL,
+    position integer NOT NULL DEFAULT 0,
+    CONSTRAINT product_options_product_name_key UNIQUE (product_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS product_variants (
+    id bigserial PRIMARY KEY,
+    product_id bigint NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    sku text NOT NULL,
+    options jsonb NOT NULL DEFAULT '{}',
+    sale_price bigint,
+    stock integer NOT NULL DEFAULT 0,
+    CONSTRAINT product_variants_product_sku_key UNIQUE (product_id, sku),
+    CONSTRAINT product_variants_sale_price_check CHECK (sale_price > 0),
+    CONSTRAINT product_variants_stock_check CHECK (stock >= 0)
+);
+
+ALTER TABLE cart_items ADD COLUMN IF NOT EXISTS variant_id bigint REFERENCES product_variants(id) ON DELETE CASCADE;
+ALTER TABLE cart_items DROP CONSTRAINT IF EXISTS cart_items_cart_product_key;
+CREATE UNIQUE INDEX IF NOT EXISTS cart_items_cart_product_variant_key ON cart_items (cart_id, product_id, (COALESCE(variant_id, 0)));
+
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS variant_id bigint REFERENCES product_variants(id) ON DELETE SET NULL;
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS sku text NOT NULL DEFAULT '';
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS stock_reservations;
\ No newline at end of file
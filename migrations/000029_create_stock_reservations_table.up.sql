@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS stock_reservations (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    expires_at timestamp(0) with time zone NOT NULL,
+    order_id bigint NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    variant_id bigint NOT NULL REFERENCES product_variants(id) ON DELETE CASCADE,
+    quantity integer NOT NULL,
+    status text NOT NULL DEFAULT 'held',
+    CONSTRAINT stock_reservations_quantity_check CHECK (quantity > 0),
+    CONSTRAINT stock_reservations_status_check CHECK (status IN ('held', 'committed', 'released'))
+);
+
+CREATE INDEX IF NOT EXISTS stock_reservations_order_id_idx ON stock_reservations (order_id);
+CREATE INDEX IF NOT EXISTS stock_reservations_variant_id_idx ON stock_reservations (variant_id);
+CREATE INDEX IF NOT EXISTS stock_reservations_held_idx ON stock_reservations (expires_at) WHERE status = 'held';
+
+-- Stock was taken for the variant items of orders when they were placed, record it as
+-- reservations so it is released like the stock of new orders.
+INSERT INTO stock_reservations (created_at, expires_at, order_id, variant_id, quantity, status)
+SELECT orders.created_at, orders.created_at + INTERVAL '30 minutes', orders.id, order_items.variant_id,
+    order_items.quantity, CASE WHEN orders.status = 'pending_payment' THEN 'held' ELSE 'committed' END
+FROM order_items
+JOIN orders ON order_items.order_id = orders.id
+WHERE order_items.variant_id IS NOT NULL AND orders.status <> 'cancelled';
\ No newline at end of file
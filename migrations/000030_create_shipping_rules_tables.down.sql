@@ -0,0 +1,9 @@
+ALTER TABLE orders DROP COLUMN IF EXISTS shipping_city;
+ALTER TABLE orders DROP COLUMN IF EXISTS shipping_province;
+ALTER TABLE orders DROP COLUMN IF EXISTS shipping_cost;
+
+DROP TABLE IF EXISTS shipping_province_rates;
+
+DROP TABLE IF EXISTS shipping_rules;
+
+ALTER TABLE products DROP COLUMN IF EXISTS weight;
\ No newline at end of file
@@ -0,0 +1,25 @@
+ALTER TABLE products ADD COLUMN IF NOT EXISTS weight integer NOT NULL DEFAULT 0;
+ALTER TABLE products ADD CONSTRAINT products_weight_check CHECK (weight >= 0);
+
+CREATE TABLE IF NOT EXISTS shipping_rules (
+    shop_id bigint PRIMARY KEY REFERENCES shops(id) ON DELETE CASCADE,
+    flat_rate bigint NOT NULL DEFAULT 0,
+    per_kg_rate bigint NOT NULL DEFAULT 0,
+    free_threshold bigint,
+    version integer NOT NULL DEFAULT 1,
+    CONSTRAINT shipping_rules_rates_check CHECK (flat_rate >= 0 AND per_kg_rate >= 0),
+    CONSTRAINT shipping_rules_free_threshold_check CHECK (free_threshold > 0)
+);
+
+CREATE TABLE IF NOT EXISTS shipping_province_rates (
+    shop_id bigint NOT NULL REFERENCES shipping_rules(shop_id) ON DELETE CASCADE,
+    province text NOT NULL,
+    flat_rate bigint NOT NULL DEFAULT 0,
+    per_kg_rate bigint NOT NULL DEFAULT 0,
+    CONSTRAINT shipping_province_rates_pk PRIMARY KEY (shop_id, province),
+    CONSTRAINT shipping_province_rates_rates_check CHECK (flat_rate >= 0 AND per_kg_rate >= 0)
+);
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS shipping_cost bigint NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS shipping_province text NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS shipping_city text NOT NULL DEFAULT '';
\ No newline at end of file
@@ -0,0 +1,12 @@
+DROP TABLE IF EXISTS promotion_products;
+
+DROP TABLE IF EXISTS promotions;
+
+ALTER TABLE orders DROP COLUMN IF EXISTS coupon_code;
+ALTER TABLE orders DROP COLUMN IF EXISTS discount;
+
+ALTER TABLE carts DROP COLUMN IF EXISTS coupon_id;
+
+DROP TABLE IF EXISTS coupon_redemptions;
+
+DROP TABLE IF EXISTS coupons;
\ No newline at end of file
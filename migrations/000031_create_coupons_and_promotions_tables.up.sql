@@ -0,0 +1,72 @@
+CREATE TABLE IF NOT EXISTS coupons (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    shop_id bigint NOT NULL REFERENCES shops(id) ON DELETE CASCADE,
+    code text NOT NULL,
+    kind text NOT NULL,
+    value bigint NOT NULL,
+    min_order_total bigint NOT NULL DEFAULT 0,
+    max_uses integer,
+    max_uses_per_user integer,
+    uses integer NOT NULL DEFAULT 0,
+    starts_at timestamp(0) with time zone NOT NULL,
+    ends_at timestamp(0) with time zone NOT NULL,
+    scope text NOT NULL DEFAULT 'shop',
+    category_id bigint REFERENCES categories(id) ON DELETE CASCADE,
+    product_id bigint REFERENCES products(id) ON DELETE CASCADE,
+    CONSTRAINT coupons_kind_check CHECK (kind IN ('percentage', 'fixed')),
+    CONSTRAINT coupons_value_check CHECK (value > 0 AND (kind <> 'percentage' OR value <= 100)),
+    CONSTRAINT coupons_uses_check CHECK (uses >= 0 AND (max_uses IS NULL OR uses <= max_uses)),
+    CONSTRAINT coupons_window_check CHECK (starts_at < ends_at),
+    CONSTRAINT coupons_scope_check CHECK (scope IN ('shop', 'category', 'product')),
+    CONSTRAINT coupons_scope_target_check CHECK ((category_id IS NOT NULL) = (scope = 'category') AND (product_id IS NOT NULL) = (scope = 'product'))
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS coupons_code_key ON coupons (code);
+
+CREATE TABLE IF NOT EXISTS coupon_redemptions (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    coupon_id bigint NOT NULL REFERENCES coupons(id) ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    order_id bigint NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    discount bigint NOT NULL,
+    CONSTRAINT coupon_redemptions_order_key UNIQUE (order_id)
+);
+
+CREATE INDEX IF NOT EXISTS coupon_redemptions_coupon_user_idx ON coupon_redemptions (coupon_id, user_id);
+
+ALTER TABLE carts ADD COLUMN IF NOT EXISTS coupon_id bigint REFERENCES coupons(id) ON DELETE SET NULL;
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS discount bigint NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS coupon_code text NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS promotions (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    shop_id bigint NOT NULL REFERENCES shops(id) ON DELETE CASCADE,
+    off integer NOT NULL,
+    starts_at timestamp(0) with time zone NOT NULL,
+    ends_at timestamp(0) with time zone NOT NULL,
+    scope text NOT NULL DEFAULT 'shop',
+    category_id bigint REFERENCES categories(id) ON DELETE CASCADE,
+    product_id bigint REFERENCES products(id) ON DELETE CASCADE,
+    status text NOT NULL DEFAULT 'scheduled',
+    CONSTRAINT promotions_off_check CHECK (off > 0 AND off < 100),
+    CONSTRAINT promotions_window_check CHECK (starts_at < ends_at),
+    CONSTRAINT promotions_scope_check CHECK (scope IN ('shop', 'category', 'product')),
+    CONSTRAINT promotions_scope_target_check CHECK ((category_id IS NOT NULL) = (scope = 'category') AND (product_id IS NOT NULL) = (scope = 'product')),
+    CONSTRAINT promotions_status_check CHECK (status IN ('scheduled', 'active', 'ended'))
+);
+
+CREATE INDEX IF NOT EXISTS promotions_shop_id_idx ON promotions (shop_id);
+
+-- The off a product had before a promotion changed it, so it can be put back when the
+-- promotion ends. A product is only in one active promotion at a time.
+CREATE TABLE IF NOT EXISTS promotion_products (
+    promotion_id bigint NOT NULL REFERENCES promotions(id) ON DELETE CASCADE,
+    product_id bigint NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    previous_off integer NOT NULL,
+    CONSTRAINT promotion_products_pk PRIMARY KEY (promotion_id, product_id),
+    CONSTRAINT promotion_products_product_key UNIQUE (product_id)
+);
\ No newline at end of file
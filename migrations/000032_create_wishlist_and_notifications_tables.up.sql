@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS wishlist_items (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    product_id bigint NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    variant_id bigint REFERENCES product_variants(id) ON DELETE CASCADE,
+    last_price bigint NOT NULL,
+    last_in_stock boolean NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS wishlist_items_user_product_variant_key ON wishlist_items (user_id, product_id, (COALESCE(variant_id, 0)));
+
+CREATE TABLE IF NOT EXISTS notifications (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    kind text NOT NULL,
+    product_id bigint REFERENCES products(id) ON DELETE SET NULL,
+    message text NOT NULL,
+    attempts integer NOT NULL DEFAULT 0,
+    sent_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS notifications_unsent_idx ON notifications (id) WHERE sent_at IS NULL;
\ No newline at end of file
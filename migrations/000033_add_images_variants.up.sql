@@ -0,0 +1 @@
+ALTER TABLE images ADD COLUMN IF NOT EXISTS variants jsonb NOT NULL DEFAULT '{}';
\ No newline at end of file
@@ -0,0 +1,19 @@
+DROP INDEX IF EXISTS images_pending_idx;
+
+DELETE FROM images WHERE product_id IS NULL AND shop_id IS NULL;
+
+ALTER TABLE images DROP CONSTRAINT IF EXISTS images_shop_id_fkey;
+ALTER TABLE images ADD CONSTRAINT images_shop_id_fkey FOREIGN KEY (shop_id) REFERENCES shops(id) ON DELETE CASCADE;
+
+ALTER TABLE images DROP CONSTRAINT IF EXISTS images_product_id_fkey;
+ALTER TABLE images ADD CONSTRAINT images_product_id_fkey FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE;
+
+ALTER TABLE images DROP CONSTRAINT IF EXISTS images_shop_product_id;
+ALTER TABLE images ADD CONSTRAINT images_shop_product_id CHECK((product_id IS NULL) <> (shop_id IS NULL));
+
+ALTER TABLE images
+    DROP COLUMN IF EXISTS position,
+    DROP COLUMN IF EXISTS storage_keys,
+    DROP COLUMN IF EXISTS user_id,
+    DROP COLUMN IF EXISTS updated_at,
+    DROP COLUMN IF EXISTS created_at;
\ No newline at end of file
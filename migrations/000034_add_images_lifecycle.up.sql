@@ -0,0 +1,26 @@
+ALTER TABLE images
+    ADD COLUMN IF NOT EXISTS created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    ADD COLUMN IF NOT EXISTS updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    ADD COLUMN IF NOT EXISTS user_id bigint REFERENCES users(id) ON DELETE SET NULL,
+    ADD COLUMN IF NOT EXISTS storage_keys text[] NOT NULL DEFAULT '{}',
+    ADD COLUMN IF NOT EXISTS position integer NOT NULL DEFAULT 0;
+
+-- Images without a product or a shop are pending. Deleting their product or shop
+-- makes them pending again, so the sweeper deletes their files too.
+ALTER TABLE images DROP CONSTRAINT IF EXISTS images_shop_product_id;
+ALTER TABLE images ADD CONSTRAINT images_shop_product_id CHECK(product_id IS NULL OR shop_id IS NULL);
+
+ALTER TABLE images DROP CONSTRAINT IF EXISTS images_product_id_fkey;
+ALTER TABLE images ADD CONSTRAINT images_product_id_fkey FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE SET NULL;
+
+ALTER TABLE images DROP CONSTRAINT IF EXISTS images_shop_id_fkey;
+ALTER TABLE images ADD CONSTRAINT images_shop_id_fkey FOREIGN KEY (shop_id) REFERENCES shops(id) ON DELETE SET NULL;
+
+UPDATE images SET position = ranked.position
+FROM (
+    SELECT id, ROW_NUMBER() OVER (PARTITION BY product_id, shop_id ORDER BY id) - 1 AS position
+    FROM images
+) AS ranked
+WHERE images.id = ranked.id;
+
+CREATE INDEX IF NOT EXISTS images_pending_idx ON images (updated_at) WHERE product_id IS NULL AND shop_id IS NULL;
\ No newline at end of file
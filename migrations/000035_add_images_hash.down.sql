@@ -0,0 +1,11 @@
+DROP INDEX IF EXISTS images_storage_keys_idx;
+
+DROP INDEX IF EXISTS images_hash_idx;
+
+DROP INDEX IF EXISTS images_url_idx;
+
+ALTER TABLE images DROP COLUMN IF EXISTS hash;
+
+DELETE FROM images a USING images b WHERE a.url = b.url AND a.id > b.id;
+
+ALTER TABLE images ADD CONSTRAINT images_url_key UNIQUE (url);
\ No newline at end of file
@@ -0,0 +1,9 @@
+ALTER TABLE images DROP CONSTRAINT IF EXISTS images_url_key;
+
+ALTER TABLE images ADD COLUMN IF NOT EXISTS hash text;
+
+CREATE INDEX IF NOT EXISTS images_url_idx ON images (url);
+
+CREATE INDEX IF NOT EXISTS images_hash_idx ON images (hash);
+
+CREATE INDEX IF NOT EXISTS images_storage_keys_idx ON images USING GIN (storage_keys);
\ No newline at end of file
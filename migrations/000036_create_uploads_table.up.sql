@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS uploads (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    filename text NOT NULL,
+    size bigint NOT NULL,
+    received bigint NOT NULL DEFAULT 0,
+    image_id bigint REFERENCES images(id) ON DELETE SET NULL,
+    CONSTRAINT uploads_received_check CHECK (received >= 0 AND received <= size)
+);
+
+CREATE INDEX IF NOT EXISTS uploads_created_at_idx ON uploads (created_at);
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE one_time_codes DROP COLUMN IF EXISTS window_start;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE one_time_codes ADD COLUMN IF NOT EXISTS window_start timestamp(0) with time zone NOT NULL DEFAULT NOW();
\ No newline at end of file
@@ -0,0 +1,6 @@
+ALTER TABLE ledger_entries DROP CONSTRAINT IF EXISTS ledger_entries_account_check;
+ALTER TABLE ledger_entries ADD CONSTRAINT ledger_entries_account_check CHECK (account IN ('escrow', 'commission', 'seller', 'payouts', 'bank')) NOT VALID;
+
+DROP INDEX IF EXISTS ledger_entries_payment_account_idx;
+
+ALTER TABLE ledger_entries DROP COLUMN IF EXISTS payment_id;
\ No newline at end of file
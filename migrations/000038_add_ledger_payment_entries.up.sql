@@ -0,0 +1,20 @@
+ALTER TABLE ledger_entries ADD COLUMN IF NOT EXISTS payment_id bigint REFERENCES payments(id) ON DELETE RESTRICT;
+
+ALTER TABLE ledger_entries DROP CONSTRAINT IF EXISTS ledger_entries_account_check;
+ALTER TABLE ledger_entries ADD CONSTRAINT ledger_entries_account_check CHECK (account IN ('gateway', 'escrow', 'commission', 'seller', 'payouts', 'bank'));
+
+-- A payment is only ever recorded once.
+CREATE UNIQUE INDEX IF NOT EXISTS ledger_entries_payment_account_idx ON ledger_entries (payment_id, account) WHERE payment_id IS NOT NULL;
+
+-- Record the payments which were verified before payments were entered in the ledger,
+-- so escrow balances.
+WITH paid AS (
+    SELECT id, order_id, amount, nextval('ledger_transaction_id_seq') AS transaction_id
+    FROM payments
+    WHERE status = 'paid'
+    AND NOT EXISTS (SELECT 1 FROM ledger_entries WHERE ledger_entries.payment_id = payments.id)
+)
+INSERT INTO ledger_entries (transaction_id, account, payment_id, amount, description)
+SELECT transaction_id, 'gateway', id, -amount, format('order %s paid', order_id) FROM paid
+UNION ALL
+SELECT transaction_id, 'escrow', id, amount, format('order %s paid', order_id) FROM paid;
\ No newline at end of file
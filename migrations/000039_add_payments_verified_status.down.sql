@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS payments_verified_idx;
+
+-- Payments which were verified but not settled keep their ref_id, so they can still be
+-- refunded.
+UPDATE payments SET status = 'failed' WHERE status IN ('verified', 'refund_due');
+
+ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_status_check;
+ALTER TABLE payments ADD CONSTRAINT payments_status_check CHECK (status IN ('pending', 'paid', 'failed'));
\ No newline at end of file
@@ -0,0 +1,4 @@
+ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_status_check;
+ALTER TABLE payments ADD CONSTRAINT payments_status_check CHECK (status IN ('pending', 'verified', 'paid', 'refund_due', 'failed'));
+
+CREATE INDEX IF NOT EXISTS payments_verified_idx ON payments (created_at) WHERE status = 'verified';
\ No newline at end of file
@@ -0,0 +1,7 @@
+ALTER TABLE promotions DROP CONSTRAINT IF EXISTS promotions_category_id_fkey;
+ALTER TABLE promotions ADD CONSTRAINT promotions_category_id_fkey
+    FOREIGN KEY (category_id) REFERENCES categories(id) ON DELETE CASCADE;
+
+ALTER TABLE coupons DROP CONSTRAINT IF EXISTS coupons_category_id_fkey;
+ALTER TABLE coupons ADD CONSTRAINT coupons_category_id_fkey
+    FOREIGN KEY (category_id) REFERENCES categories(id) ON DELETE CASCADE;
\ No newline at end of file